@@ -0,0 +1,260 @@
+// Package api is the versioned json api of the core features, it is served
+// under /api/v1/ next to the apis of the plugins under /api/{pluginname}/.
+//
+// Requests authenticate with a session cookie or with a personal api token
+// in the Authorization header, e.g. "Authorization: Bearer hm_...". Requests
+// that change something with a session cookie need the X-CSRF-Token header.
+//
+// Successful responses wrap their result in an envelope:
+//
+//	{"data": {...}}
+//
+// Lists are paginated with the page and per_page query parameters, per_page
+// is at most 100. Their envelope describes the page:
+//
+//	{"data": [...], "meta": {"page": 1, "per_page": 20, "total": 42, "pages": 3}}
+//
+// Errors are problem documents as described in RFC 7807, served as
+// application/problem+json:
+//
+//	{"type": "about:blank", "title": "Not Found", "status": 404,
+//	 "detail": "...", "instance": "/api/v1/...", "request_id": "..."}
+//
+// The endpoints are:
+//
+//	GET    /api/v1/plugins/                     list the plugins
+//	GET    /api/v1/plugins/{plugin}/            a single plugin
+//	POST   /api/v1/plugins/{plugin}/enable/     enable a plugin (settings.manage)
+//	POST   /api/v1/plugins/{plugin}/disable/    disable a plugin (settings.manage)
+//	POST   /api/v1/plugins/{plugin}/run/        start a run (plugins.run)
+//	GET    /api/v1/plugins/{plugin}/runs/       the run history, newest first
+//	GET    /api/v1/jobs/{job}/                  a running or finished job
+//	POST   /api/v1/jobs/{job}/cancel/           cancel a job (plugins.run)
+//	GET    /api/v1/stats/                       statistics of the server
+//	GET    /api/v1/database/                    status of the database and tables (database.manage)
+//	GET    /api/v1/logs/                        the application log, newest first (logs.view)
+//	GET    /api/v1/settings/                    the settings of every plugin (settings.manage)
+//	PUT    /api/v1/settings/{plugin}/{key}/     change a setting (settings.manage)
+//	DELETE /api/v1/settings/{plugin}/{key}/     reset a setting to its default (settings.manage)
+//
+// The server describes these and the apis of the plugins in an OpenAPI
+// document at /api/openapi.json and on the page /apidocs/.
+//
+// Live updates are server-sent events on /events/?topic=..., the topics are
+// stats, plugins (the state of every job), logs (logs.view) and the topics
+// of the plugins such as torrentplugin.torrents.
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/nielsvanm/homemanager/auth"
+	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/plugin"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// Prefix is the url every endpoint of this version of the api starts with
+const Prefix = "/api/v1"
+
+// Pagination limits
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// Endpoints of the api, they are registered by main
+var Endpoints = []*frame.Endpoint{
+	paginated(frame.NewEndpoint(Prefix+"/plugins/", frame.Handle(PluginsView))).
+		WithPermission(auth.PermViewPlugins).
+		WithDoc("List the plugins", "").
+		WithResponse(http.StatusOK, listOf(pluginJSON{})),
+	frame.NewEndpoint(Prefix+"/plugins/{pluginname}/", frame.Handle(PluginView)).
+		WithPermission(auth.PermViewPlugins).
+		WithDoc("Get a plugin", "").
+		WithResponse(http.StatusOK, dataOf(pluginJSON{})),
+	frame.NewEndpoint(Prefix+"/plugins/{pluginname}/enable/", frame.Handle(EnablePluginView)).
+		WithMethods(http.MethodPost).
+		WithPermission(auth.PermManageSettings).
+		WithDoc("Enable a plugin", "The plugin stays enabled after a restart.").
+		WithResponse(http.StatusOK, dataOf(pluginJSON{})),
+	frame.NewEndpoint(Prefix+"/plugins/{pluginname}/disable/", frame.Handle(DisablePluginView)).
+		WithMethods(http.MethodPost).
+		WithPermission(auth.PermManageSettings).
+		WithDoc("Disable a plugin", "A run that is in progress finishes, the plugin stays disabled after a restart.").
+		WithResponse(http.StatusOK, dataOf(pluginJSON{})),
+	frame.NewEndpoint(Prefix+"/plugins/{pluginname}/run/", frame.Handle(RunPluginView)).
+		WithMethods(http.MethodPost).
+		WithPermission(auth.PermRunPlugins).
+		WithDoc("Run a plugin", "Starts a run in the background, when the plugin is already running the running job is returned. The Location header points at the job.").
+		WithResponse(http.StatusAccepted, dataOf(plugin.JobInfo{})),
+	paginated(frame.NewEndpoint(Prefix+"/plugins/{pluginname}/runs/", frame.Handle(RunHistoryView))).
+		WithPermission(auth.PermViewPlugins).
+		WithDoc("List the finished runs of a plugin", "Newest first.").
+		WithResponse(http.StatusOK, listOf(runJSON{})),
+	frame.NewEndpoint(Prefix+"/jobs/{jobid}/", frame.Handle(JobView)).
+		WithPermission(auth.PermViewPlugins).
+		WithDoc("Get a job", "Jobs are kept in memory until the server restarts.").
+		WithResponse(http.StatusOK, dataOf(plugin.JobInfo{})),
+	frame.NewEndpoint(Prefix+"/jobs/{jobid}/cancel/", frame.Handle(CancelJobView)).
+		WithMethods(http.MethodPost).
+		WithPermission(auth.PermRunPlugins).
+		WithDoc("Cancel a job", "The job is done once the plugin returns.").
+		WithResponse(http.StatusAccepted, dataOf(plugin.JobInfo{})),
+	frame.NewEndpoint(Prefix+"/stats/", frame.Handle(StatsView)).
+		WithDoc("Get the statistics of the server", "Sizes are in bytes.").
+		WithResponse(http.StatusOK, dataOf(statsJSON{})),
+	frame.NewEndpoint(Prefix+"/database/", frame.Handle(DatabaseView)).
+		WithPermission(auth.PermManageDatabase).
+		WithDoc("Get the status of the database", "Row counts are estimates that postgres updates when it analyzes a table.").
+		WithResponse(http.StatusOK, dataOf(databaseJSON{})),
+	paginated(frame.NewEndpoint(Prefix+"/logs/", frame.Handle(LogsView))).
+		WithPermission(auth.PermViewLogs).
+		WithDoc("List the entries of the application log", "Newest first, only the end of the log file is read.").
+		WithQuery("level", "Only entries of the level, INFO, WARNING, ERROR or FATAL").
+		WithQuery("module", "Only entries of the module").
+		WithResponse(http.StatusOK, listOf(logJSON{})),
+	paginated(frame.NewEndpoint(Prefix+"/settings/", frame.Handle(SettingsView))).
+		WithPermission(auth.PermManageSettings).
+		WithDoc("List the settings of the plugins", "").
+		WithQuery("plugin", "Only the settings of the plugin").
+		WithResponse(http.StatusOK, listOf(settingJSON{})),
+	frame.NewEndpoint(Prefix+"/settings/{pluginname}/{key}/", frame.Handle(SettingView)).
+		WithMethods(http.MethodPut, http.MethodDelete).
+		WithPermission(auth.PermManageSettings).
+		WithDoc("Change or reset a setting", "PUT changes the value, DELETE resets it to the default.").
+		WithRequest(settingBody{}).
+		WithResponse(http.StatusOK, dataOf(settingJSON{})),
+}
+
+// paginated documents the pagination query parameters of a list endpoint
+func paginated(endp *frame.Endpoint) *frame.Endpoint {
+	return endp.
+		WithQuery("page", "The page to return, starting at 1").
+		WithQuery("per_page", "The amount of items per page, at most "+strconv.Itoa(maxPerPage))
+}
+
+// dataOf describes the envelope of a response with data like v
+func dataOf(v interface{}) frame.Schema {
+	return frame.Schema{
+		"type":       "object",
+		"properties": frame.Schema{"data": frame.SchemaOf(v)},
+	}
+}
+
+// listOf describes the envelope of a page of items like v
+func listOf(v interface{}) frame.Schema {
+	return frame.Schema{
+		"type": "object",
+		"properties": frame.Schema{
+			"data": frame.Schema{"type": "array", "items": frame.SchemaOf(v)},
+			"meta": frame.SchemaOf(Meta{}),
+		},
+	}
+}
+
+// envelope wraps every successful response
+type envelope struct {
+	Data interface{} `json:"data"`
+	Meta *Meta       `json:"meta,omitempty"`
+}
+
+// Meta describes the page of a paginated list
+type Meta struct {
+	Page    int `json:"page"`
+	PerPage int `json:"per_page"`
+	Total   int `json:"total"`
+	Pages   int `json:"pages"`
+}
+
+// Page is the part of a list that is requested
+type Page struct {
+	Number  int
+	PerPage int
+}
+
+// GetPage reads the page and per_page query parameters, they default to the
+// first page of 20 items
+func GetPage(r *http.Request) (Page, error) {
+	page := Page{1, defaultPerPage}
+
+	if value := r.URL.Query().Get("page"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 {
+			return page, frame.BadRequest("page should be a number of at least 1", err)
+		}
+		page.Number = number
+	}
+
+	if value := r.URL.Query().Get("per_page"); value != "" {
+		perPage, err := strconv.Atoi(value)
+		if err != nil || perPage < 1 || perPage > maxPerPage {
+			return page, frame.BadRequest("per_page should be a number from 1 to "+strconv.Itoa(maxPerPage), err)
+		}
+		page.PerPage = perPage
+	}
+
+	return page, nil
+}
+
+// Offset returns the amount of items before the page
+func (p Page) Offset() int {
+	return (p.Number - 1) * p.PerPage
+}
+
+// Meta returns the description of the page for a list of total items
+func (p Page) Meta(total int) *Meta {
+	pages := (total + p.PerPage - 1) / p.PerPage
+
+	return &Meta{p.Number, p.PerPage, total, pages}
+}
+
+// Slice returns the start and end of the page in a list of total items
+func (p Page) Slice(total int) (int, int) {
+	start := p.Offset()
+	if start > total {
+		start = total
+	}
+	end := start + p.PerPage
+	if end > total {
+		end = total
+	}
+
+	return start, end
+}
+
+// WriteData responds with the data in an envelope
+func WriteData(w http.ResponseWriter, status int, data interface{}) {
+	write(w, status, envelope{data, nil})
+}
+
+// WriteList responds with a page of a list in an envelope
+func WriteList(w http.ResponseWriter, data interface{}, page Page, total int) {
+	write(w, http.StatusOK, envelope{data, page.Meta(total)})
+}
+
+func write(w http.ResponseWriter, status int, env envelope) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(env)
+	if err != nil {
+		log.Warn("API", "Failed to write response", err.Error())
+	}
+}
+
+// decodeBody reads the json body of the request into v
+func decodeBody(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err != nil {
+		return frame.BadRequest("The body should be a json object", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,261 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nielsvanm/homemanager/api"
+	"github.com/nielsvanm/homemanager/database"
+	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/middleware"
+	"github.com/nielsvanm/homemanager/plugin"
+	"github.com/nielsvanm/homemanager/plugin/plugintest"
+)
+
+// response is the envelope of a successful response
+type response struct {
+	Data json.RawMessage `json:"data"`
+	Meta *api.Meta       `json:"meta"`
+}
+
+// problem is the body of an error response
+type problem struct {
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail"`
+	Instance  string `json:"instance"`
+	RequestID string `json:"request_id"`
+}
+
+// setup serves the api for plugins with the names, the first one is set up
+// by the plugintest harness and every plugin is known to the manager
+func setup(t *testing.T, names ...string) *plugintest.Harness {
+	plugins := []*plugin.Plugin{}
+	for _, name := range names {
+		plugins = append(plugins, &plugin.Plugin{
+			Name:     name,
+			Category: "Test",
+			Main:     func(ctx context.Context) []database.BatchQuery { return nil },
+		})
+	}
+
+	h := plugintest.New(t, plugins[0])
+	plugins[0] = h.Plugin
+
+	previousPlugins, previousDB := plugin.PluginManager.Plugins, plugin.PluginManager.DB
+	plugin.PluginManager.Plugins, plugin.PluginManager.DB = plugins, h.DB
+	t.Cleanup(func() {
+		// Runs that were started store their history before the database goes
+		plugin.PluginManager.Shutdown(time.Minute)
+		plugin.PluginManager.Plugins, plugin.PluginManager.DB = previousPlugins, previousDB
+	})
+
+	server := frame.NewWebServer()
+	server.Use(middleware.RequestID, middleware.Recover)
+	server.AddEndpoints(api.Endpoints)
+	h.Handler = server.Handler()
+
+	return h
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("expected a json body, got %q: %s", rec.Body.String(), err.Error())
+	}
+}
+
+func TestPageSlice(t *testing.T) {
+	tests := []struct {
+		page  api.Page
+		total int
+		start int
+		end   int
+	}{
+		{api.Page{Number: 1, PerPage: 20}, 0, 0, 0},
+		{api.Page{Number: 1, PerPage: 20}, 5, 0, 5},
+		{api.Page{Number: 2, PerPage: 2}, 5, 2, 4},
+		{api.Page{Number: 3, PerPage: 2}, 5, 4, 5},
+		{api.Page{Number: 4, PerPage: 2}, 5, 5, 5},
+		{api.Page{Number: 100, PerPage: 100}, 5, 5, 5},
+	}
+
+	for _, test := range tests {
+		start, end := test.page.Slice(test.total)
+		if start != test.start || end != test.end {
+			t.Errorf("%+v of %d: expected %d:%d, got %d:%d", test.page, test.total, test.start, test.end, start, end)
+		}
+	}
+}
+
+func TestGetPage(t *testing.T) {
+	tests := []struct {
+		query string
+		page  api.Page
+		valid bool
+	}{
+		{"", api.Page{Number: 1, PerPage: 20}, true},
+		{"page=3&per_page=1", api.Page{Number: 3, PerPage: 1}, true},
+		{"per_page=100", api.Page{Number: 1, PerPage: 100}, true},
+		{"per_page=101", api.Page{}, false},
+		{"per_page=0", api.Page{}, false},
+		{"per_page=ten", api.Page{}, false},
+		{"page=0", api.Page{}, false},
+		{"page=-1", api.Page{}, false},
+	}
+
+	for _, test := range tests {
+		page, err := api.GetPage(httptest.NewRequest(http.MethodGet, "/api/v1/plugins/?"+test.query, nil))
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("%q: expected valid %v, got error %v", test.query, test.valid, err)
+			continue
+		}
+		if test.valid && page != test.page {
+			t.Errorf("%q: expected %+v, got %+v", test.query, test.page, page)
+		}
+	}
+}
+
+func TestPluginsPagination(t *testing.T) {
+	h := setup(t, "First", "Second", "Third")
+
+	tests := []struct {
+		query string
+		names []string
+		meta  api.Meta
+	}{
+		{"", []string{"First", "Second", "Third"}, api.Meta{Page: 1, PerPage: 20, Total: 3, Pages: 1}},
+		{"?per_page=2", []string{"First", "Second"}, api.Meta{Page: 1, PerPage: 2, Total: 3, Pages: 2}},
+		{"?per_page=2&page=2", []string{"Third"}, api.Meta{Page: 2, PerPage: 2, Total: 3, Pages: 2}},
+		{"?per_page=2&page=5", []string{}, api.Meta{Page: 5, PerPage: 2, Total: 3, Pages: 2}},
+	}
+
+	for _, test := range tests {
+		rec := h.Get("/api/v1/plugins/" + test.query)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%q: expected a json response, got %d %s", test.query, rec.Code, rec.Header().Get("Content-Type"))
+			continue
+		}
+
+		var body response
+		decode(t, rec, &body)
+		var plugins []struct {
+			Name    string `json:"name"`
+			Enabled bool   `json:"enabled"`
+		}
+		json.Unmarshal(body.Data, &plugins)
+
+		names := []string{}
+		for _, p := range plugins {
+			names = append(names, p.Name)
+		}
+		if len(names) != len(test.names) || (len(names) > 0 && names[0] != test.names[0]) {
+			t.Errorf("%q: expected %v, got %v", test.query, test.names, names)
+		}
+		if body.Meta == nil || *body.Meta != test.meta {
+			t.Errorf("%q: expected meta %+v, got %+v", test.query, test.meta, body.Meta)
+		}
+	}
+}
+
+func TestProblemResponses(t *testing.T) {
+	h := setup(t, "Enabled", "Disabled")
+	plugin.PluginManager.Plugins[1].Disable()
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/api/v1/plugins/missing/", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/plugins/missing/run/", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/plugins/disabled/run/", http.StatusConflict},
+		{http.MethodGet, "/api/v1/plugins/?per_page=500", http.StatusBadRequest},
+		{http.MethodGet, "/api/v1/jobs/seven/", http.StatusBadRequest},
+		{http.MethodGet, "/api/v1/jobs/999999/", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, nil)
+		r.Header.Set(middleware.RequestIDHeader, "req-1")
+		rec := h.Do(r)
+
+		if rec.Code != test.status || rec.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("%s %s: expected a %d problem, got %d %s", test.method, test.path, test.status, rec.Code, rec.Header().Get("Content-Type"))
+			continue
+		}
+
+		var body problem
+		decode(t, rec, &body)
+		if body.Status != test.status || body.Title != http.StatusText(test.status) || body.Detail == "" || body.RequestID != "req-1" {
+			t.Errorf("%s %s: expected a complete problem, got %+v", test.method, test.path, body)
+		}
+	}
+}
+
+func TestEnableAndDisablePersist(t *testing.T) {
+	h := setup(t, "Test")
+
+	tests := []struct {
+		path     string
+		enabled  bool
+		disabled bool
+	}{
+		{"/api/v1/plugins/test/disable/", false, true},
+		{"/api/v1/plugins/test/enable/", true, false},
+	}
+
+	for _, test := range tests {
+		h.FakeDB.Reset()
+		rec := h.Request(http.MethodPost, test.path, nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", test.path, rec.Code)
+			continue
+		}
+
+		var body response
+		decode(t, rec, &body)
+		var p struct {
+			Enabled bool `json:"enabled"`
+		}
+		json.Unmarshal(body.Data, &p)
+		if p.Enabled != test.enabled || h.Plugin.IsEnabled() != test.enabled {
+			t.Errorf("%s: expected enabled %v, got %v in the response and %v on the plugin", test.path, test.enabled, p.Enabled, h.Plugin.IsEnabled())
+		}
+
+		execs := h.FakeDB.Execs()
+		if len(execs) != 1 || execs[0].Args[0] != "Test" || execs[0].Args[1] != test.disabled {
+			t.Errorf("%s: expected the state to be stored, got %v", test.path, execs)
+		}
+	}
+}
+
+func TestRunPlugin(t *testing.T) {
+	h := setup(t, "Test")
+
+	rec := h.Request(http.MethodPost, "/api/v1/plugins/test/run/", nil)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected the run to be accepted, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	var body response
+	decode(t, rec, &body)
+	var job plugin.JobInfo
+	json.Unmarshal(body.Data, &job)
+	if job.Plugin != "Test" || rec.Header().Get("Location") == "" {
+		t.Fatalf("expected the job and its location, got %+v at %q", job, rec.Header().Get("Location"))
+	}
+
+	plugin.PluginManager.GetJob(job.ID).Wait()
+	rec = h.Get(rec.Header().Get("Location"))
+	decode(t, rec, &body)
+	json.Unmarshal(body.Data, &job)
+	if rec.Code != http.StatusOK || job.Status != plugin.JobFinished {
+		t.Errorf("expected the job to be finished, got %d %+v", rec.Code, job)
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// maxLogBytes is how much of the end of the log file is read, older entries
+// are not available through the api
+const maxLogBytes = 2 << 20
+
+// logLine matches the first line of an entry, e.g. "[Module/LEVEL] message"
+var logLine = regexp.MustCompile(`^\[([^/\]]+)/([A-Z]+)\] ?(.*)$`)
+
+// logJSON is an entry of the log, messages can span multiple lines
+type logJSON struct {
+	Module  string `json:"module"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// LogsView lists the entries of the log, newest first. They can be filtered
+// with the level and module query parameters
+func LogsView(w http.ResponseWriter, r *http.Request) error {
+	page, err := GetPage(r)
+	if err != nil {
+		return err
+	}
+
+	level := strings.ToUpper(r.URL.Query().Get("level"))
+	switch level {
+	case "", log.Information, log.Warning, log.Error, log.Fatality:
+	default:
+		return frame.BadRequest("level should be one of "+strings.Join([]string{log.Information, log.Warning, log.Error, log.Fatality}, ", "), nil)
+	}
+	module := r.URL.Query().Get("module")
+
+	entries, err := readLog()
+	if err != nil {
+		return frame.InternalError(err)
+	}
+
+	filtered := []logJSON{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if level != "" && entry.Level != level {
+			continue
+		}
+		if module != "" && !strings.EqualFold(entry.Module, module) {
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+
+	start, end := page.Slice(len(filtered))
+	WriteList(w, filtered[start:end], page, len(filtered))
+	return nil
+}
+
+// readLog parses the end of the log file, oldest entry first
+func readLog() ([]logJSON, error) {
+	f, err := os.Open(log.File)
+	if os.IsNotExist(err) {
+		return []logJSON{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	partial := info.Size() > maxLogBytes
+	if partial {
+		_, err = f.Seek(-maxLogBytes, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if partial && len(lines) > 0 {
+		// The first line is cut off somewhere in the middle
+		lines = lines[1:]
+	}
+
+	entries := []logJSON{}
+	for _, line := range lines {
+		match := logLine.FindStringSubmatch(line)
+		if match == nil {
+			// Stack traces and other messages with newlines continue the
+			// previous entry
+			if len(entries) > 0 {
+				entries[len(entries)-1].Message += "\n" + line
+			}
+			continue
+		}
+
+		entries = append(entries, logJSON{match[1], match[2], match[3]})
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,216 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/middleware"
+	"github.com/nielsvanm/homemanager/plugin"
+)
+
+// pluginJSON describes a plugin
+type pluginJSON struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Category    string          `json:"category"`
+	Enabled     bool            `json:"enabled"`
+	Tables      []string        `json:"tables"`
+	Running     *plugin.JobInfo `json:"running"`
+	LastRun     *runJSON        `json:"last_run"`
+}
+
+// runJSON is a run from the run history
+type runJSON struct {
+	ID       int       `json:"id"`
+	Status   string    `json:"status"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Summary  string    `json:"summary"`
+}
+
+// PluginsView lists the plugins
+func PluginsView(w http.ResponseWriter, r *http.Request) error {
+	page, err := GetPage(r)
+	if err != nil {
+		return err
+	}
+
+	plugins := plugin.PluginManager.Plugins
+	start, end := page.Slice(len(plugins))
+
+	list := []pluginJSON{}
+	for _, p := range plugins[start:end] {
+		list = append(list, newPluginJSON(p))
+	}
+
+	WriteList(w, list, page, len(plugins))
+	return nil
+}
+
+// PluginView describes a single plugin
+func PluginView(w http.ResponseWriter, r *http.Request) error {
+	p, err := getPlugin(r)
+	if err != nil {
+		return err
+	}
+
+	WriteData(w, http.StatusOK, newPluginJSON(p))
+	return nil
+}
+
+// EnablePluginView enables a plugin
+func EnablePluginView(w http.ResponseWriter, r *http.Request) error {
+	return setEnabled(w, r, true)
+}
+
+// DisablePluginView disables a plugin, a run that is in progress finishes
+func DisablePluginView(w http.ResponseWriter, r *http.Request) error {
+	return setEnabled(w, r, false)
+}
+
+func setEnabled(w http.ResponseWriter, r *http.Request, enabled bool) error {
+	p, err := getPlugin(r)
+	if err != nil {
+		return err
+	}
+
+	err = plugin.PluginManager.SetEnabled(p, enabled)
+	if err != nil {
+		return frame.InternalError(err)
+	}
+
+	WriteData(w, http.StatusOK, newPluginJSON(p))
+	return nil
+}
+
+// RunPluginView starts a run of the plugin in the background, when the
+// plugin is already running the running job is returned
+func RunPluginView(w http.ResponseWriter, r *http.Request) error {
+	p, err := getPlugin(r)
+	if err != nil {
+		return err
+	}
+	if !p.IsEnabled() {
+		return frame.NewHTTPError(http.StatusConflict, "The plugin "+p.Name+" is disabled", nil)
+	}
+
+	// The run should outlive this request
+	job := plugin.PluginManager.StartBackgroundRun(p)
+
+	w.Header().Set("Location", middleware.URL(Prefix+"/jobs/"+strconv.Itoa(job.ID)+"/"))
+	WriteData(w, http.StatusAccepted, job.Info())
+	return nil
+}
+
+// RunHistoryView lists the finished runs of the plugin, newest first
+func RunHistoryView(w http.ResponseWriter, r *http.Request) error {
+	p, err := getPlugin(r)
+	if err != nil {
+		return err
+	}
+
+	page, err := GetPage(r)
+	if err != nil {
+		return err
+	}
+
+	records, total := plugin.PluginManager.GetRunHistoryPage(p.Name, page.PerPage, page.Offset())
+
+	runs := []runJSON{}
+	for _, record := range records {
+		runs = append(runs, newRunJSON(record))
+	}
+
+	WriteList(w, runs, page, total)
+	return nil
+}
+
+// JobView describes a running or finished job
+func JobView(w http.ResponseWriter, r *http.Request) error {
+	job, err := getJob(r)
+	if err != nil {
+		return err
+	}
+
+	WriteData(w, http.StatusOK, job.Info())
+	return nil
+}
+
+// CancelJobView cancels a running job, the job is done once the plugin
+// returns
+func CancelJobView(w http.ResponseWriter, r *http.Request) error {
+	job, err := getJob(r)
+	if err != nil {
+		return err
+	}
+
+	job.Cancel()
+
+	WriteData(w, http.StatusAccepted, job.Info())
+	return nil
+}
+
+// getPlugin returns the plugin referenced by the pluginname url variable
+func getPlugin(r *http.Request) (*plugin.Plugin, error) {
+	name := mux.Vars(r)["pluginname"]
+
+	p := plugin.PluginManager.GetPlugin(name)
+	if p == nil {
+		return nil, frame.NotFound("There is no plugin called " + name)
+	}
+
+	return p, nil
+}
+
+// getJob returns the job referenced by the jobid url variable
+func getJob(r *http.Request) (*plugin.Job, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["jobid"])
+	if err != nil {
+		return nil, frame.BadRequest("The job id should be a number", err)
+	}
+
+	job := plugin.PluginManager.GetJob(id)
+	if job == nil {
+		return nil, frame.NotFound("There is no job with id " + strconv.Itoa(id))
+	}
+
+	return job, nil
+}
+
+func newPluginJSON(p *plugin.Plugin) pluginJSON {
+	info := pluginJSON{
+		ID:          strings.ToLower(p.Name),
+		Name:        p.Name,
+		Description: p.Description,
+		Category:    p.Category,
+		Enabled:     p.IsEnabled(),
+		Tables:      p.Tables,
+	}
+
+	if job := plugin.PluginManager.GetRunningJob(p); job != nil {
+		running := job.Info()
+		info.Running = &running
+	}
+
+	if history := plugin.PluginManager.GetRunHistory(p.Name, 1); len(history) > 0 {
+		lastRun := newRunJSON(history[0])
+		info.LastRun = &lastRun
+	}
+
+	return info
+}
+
+func newRunJSON(record plugin.RunRecord) runJSON {
+	return runJSON{
+		record.ID,
+		record.Status,
+		record.Started,
+		record.Finished,
+		record.Summary,
+	}
+}
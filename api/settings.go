@@ -0,0 +1,120 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/plugin"
+	"github.com/nielsvanm/homemanager/settings"
+)
+
+// settingJSON is a setting of a plugin together with its current value
+type settingJSON struct {
+	Plugin      string `json:"plugin"`
+	Key         string `json:"key"`
+	Label       string `json:"label"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Default     string `json:"default"`
+	Value       string `json:"value"`
+}
+
+// settingBody is the body of a request that changes a setting
+type settingBody struct {
+	Value *string `json:"value"`
+}
+
+// SettingsView lists the settings of every plugin, they can be filtered
+// with the plugin query parameter
+func SettingsView(w http.ResponseWriter, r *http.Request) error {
+	page, err := GetPage(r)
+	if err != nil {
+		return err
+	}
+	filter := r.URL.Query().Get("plugin")
+
+	list := []settingJSON{}
+	for _, p := range plugin.PluginManager.Plugins {
+		if filter != "" && !strings.EqualFold(p.Name, filter) {
+			continue
+		}
+		for _, setting := range p.Settings {
+			list = append(list, newSettingJSON(p, setting))
+		}
+	}
+
+	start, end := page.Slice(len(list))
+	WriteList(w, list[start:end], page, len(list))
+	return nil
+}
+
+// SettingView changes a setting with PUT and resets it to its default with
+// DELETE, both respond with the setting
+func SettingView(w http.ResponseWriter, r *http.Request) error {
+	p, err := getPlugin(r)
+	if err != nil {
+		return err
+	}
+
+	key := mux.Vars(r)["key"]
+	setting, ok := findSetting(p, key)
+	if !ok {
+		return frame.NotFound(p.Name + " has no setting called " + key)
+	}
+
+	if r.Method == http.MethodDelete {
+		err = settings.Reset(p.Name, setting)
+		if err != nil {
+			return frame.InternalError(err)
+		}
+
+		WriteData(w, http.StatusOK, newSettingJSON(p, setting))
+		return nil
+	}
+
+	body := settingBody{}
+	err = decodeBody(r, &body)
+	if err != nil {
+		return err
+	}
+	if body.Value == nil {
+		return frame.BadRequest("The body should contain a value", nil)
+	}
+
+	err = setting.Validate(*body.Value)
+	if err != nil {
+		return frame.NewHTTPError(http.StatusUnprocessableEntity, err.Error(), nil)
+	}
+
+	err = settings.Set(p.Name, setting, *body.Value)
+	if err != nil {
+		return frame.InternalError(err)
+	}
+
+	WriteData(w, http.StatusOK, newSettingJSON(p, setting))
+	return nil
+}
+
+func findSetting(p *plugin.Plugin, key string) (settings.Setting, bool) {
+	for _, setting := range p.Settings {
+		if setting.Key == key {
+			return setting, true
+		}
+	}
+
+	return settings.Setting{}, false
+}
+
+func newSettingJSON(p *plugin.Plugin, setting settings.Setting) settingJSON {
+	return settingJSON{
+		strings.ToLower(p.Name),
+		setting.Key,
+		setting.Label,
+		setting.Description,
+		setting.Type,
+		setting.Default,
+		settings.Get(p.Name, setting),
+	}
+}
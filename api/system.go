@@ -0,0 +1,152 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/nielsvanm/homemanager/database"
+	"github.com/nielsvanm/homemanager/plugin"
+	"github.com/nielsvanm/homemanager/storage"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// started is when the server started, it is used for the uptime
+var started = time.Now()
+
+// statsJSON are the statistics of the server, sizes are in bytes
+type statsJSON struct {
+	Uptime         float64       `json:"uptime_seconds"`
+	Processors     int           `json:"processors"`
+	Goroutines     int           `json:"goroutines"`
+	Memory         memoryJSON    `json:"memory"`
+	Plugins        int           `json:"plugins"`
+	EnabledPlugins int           `json:"enabled_plugins"`
+	LogSize        int64         `json:"log_size"`
+	Storage        []storageJSON `json:"storage"`
+}
+
+type memoryJSON struct {
+	Alloc      uint64 `json:"alloc"`
+	TotalAlloc uint64 `json:"total_alloc"`
+	Sys        uint64 `json:"sys"`
+	NumGC      uint32 `json:"num_gc"`
+}
+
+type storageJSON struct {
+	Name      string `json:"name"`
+	Usage     int64  `json:"usage"`
+	SoftQuota int64  `json:"soft_quota"`
+	HardQuota int64  `json:"hard_quota"`
+}
+
+// databaseJSON is the status of the database and of the tables of every
+// plugin
+type databaseJSON struct {
+	Connected bool         `json:"connected"`
+	Error     string       `json:"error,omitempty"`
+	Plugins   []tablesJSON `json:"plugins"`
+}
+
+type tablesJSON struct {
+	Plugin string      `json:"plugin"`
+	Tables []tableJSON `json:"tables"`
+}
+
+// tableJSON describes a table, the amount of rows is the estimate of
+// postgres which is updated when the table is analyzed
+type tableJSON struct {
+	Name   string `json:"name"`
+	Exists bool   `json:"exists"`
+	Rows   int64  `json:"rows"`
+	Size   int64  `json:"size"`
+}
+
+// StatsView returns the statistics of the server
+func StatsView(w http.ResponseWriter, r *http.Request) error {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	stats := statsJSON{
+		Uptime:     time.Since(started).Seconds(),
+		Processors: runtime.NumCPU(),
+		Goroutines: runtime.NumGoroutine(),
+		Memory:     memoryJSON{m.Alloc, m.TotalAlloc, m.Sys, m.NumGC},
+		Plugins:    len(plugin.PluginManager.Plugins),
+		Storage:    []storageJSON{},
+	}
+
+	for _, p := range plugin.PluginManager.Plugins {
+		if p.IsEnabled() {
+			stats.EnabledPlugins++
+		}
+	}
+
+	if info, err := os.Stat(log.File); err == nil {
+		stats.LogSize = info.Size()
+	}
+
+	for _, s := range storage.All() {
+		usage, err := s.Usage()
+		if err != nil {
+			log.FromContext(r.Context()).Warn("API", "Failed to get the storage usage of", s.Name, err.Error())
+		}
+		stats.Storage = append(stats.Storage, storageJSON{s.Name, usage, s.SoftQuota, s.HardQuota})
+	}
+
+	WriteData(w, http.StatusOK, stats)
+	return nil
+}
+
+// DatabaseView returns whether the database is reachable and which tables of
+// the plugins exist
+func DatabaseView(w http.ResponseWriter, r *http.Request) error {
+	status := databaseJSON{Plugins: []tablesJSON{}}
+
+	err := database.Database.Ping(r.Context())
+	if err != nil {
+		status.Error = err.Error()
+		WriteData(w, http.StatusOK, status)
+		return nil
+	}
+	status.Connected = true
+
+	for _, p := range plugin.PluginManager.Plugins {
+		tables := tablesJSON{p.Name, []tableJSON{}}
+		for _, table := range p.Tables {
+			tables.Tables = append(tables.Tables, getTable(table))
+		}
+		status.Plugins = append(status.Plugins, tables)
+	}
+
+	WriteData(w, http.StatusOK, status)
+	return nil
+}
+
+// getTable looks up the table in the schemas of the connection
+func getTable(name string) tableJSON {
+	table := tableJSON{Name: name}
+
+	rows := database.Database.Query(`
+	SELECT GREATEST(c.reltuples, 0)::BIGINT, pg_total_relation_size(c.oid)
+	FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relname = $1 AND c.relkind = 'r' AND n.nspname = ANY(current_schemas(false));`, strings.ToLower(name))
+	if rows == nil {
+		return table
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err := rows.Scan(&table.Rows, &table.Size)
+		if err != nil {
+			log.Warn("API", "Failed to scan table row", err.Error())
+			return table
+		}
+		table.Exists = true
+	}
+
+	return table
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokenUsage describes the token command
+const tokenUsage = `usage:
+  homemanager token create --user <username> --name <name> [--days <days>] [--scopes <scope,scope>]
+  homemanager token list --user <username>
+  homemanager token revoke --user <username> <id>`
+
+// RunTokenCommand executes the token command, it is called with the
+// arguments after "token", e.g. create --user admin --name backup
+func RunTokenCommand(args []string) error {
+	if len(args) < 1 {
+		return errors.New(tokenUsage)
+	}
+
+	flags := flag.NewFlagSet("token "+args[0], flag.ContinueOnError)
+	username := flags.String("user", "", "--user <username the token belongs to>")
+	name := flags.String("name", "", "--name <name of the token>")
+	days := flags.Int("days", 90, "--days <days until the token expires, 0 never expires>")
+	scopes := flags.String("scopes", "", "--scopes <comma separated permissions or plugin:<name>>")
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+
+	user := GetUserByName(*username)
+	if user == nil {
+		return fmt.Errorf("can't find user %q\n%s", *username, tokenUsage)
+	}
+
+	switch args[0] {
+	case "create":
+		scopeList := []string{}
+		for _, scope := range strings.Split(*scopes, ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				scopeList = append(scopeList, scope)
+			}
+		}
+
+		token, secret, err := CreateToken(user, *name, scopeList, time.Duration(*days)*24*time.Hour)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created token %d, it won't be shown again:\n%s\n", token.ID, secret)
+
+	case "list":
+		for _, token := range GetTokens(user) {
+			expires := "never"
+			if token.Expires != nil {
+				expires = token.Expires.Format("2006-01-02")
+			}
+			lastUsed := "never"
+			if token.LastUsed != nil {
+				lastUsed = token.LastUsed.Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%d\t%s\thm_...%s\texpires %s\tlast used %s\t%s\n",
+				token.ID, token.Name, token.Hint, expires, lastUsed, strings.Join(token.Scopes, ","))
+		}
+
+	case "revoke":
+		if flags.NArg() != 1 {
+			return errors.New(tokenUsage)
+		}
+		id, err := strconv.Atoi(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		err = RevokeToken(user, id)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Revoked token", id)
+
+	default:
+		return errors.New(tokenUsage)
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nielsvanm/homemanager/middleware"
+	"github.com/nielsvanm/homemanager/tools/clock"
+)
+
+// MaxLoginFailures is the amount of failed logins for an account from one
+// address after which that address can't log in to the account for
+// LockoutDuration. An address that fails four times as often is locked out
+// of every account
+var (
+	MaxLoginFailures = 5
+	LockoutDuration  = 15 * time.Minute
+)
+
+// loginFailures counts the failed logins of a key, failures older than
+// LockoutDuration are forgotten
+type loginFailures struct {
+	count  int
+	last   time.Time
+	locked time.Time
+}
+
+var failures = map[string]*loginFailures{}
+var failuresLock sync.Mutex
+
+// RateLimitKey rate limits requests by api token, by user or by the address
+// of the client, whichever is known first
+func RateLimitKey(r *http.Request) string {
+	if token := TokenFromRequest(r); token != nil {
+		return "token:" + strconv.Itoa(token.ID)
+	}
+	if user := UserFromRequest(r); user != nil {
+		return "user:" + strconv.Itoa(user.ID)
+	}
+
+	return middleware.ByIP(r)
+}
+
+// LoginLockout returns how long logging in as username from ip is locked
+// because of earlier failures, it is 0 when logging in is allowed
+func LoginLockout(username, ip string) time.Duration {
+	now := clock.Now()
+
+	failuresLock.Lock()
+	defer failuresLock.Unlock()
+
+	var wait time.Duration
+	for _, key := range lockoutKeys(username, ip) {
+		f, ok := failures[key]
+		if ok && f.locked.After(now) && f.locked.Sub(now) > wait {
+			wait = f.locked.Sub(now)
+		}
+	}
+
+	return wait
+}
+
+// LoginFailed records a failed login as username from ip and locks logging
+// in when there were too many
+func LoginFailed(username, ip string) {
+	now := clock.Now()
+
+	failuresLock.Lock()
+	defer failuresLock.Unlock()
+
+	// Forget what happened long ago
+	for key, f := range failures {
+		if now.Sub(f.last) > LockoutDuration && !f.locked.After(now) {
+			delete(failures, key)
+		}
+	}
+
+	keys := lockoutKeys(username, ip)
+	for i, key := range keys {
+		f, ok := failures[key]
+		if !ok {
+			f = &loginFailures{}
+			failures[key] = f
+		}
+		f.count++
+		f.last = now
+
+		limit := MaxLoginFailures
+		if i == len(keys)-1 {
+			limit *= 4
+		}
+		if f.count >= limit {
+			f.count = 0
+			f.locked = now.Add(LockoutDuration)
+		}
+	}
+}
+
+// LoginSucceeded forgets the failed logins as username from ip, failures of
+// the address for other accounts still count
+func LoginSucceeded(username, ip string) {
+	failuresLock.Lock()
+	defer failuresLock.Unlock()
+
+	delete(failures, lockoutKeys(username, ip)[0])
+}
+
+// lockoutKeys returns the key of the account and address and the key of the
+// address alone
+func lockoutKeys(username, ip string) []string {
+	return []string{"login:" + strings.ToLower(username) + "@" + ip, "ip:" + ip}
+}
@@ -0,0 +1,99 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nielsvanm/homemanager/auth"
+)
+
+func TestLoginLockout(t *testing.T) {
+	c := useClock(t)
+
+	// Every address is only used by this test, the failures are global
+	fail := func(username, ip string, times int) {
+		for i := 0; i < times; i++ {
+			auth.LoginFailed(username, ip)
+		}
+	}
+
+	fail("alice", "192.0.2.1", auth.MaxLoginFailures-1)
+	if wait := auth.LoginLockout("alice", "192.0.2.1"); wait != 0 {
+		t.Errorf("expected no lockout below the limit, got %s", wait)
+	}
+
+	fail("Alice", "192.0.2.1", 1)
+	if wait := auth.LoginLockout("alice", "192.0.2.1"); wait != auth.LockoutDuration {
+		t.Errorf("expected the account to be locked for the address, got %s", wait)
+	}
+	if wait := auth.LoginLockout("alice", "192.0.2.2"); wait != 0 {
+		t.Errorf("expected other addresses to be able to log in, got %s", wait)
+	}
+	if wait := auth.LoginLockout("bob", "192.0.2.1"); wait != 0 {
+		t.Errorf("expected the address to be able to log in to other accounts, got %s", wait)
+	}
+
+	c.Set(now.Add(auth.LockoutDuration - time.Minute))
+	if wait := auth.LoginLockout("alice", "192.0.2.1"); wait != time.Minute {
+		t.Errorf("expected the lockout to count down, got %s", wait)
+	}
+
+	c.Set(now.Add(auth.LockoutDuration))
+	if wait := auth.LoginLockout("alice", "192.0.2.1"); wait != 0 {
+		t.Errorf("expected the lockout to end, got %s", wait)
+	}
+}
+
+func TestLoginLockoutOfAddress(t *testing.T) {
+	useClock(t)
+
+	// An address that tries many accounts is locked out of all of them
+	for i := 0; i < 4*auth.MaxLoginFailures; i++ {
+		auth.LoginFailed("user"+string(rune('a'+i)), "192.0.2.10")
+	}
+
+	if wait := auth.LoginLockout("somebody", "192.0.2.10"); wait != auth.LockoutDuration {
+		t.Errorf("expected the address to be locked out, got %s", wait)
+	}
+	if wait := auth.LoginLockout("somebody", "192.0.2.11"); wait != 0 {
+		t.Errorf("expected other addresses to be able to log in, got %s", wait)
+	}
+}
+
+func TestLoginSucceededForgetsFailures(t *testing.T) {
+	c := useClock(t)
+
+	auth.LoginFailed("carol", "192.0.2.20")
+	for i := 0; i < auth.MaxLoginFailures-1; i++ {
+		auth.LoginSucceeded("carol", "192.0.2.20")
+		auth.LoginFailed("carol", "192.0.2.20")
+	}
+	if wait := auth.LoginLockout("carol", "192.0.2.20"); wait != 0 {
+		t.Errorf("expected failures before a login to be forgotten, got %s", wait)
+	}
+
+	// Failures long ago are forgotten as well
+	for i := 0; i < auth.MaxLoginFailures-1; i++ {
+		auth.LoginFailed("dave", "192.0.2.21")
+	}
+	c.Set(now.Add(auth.LockoutDuration + time.Minute))
+	auth.LoginFailed("dave", "192.0.2.21")
+	if wait := auth.LoginLockout("dave", "192.0.2.21"); wait != 0 {
+		t.Errorf("expected old failures to be forgotten, got %s", wait)
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/plugins/", nil)
+	r.RemoteAddr = "192.0.2.30:5000"
+	if key := auth.RateLimitKey(r); key != "ip:192.0.2.30" {
+		t.Errorf("expected anonymous requests to be limited by address, got %q", key)
+	}
+
+	r = auth.WithUser(r, &auth.User{ID: 3, Username: "alice"})
+	if key := auth.RateLimitKey(r); key != "user:3" {
+		t.Errorf("expected requests of a user to be limited by user, got %q", key)
+	}
+}
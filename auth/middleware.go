@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nielsvanm/homemanager/middleware"
+)
+
+// AllowList are the paths that can be visited without logging in, entries
+// that end with a / match everything below them
+var AllowList = []string{
+	"/login/",
+	"/logout/",
+	"/setup/",
+	"/static/",
+	"/healthz",
+	"/readyz",
+}
+
+// TokenList are the paths that accept api tokens instead of a session, so
+// scripts and scrapers can use them. Entries that end with a / match
+// everything below them
+var TokenList = []string{
+	"/api/",
+	"/metrics",
+}
+
+// LoginURL and SetupURL are where visitors are sent to log in or to create
+// the first account
+var (
+	LoginURL = "/login/"
+	SetupURL = "/setup/"
+)
+
+// Middleware looks up the user of the request and only lets requests for
+// paths on the AllowList through when nobody is logged in. Requests for the
+// api and the other paths on the TokenList can authenticate with a bearer
+// token instead of a session, the token is ignored for other paths
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := bearerToken(r)
+		if secret != "" && matchesPath(TokenList, r.URL.Path) {
+			token, user, err := tokenUser(secret)
+			switch {
+			case err != nil:
+				writeAPIError(w, r, http.StatusUnauthorized, err.Error())
+			case !token.AllowsPath(r.URL.Path):
+				writeAPIError(w, r, http.StatusForbidden, "the api token is not scoped for this plugin")
+			default:
+				r = WithUser(r, user)
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenKey, token)))
+			}
+			return
+		}
+
+		user := sessionUser(r)
+		if user != nil {
+			next.ServeHTTP(w, WithUser(r, user))
+			return
+		}
+
+		if IsAllowed(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// API clients can't follow a login page
+		if matchesPath(TokenList, r.URL.Path) {
+			writeAPIError(w, r, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+
+		// Nobody can log in before the first account exists
+		if CountUsers() == 0 {
+			middleware.Redirect(w, r, SetupURL, http.StatusSeeOther)
+			return
+		}
+
+		middleware.Redirect(w, r, LoginURL+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+	})
+}
+
+// IsAllowed returns true when the path can be visited without logging in
+func IsAllowed(path string) bool {
+	return matchesPath(AllowList, path)
+}
+
+// matchesPath returns true when the path is on the list or below an entry
+// that ends with a /
+func matchesPath(list []string, path string) bool {
+	for _, allowed := range list {
+		if path == allowed {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/") && strings.HasPrefix(path, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SafeRedirect returns next when it is a path on this site and fallback
+// otherwise, so the login page can't be used to redirect to other sites
+func SafeRedirect(next, fallback string) string {
+	// Browsers ignore tabs and newlines in urls, "/\t/evil" is "//evil"
+	if strings.ContainsAny(next, "\t\r\n") {
+		return fallback
+	}
+	if next == "" || !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return fallback
+	}
+
+	return next
+}
+
+// writeAPIError responds with the same json errors as the rest of the api
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="homemanager"`)
+	}
+	middleware.ErrorHandler(w, r, status, message)
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Built-in roles, admins are allowed everything
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleGuest  = "guest"
+)
+
+// Roles lists the built-in roles from most to least privileged
+var Roles = []string{RoleAdmin, RoleMember, RoleGuest}
+
+// Built-in permissions of the core pages
+const (
+	PermViewPlugins    = "plugins.view"
+	PermRunPlugins     = "plugins.run"
+	PermViewStorage    = "storage.view"
+	PermManageDatabase = "database.manage"
+	PermManageSettings = "settings.manage"
+	PermManageUsers    = "users.manage"
+	PermAPITokens      = "tokens.manage"
+	PermViewLogs       = "logs.view"
+	PermViewMetrics    = "metrics.view"
+)
+
+// ErrUnknownRole is returned when a role is assigned that does not exist
+var ErrUnknownRole = errors.New("unknown role")
+
+// Permission is something a role is allowed to do, Roles are the roles that
+// are granted the permission besides admin
+type Permission struct {
+	Name        string
+	Description string
+	Roles       []string
+}
+
+var permissions = map[string]Permission{}
+var permissionsLock sync.RWMutex
+
+func init() {
+	RegisterPermission(Permission{PermViewPlugins, "See the plugins and follow their jobs", []string{RoleMember}})
+	RegisterPermission(Permission{PermRunPlugins, "Run and cancel plugins", []string{RoleMember}})
+	RegisterPermission(Permission{PermViewStorage, "See the storage used by plugins", []string{RoleMember}})
+	RegisterPermission(Permission{PermManageDatabase, "Create and drop the tables of plugins", nil})
+	RegisterPermission(Permission{PermManageSettings, "Change the settings of the app and plugins", nil})
+	RegisterPermission(Permission{PermManageUsers, "Create users and assign their roles", nil})
+	RegisterPermission(Permission{PermAPITokens, "Create personal api tokens", []string{RoleMember}})
+	RegisterPermission(Permission{PermViewLogs, "Read the application log", nil})
+	RegisterPermission(Permission{PermViewMetrics, "Scrape the Prometheus metrics", nil})
+}
+
+// RegisterPermission makes a permission known, plugins declare their own
+// permissions which are registered when the plugin is set up
+func RegisterPermission(perm Permission) {
+	permissionsLock.Lock()
+	defer permissionsLock.Unlock()
+
+	permissions[perm.Name] = perm
+}
+
+// GetPermissions returns every known permission sorted by name
+func GetPermissions() []Permission {
+	permissionsLock.RLock()
+	defer permissionsLock.RUnlock()
+
+	perms := []Permission{}
+	for _, perm := range permissions {
+		perms = append(perms, perm)
+	}
+	sort.Slice(perms, func(i, j int) bool { return perms[i].Name < perms[j].Name })
+
+	return perms
+}
+
+// IsRole returns true when the role exists
+func IsRole(role string) bool {
+	for _, r := range Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RoleHasPermission returns true when the role is granted the permission,
+// permissions that are not registered are only granted to admins
+func RoleHasPermission(role, permission string) bool {
+	if role == RoleAdmin {
+		return true
+	}
+
+	permissionsLock.RLock()
+	defer permissionsLock.RUnlock()
+
+	perm, ok := permissions[permission]
+	if !ok {
+		return false
+	}
+	for _, r := range perm.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasPermission returns true when the user is granted the permission
+func (u *User) HasPermission(permission string) bool {
+	if permission == "" {
+		return true
+	}
+
+	return RoleHasPermission(u.Role, permission)
+}
+
+// Authorize returns true when the user of the request is granted the
+// permission, it is used as the frame.Authorizer
+func Authorize(r *http.Request, permission string) bool {
+	if permission == "" {
+		return true
+	}
+
+	user := UserFromRequest(r)
+	if user == nil {
+		return false
+	}
+
+	// Tokens can be limited to fewer permissions than the user has
+	if token := TokenFromRequest(r); token != nil && !token.AllowsPermission(permission) {
+		return false
+	}
+
+	return user.HasPermission(permission)
+}
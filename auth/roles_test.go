@@ -0,0 +1,31 @@
+package auth_test
+
+import (
+	"testing"
+
+	"github.com/nielsvanm/homemanager/auth"
+)
+
+func TestRoleHasPermission(t *testing.T) {
+	tests := []struct {
+		role       string
+		permission string
+		allowed    bool
+	}{
+		{auth.RoleAdmin, auth.PermManageUsers, true},
+		{auth.RoleAdmin, auth.PermViewPlugins, true},
+		{auth.RoleMember, auth.PermViewPlugins, true},
+		{auth.RoleMember, auth.PermViewStorage, true},
+		{auth.RoleMember, auth.PermRunPlugins, true},
+		{auth.RoleMember, auth.PermViewLogs, false},
+		{auth.RoleGuest, auth.PermViewPlugins, false},
+		{auth.RoleGuest, auth.PermViewStorage, false},
+		{auth.RoleGuest, auth.PermRunPlugins, false},
+	}
+
+	for _, test := range tests {
+		if allowed := auth.RoleHasPermission(test.role, test.permission); allowed != test.allowed {
+			t.Errorf("%s %s: expected %v, got %v", test.role, test.permission, test.allowed, allowed)
+		}
+	}
+}
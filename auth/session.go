@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/nielsvanm/homemanager/database"
+	"github.com/nielsvanm/homemanager/middleware"
+	"github.com/nielsvanm/homemanager/tools/clock"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// CookieName is the name of the session cookie
+const CookieName = "homemanager_session"
+
+// SessionDuration is how long a session stays valid after logging in
+var SessionDuration = 7 * 24 * time.Hour
+
+// SecureCookies forces the secure flag on the session cookie, it is always
+// set for requests that are served over tls
+var SecureCookies = false
+
+type contextKey int
+
+const (
+	userKey contextKey = iota
+	tokenKey
+)
+
+// Login creates a session for the user and sets the session cookie
+func Login(w http.ResponseWriter, r *http.Request, user *User) error {
+	token, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	now := clock.Now()
+	expires := now.Add(SessionDuration)
+
+	err = database.Database.Exec(`
+	INSERT INTO homemanager_session (token_hash, user_id, created, expires)
+	VALUES ($1, $2, $3, $4);`, hashToken(token), user.ID, now, expires)
+	if err != nil {
+		return err
+	}
+
+	// Clean up sessions that can no longer be used
+	database.Database.Exec(`DELETE FROM homemanager_session WHERE expires < $1;`, now)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    token,
+		Path:     middleware.URL("/"),
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   SecureCookies || middleware.IsHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	log.FromContext(r.Context()).Info("Auth", "User "+user.Username+" logged in from "+r.RemoteAddr)
+
+	return nil
+}
+
+// Logout removes the session of the request and clears the cookie
+func Logout(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(CookieName)
+	if err == nil {
+		database.Database.Exec(`
+		DELETE FROM homemanager_session
+		WHERE token_hash = $1;`, hashToken(cookie.Value))
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     CookieName,
+		Value:    "",
+		Path:     middleware.URL("/"),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   SecureCookies || middleware.IsHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// UserFromRequest returns the user that is logged in for the request, it is
+// nil when nobody is logged in
+func UserFromRequest(r *http.Request) *User {
+	user, _ := r.Context().Value(userKey).(*User)
+	return user
+}
+
+// WithUser returns a copy of the request that belongs to the user
+func WithUser(r *http.Request, user *User) *http.Request {
+	middleware.SetAccessUser(r, user.Username)
+	return r.WithContext(context.WithValue(r.Context(), userKey, user))
+}
+
+// sessionUser looks up the user of the session cookie
+func sessionUser(r *http.Request) *User {
+	cookie, err := r.Cookie(CookieName)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+
+	rows := database.Database.Query(`
+	SELECT user_id FROM homemanager_session
+	WHERE token_hash = $1 AND expires > $2;`, hashToken(cookie.Value), clock.Now())
+	if rows == nil {
+		return nil
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID int
+		err := rows.Scan(&userID)
+		if err != nil {
+			log.FromContext(r.Context()).Warn("Auth", "Failed to scan session row", err.Error())
+			return nil
+		}
+
+		return GetUserByID(userID)
+	}
+
+	return nil
+}
+
+// randomToken returns a url safe random token
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes a token so the stored value can't be used as a cookie
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,212 @@
+package auth_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nielsvanm/homemanager/auth"
+	"github.com/nielsvanm/homemanager/plugin/plugintest"
+	"github.com/nielsvanm/homemanager/tools/clock"
+)
+
+var now = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+var userColumns = []string{"id", "username", "role", "created", "password_hash"}
+
+func useClock(t *testing.T) *plugintest.FakeClock {
+	c := plugintest.NewFakeClock(now)
+	previous := clock.Set(c)
+	t.Cleanup(func() { clock.Set(previous) })
+
+	return c
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// serve runs the request through the auth middleware and returns the user
+// the handler got
+func serve(r *http.Request) (*httptest.ResponseRecorder, *auth.User) {
+	var user *auth.User
+	rec := httptest.NewRecorder()
+	auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = auth.UserFromRequest(r)
+	})).ServeHTTP(rec, r)
+
+	return rec, user
+}
+
+func TestSafeRedirect(t *testing.T) {
+	tests := []struct {
+		next string
+		want string
+	}{
+		{"/plugins/", "/plugins/"},
+		{"/ytsamplugin/view/7/?tab=torrents", "/ytsamplugin/view/7/?tab=torrents"},
+		{"", "/"},
+		{"plugins/", "/"},
+		{"https://evil.example/", "/"},
+		{"//evil.example/", "/"},
+		{"/\\evil.example/", "/"},
+		{"/\t/evil.example/", "/"},
+		{"/\n/evil.example/", "/"},
+		{"javascript:alert(1)", "/"},
+	}
+
+	for _, test := range tests {
+		if got := auth.SafeRedirect(test.next, "/"); got != test.want {
+			t.Errorf("SafeRedirect(%q): expected %q, got %q", test.next, test.want, got)
+		}
+	}
+}
+
+func TestLoginStoresHashedSession(t *testing.T) {
+	useClock(t)
+	db := plugintest.UseFakeDB(t)
+
+	rec := httptest.NewRecorder()
+	err := auth.Login(rec, httptest.NewRequest(http.MethodPost, "/login/", nil), &auth.User{ID: 3, Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != auth.CookieName {
+		t.Fatalf("expected the session cookie, got %v", cookies)
+	}
+	cookie := cookies[0]
+	if len(cookie.Value) < 32 || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("expected a long random http only cookie, got %v", cookie)
+	}
+	if !cookie.Expires.Equal(now.Add(auth.SessionDuration)) {
+		t.Errorf("expected the cookie to expire with the session, got %v", cookie.Expires)
+	}
+
+	execs := db.Execs()
+	if len(execs) == 0 {
+		t.Fatal("expected the session to be stored")
+	}
+	args := execs[0].Args
+	if args[0] == cookie.Value || args[0] != sha256Hex(cookie.Value) {
+		t.Errorf("expected only the hash of the session to be stored, got %v", args[0])
+	}
+	if args[1] != int64(3) || !args[3].(time.Time).Equal(now.Add(auth.SessionDuration)) {
+		t.Errorf("expected the session of the user to expire after SessionDuration, got %v", args)
+	}
+}
+
+func TestSessionLookup(t *testing.T) {
+	useClock(t)
+
+	tests := []struct {
+		name     string
+		sessions [][]interface{}
+		users    int
+		user     int
+		location string
+	}{
+		{"valid session", [][]interface{}{{3}}, 1, 3, ""},
+		{"expired or unknown session", nil, 1, 0, "/login/?next=%2Fplugins%2F"},
+		{"no accounts yet", nil, 0, 0, "/setup/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := plugintest.UseFakeDB(t)
+			db.HandleQuery("SELECT user_id FROM homemanager_session", []string{"user_id"}, test.sessions...)
+			db.HandleQuery("FROM homemanager_user WHERE id = $1", userColumns, []interface{}{3, "alice", auth.RoleMember, now, ""})
+			db.HandleQuery("SELECT COUNT(id) FROM homemanager_user", []string{"count"}, []interface{}{test.users})
+
+			r := httptest.NewRequest(http.MethodGet, "/plugins/", nil)
+			r.AddCookie(&http.Cookie{Name: auth.CookieName, Value: "secret-session"})
+			rec, user := serve(r)
+
+			if test.user != 0 && (user == nil || user.ID != test.user) {
+				t.Errorf("expected user %d, got %v", test.user, user)
+			}
+			if test.location != "" && rec.Header().Get("Location") != test.location {
+				t.Errorf("expected a redirect to %s, got %d %q", test.location, rec.Code, rec.Header().Get("Location"))
+			}
+
+			// Expired sessions are filtered out by the query
+			lookup := db.Queries()[0]
+			if lookup.Args[0] != sha256Hex("secret-session") || !lookup.Args[1].(time.Time).Equal(now) {
+				t.Errorf("expected the session to be looked up by hash and the current time, got %v", lookup.Args)
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	db := plugintest.UseFakeDB(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	db.HandleQuery("FROM homemanager_user WHERE username = $1", userColumns, []interface{}{3, "alice", auth.RoleMember, now, string(hash)})
+
+	if user, err := auth.Authenticate("alice", "correct horse"); err != nil || user.ID != 3 {
+		t.Errorf("expected the password to match, got %v %v", user, err)
+	}
+	if _, err := auth.Authenticate("alice", "wrong horse"); err != auth.ErrInvalidCredentials {
+		t.Errorf("expected a wrong password to be refused, got %v", err)
+	}
+
+	db.HandleQuery("FROM homemanager_user WHERE username = $1", userColumns)
+	if _, err := auth.Authenticate("bob", "correct horse"); err != auth.ErrInvalidCredentials {
+		t.Errorf("expected an unknown user to be refused, got %v", err)
+	}
+}
+
+func TestCreateUserHashesPassword(t *testing.T) {
+	db := plugintest.UseFakeDB(t)
+
+	if _, err := auth.CreateUser("alice", "short", auth.RoleMember); err != auth.ErrWeakPassword {
+		t.Errorf("expected a short password to be refused, got %v", err)
+	}
+	if _, err := auth.CreateUser("alice", "correct horse", "owner"); err != auth.ErrUnknownRole {
+		t.Errorf("expected an unknown role to be refused, got %v", err)
+	}
+
+	auth.CreateUser("alice", "correct horse", auth.RoleMember)
+	execs := db.Execs()
+	if len(execs) != 1 {
+		t.Fatalf("expected the user to be stored once, got %v", execs)
+	}
+
+	stored := execs[0].Args[1].(string)
+	if stored == "correct horse" || bcrypt.CompareHashAndPassword([]byte(stored), []byte("correct horse")) != nil {
+		t.Errorf("expected a bcrypt hash of the password to be stored, got %q", stored)
+	}
+}
+
+func TestCreateFirstUser(t *testing.T) {
+	db := plugintest.UseFakeDB(t)
+
+	if _, err := auth.CreateFirstUser("alice", "short"); err != auth.ErrWeakPassword {
+		t.Errorf("expected a short password to be refused, got %v", err)
+	}
+
+	// The insert returns no id when an account already exists
+	if _, err := auth.CreateFirstUser("alice", "correct horse"); err != auth.ErrSetupDone {
+		t.Errorf("expected the setup to be refused when accounts exist, got %v", err)
+	}
+
+	db.HandleQuery("INSERT INTO homemanager_user", []string{"id"}, []interface{}{1})
+	db.HandleQuery("FROM homemanager_user WHERE username = $1", userColumns, []interface{}{1, "alice", auth.RoleAdmin, now, "hash"})
+	user, err := auth.CreateFirstUser("alice", "correct horse")
+	if err != nil || user == nil || user.Role != auth.RoleAdmin {
+		t.Fatalf("expected the first account to be an admin, got %v %v", user, err)
+	}
+
+	queries := db.Queries()
+	if !strings.Contains(queries[len(queries)-2].Query, "WHERE NOT EXISTS") {
+		t.Errorf("expected the insert to check for existing accounts, got %q", queries[len(queries)-2].Query)
+	}
+}
@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nielsvanm/homemanager/database"
+	"github.com/nielsvanm/homemanager/tools/clock"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// TokenPrefix starts every api token so they are easy to recognise, for
+// example by secret scanners
+const TokenPrefix = "hm_"
+
+// PluginScopePrefix starts scopes that limit a token to the api of a plugin,
+// e.g. plugin:torrentplugin
+const PluginScopePrefix = "plugin:"
+
+// ErrInvalidToken is returned when a token does not exist or has expired
+var ErrInvalidToken = errors.New("invalid or expired api token")
+
+// TokenSetupDB creates the table for api tokens
+var TokenSetupDB = []string{
+	`CREATE TABLE IF NOT EXISTS homemanager_api_token (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES homemanager_user(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		hint TEXT NOT NULL,
+		scopes TEXT NOT NULL DEFAULT '',
+		created TIMESTAMP NOT NULL,
+		expires TIMESTAMP,
+		last_used TIMESTAMP
+	);`,
+}
+
+// Token is a personal api token, the token itself is only known when it is
+// created, afterwards only its hash is stored
+type Token struct {
+	ID       int
+	UserID   int
+	Name     string
+	Hint     string
+	Scopes   []string
+	Created  time.Time
+	Expires  *time.Time
+	LastUsed *time.Time
+}
+
+// CreateToken creates a token for the user and returns it together with the
+// secret that has to be sent as a bearer token. A zero lifetime never expires
+func CreateToken(user *User, name string, scopes []string, lifetime time.Duration) (*Token, string, error) {
+	if name == "" {
+		return nil, "", errors.New("a token name is required")
+	}
+	for _, scope := range scopes {
+		if !IsScope(scope) {
+			return nil, "", errors.New("unknown scope " + scope)
+		}
+	}
+
+	random, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	secret := TokenPrefix + random
+
+	now := clock.Now()
+	var expires *time.Time
+	if lifetime > 0 {
+		e := now.Add(lifetime)
+		expires = &e
+	}
+
+	hint := secret[len(secret)-4:]
+	err = database.Database.Exec(`
+	INSERT INTO homemanager_api_token (user_id, name, token_hash, hint, scopes, created, expires)
+	VALUES ($1, $2, $3, $4, $5, $6, $7);`,
+		user.ID, name, hashToken(secret), hint, strings.Join(scopes, ","), now, expires)
+	if err != nil {
+		return nil, "", err
+	}
+
+	log.Info("Auth", "Created api token "+name+" for "+user.Username)
+
+	tokens := getTokens(`
+	SELECT id, user_id, name, hint, scopes, created, expires, last_used
+	FROM homemanager_api_token
+	WHERE token_hash = $1;`, hashToken(secret))
+	if len(tokens) == 0 {
+		return nil, "", errors.New("failed to read the created token")
+	}
+
+	return tokens[0], secret, nil
+}
+
+// GetTokens returns the tokens of the user, newest first
+func GetTokens(user *User) []*Token {
+	return getTokens(`
+	SELECT id, user_id, name, hint, scopes, created, expires, last_used
+	FROM homemanager_api_token
+	WHERE user_id = $1
+	ORDER BY created DESC;`, user.ID)
+}
+
+// RevokeToken deletes a token of the user
+func RevokeToken(user *User, id int) error {
+	return database.Database.Exec(`
+	DELETE FROM homemanager_api_token
+	WHERE id = $1 AND user_id = $2;`, id, user.ID)
+}
+
+// IsScope returns true when the scope is a known permission or a plugin
+// scope
+func IsScope(scope string) bool {
+	if strings.HasPrefix(scope, PluginScopePrefix) {
+		return len(scope) > len(PluginScopePrefix)
+	}
+
+	for _, perm := range GetPermissions() {
+		if perm.Name == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Expired returns true when the token can no longer be used
+func (t *Token) Expired() bool {
+	return t.Expires != nil && !clock.Now().Before(*t.Expires)
+}
+
+// AllowsPath returns true when the token may be used for the path, tokens
+// with plugin scopes may only use the api of those plugins
+func (t *Token) AllowsPath(path string) bool {
+	plugins := []string{}
+	for _, scope := range t.Scopes {
+		if strings.HasPrefix(scope, PluginScopePrefix) {
+			plugins = append(plugins, strings.TrimPrefix(scope, PluginScopePrefix))
+		}
+	}
+	if len(plugins) == 0 {
+		return true
+	}
+
+	for _, plugin := range plugins {
+		if strings.HasPrefix(path, "/api/"+strings.ToLower(plugin)+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsPermission returns true when the token may use the permission,
+// tokens with permission scopes are limited to those permissions. The role of
+// the user limits the token as well
+func (t *Token) AllowsPermission(permission string) bool {
+	limited := false
+	for _, scope := range t.Scopes {
+		if strings.HasPrefix(scope, PluginScopePrefix) {
+			continue
+		}
+		if scope == permission {
+			return true
+		}
+		limited = true
+	}
+
+	return !limited
+}
+
+// TokenFromRequest returns the api token the request was authenticated with,
+// it is nil for requests that use a session
+func TokenFromRequest(r *http.Request) *Token {
+	token, _ := r.Context().Value(tokenKey).(*Token)
+	return token
+}
+
+// IsTokenRequest reports if the request was authenticated by an api token
+func IsTokenRequest(r *http.Request) bool {
+	return TokenFromRequest(r) != nil
+}
+
+// bearerToken returns the token of the authorization header
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(header[7:])
+}
+
+// tokenUser looks up the token and its user and records that it was used
+func tokenUser(secret string) (*Token, *User, error) {
+	tokens := getTokens(`
+	SELECT id, user_id, name, hint, scopes, created, expires, last_used
+	FROM homemanager_api_token
+	WHERE token_hash = $1;`, hashToken(secret))
+	if len(tokens) == 0 || tokens[0].Expired() {
+		return nil, nil, ErrInvalidToken
+	}
+	token := tokens[0]
+
+	user := GetUserByID(token.UserID)
+	if user == nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	now := clock.Now()
+	database.Database.Exec(`
+	UPDATE homemanager_api_token SET last_used = $1
+	WHERE id = $2;`, now, token.ID)
+	token.LastUsed = &now
+
+	return token, user, nil
+}
+
+func getTokens(query string, values ...interface{}) []*Token {
+	tokens := []*Token{}
+
+	rows := database.Database.Query(query, values...)
+	if rows == nil {
+		return tokens
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		token := Token{}
+		var scopes string
+		err := rows.Scan(
+			&token.ID,
+			&token.UserID,
+			&token.Name,
+			&token.Hint,
+			&scopes,
+			&token.Created,
+			&token.Expires,
+			&token.LastUsed,
+		)
+		if err != nil {
+			log.Warn("Auth", "Failed to scan api token row", err.Error())
+			continue
+		}
+		if scopes != "" {
+			token.Scopes = strings.Split(scopes, ",")
+		}
+
+		tokens = append(tokens, &token)
+	}
+
+	return tokens
+}
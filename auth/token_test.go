@@ -0,0 +1,177 @@
+package auth_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nielsvanm/homemanager/auth"
+	"github.com/nielsvanm/homemanager/plugin/plugintest"
+)
+
+var tokenColumns = []string{"id", "user_id", "name", "hint", "scopes", "created", "expires", "last_used"}
+
+func TestTokenExpired(t *testing.T) {
+	c := useClock(t)
+	expires := now.Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		expires *time.Time
+		advance time.Duration
+		expired bool
+	}{
+		{"never expires", nil, 1000 * time.Hour, false},
+		{"before expiry", &expires, 59 * time.Minute, false},
+		{"at expiry", &expires, time.Hour, true},
+		{"after expiry", &expires, 2 * time.Hour, true},
+	}
+
+	for _, test := range tests {
+		c.Set(now.Add(test.advance))
+		token := &auth.Token{Expires: test.expires}
+		if token.Expired() != test.expired {
+			t.Errorf("%s: expected expired to be %v", test.name, test.expired)
+		}
+	}
+}
+
+func TestTokenAllowsPath(t *testing.T) {
+	tests := []struct {
+		scopes  []string
+		path    string
+		allowed bool
+	}{
+		{nil, "/api/torrentplugin/add/", true},
+		{[]string{"plugins.run"}, "/api/ytsamplugin/", true},
+		{[]string{"plugin:torrentplugin"}, "/api/torrentplugin/add/", true},
+		{[]string{"plugin:TorrentPlugin"}, "/api/torrentplugin/add/", true},
+		{[]string{"plugin:torrentplugin"}, "/api/ytsamplugin/", false},
+		{[]string{"plugin:torrentplugin"}, "/api/torrentpluginx/", false},
+		{[]string{"plugin:torrentplugin"}, "/api/plugins/", false},
+		{[]string{"plugin:torrentplugin", "plugin:ytsamplugin"}, "/api/ytsamplugin/", true},
+	}
+
+	for _, test := range tests {
+		token := &auth.Token{Scopes: test.scopes}
+		if token.AllowsPath(test.path) != test.allowed {
+			t.Errorf("%v on %s: expected allowed to be %v", test.scopes, test.path, test.allowed)
+		}
+	}
+}
+
+func TestTokenAllowsPermission(t *testing.T) {
+	tests := []struct {
+		scopes     []string
+		permission string
+		allowed    bool
+	}{
+		{nil, auth.PermRunPlugins, true},
+		{[]string{"plugin:torrentplugin"}, auth.PermRunPlugins, true},
+		{[]string{auth.PermRunPlugins}, auth.PermRunPlugins, true},
+		{[]string{auth.PermRunPlugins}, auth.PermManageUsers, false},
+		{[]string{"plugin:torrentplugin", auth.PermViewLogs}, auth.PermRunPlugins, false},
+		{[]string{auth.PermViewLogs, auth.PermRunPlugins}, auth.PermRunPlugins, true},
+	}
+
+	for _, test := range tests {
+		token := &auth.Token{Scopes: test.scopes}
+		if token.AllowsPermission(test.permission) != test.allowed {
+			t.Errorf("%v for %s: expected allowed to be %v", test.scopes, test.permission, test.allowed)
+		}
+	}
+}
+
+func TestCreateTokenStoresHash(t *testing.T) {
+	useClock(t)
+	db := plugintest.UseFakeDB(t)
+	db.HandleQuery("FROM homemanager_api_token WHERE token_hash = $1", tokenColumns,
+		[]interface{}{5, 3, "backup", "abcd", "plugin:torrentplugin", now, now.Add(time.Hour), nil},
+	)
+
+	token, secret, err := auth.CreateToken(&auth.User{ID: 3, Username: "alice"}, "backup", []string{"plugin:torrentplugin"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, auth.TokenPrefix) || len(secret) < 32 {
+		t.Errorf("expected a long random secret with the prefix, got %q", secret)
+	}
+	if token.ID != 5 || len(token.Scopes) != 1 {
+		t.Errorf("expected the stored token, got %v", token)
+	}
+
+	args := db.Execs()[0].Args
+	if args[2] == secret || args[2] != sha256Hex(secret) {
+		t.Errorf("expected only the hash of the secret to be stored, got %v", args[2])
+	}
+	if args[3] != secret[len(secret)-4:] {
+		t.Errorf("expected the last characters of the secret as hint, got %v", args[3])
+	}
+	if !args[6].(time.Time).Equal(now.Add(time.Hour)) {
+		t.Errorf("expected the token to expire after its lifetime, got %v", args[6])
+	}
+
+	if _, _, err := auth.CreateToken(&auth.User{ID: 3}, "backup", []string{"everything"}, 0); err == nil {
+		t.Error("expected unknown scopes to be refused")
+	}
+}
+
+func TestTokenAuthentication(t *testing.T) {
+	c := useClock(t)
+
+	tests := []struct {
+		name    string
+		path    string
+		header  string
+		tokens  [][]interface{}
+		advance time.Duration
+		status  int
+		user    int
+	}{
+		{"valid token", "/api/torrentplugin/add/", "Bearer hm_secret", [][]interface{}{{5, 3, "backup", "cret", "", now, nil, nil}}, 0, http.StatusOK, 3},
+		{"lowercase scheme", "/api/torrentplugin/add/", "bearer hm_secret", [][]interface{}{{5, 3, "backup", "cret", "", now, nil, nil}}, 0, http.StatusOK, 3},
+		{"unknown token", "/api/torrentplugin/add/", "Bearer hm_secret", nil, 0, http.StatusUnauthorized, 0},
+		{"expired token", "/api/torrentplugin/add/", "Bearer hm_secret", [][]interface{}{{5, 3, "backup", "cret", "", now, now.Add(time.Hour), nil}}, 2 * time.Hour, http.StatusUnauthorized, 0},
+		{"other plugin", "/api/ytsamplugin/", "Bearer hm_secret", [][]interface{}{{5, 3, "backup", "cret", "plugin:torrentplugin", now, nil, nil}}, 0, http.StatusForbidden, 0},
+		{"not an api path", "/plugins/", "Bearer hm_secret", [][]interface{}{{5, 3, "backup", "cret", "", now, nil, nil}}, 0, http.StatusSeeOther, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c.Set(now.Add(test.advance))
+			db := plugintest.UseFakeDB(t)
+			db.HandleQuery("FROM homemanager_api_token WHERE token_hash = $1", tokenColumns, test.tokens...)
+			db.HandleQuery("FROM homemanager_user WHERE id = $1", userColumns, []interface{}{3, "alice", auth.RoleMember, now, ""})
+			db.HandleQuery("SELECT COUNT(id) FROM homemanager_user", []string{"count"}, []interface{}{1})
+
+			r := httptest.NewRequest(http.MethodPost, test.path, nil)
+			r.Header.Set("Authorization", test.header)
+			var token *auth.Token
+			rec := httptest.NewRecorder()
+			auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				token = auth.TokenFromRequest(r)
+				if user := auth.UserFromRequest(r); user == nil || user.ID != test.user {
+					t.Errorf("expected user %d, got %v", test.user, user)
+				}
+			})).ServeHTTP(rec, r)
+
+			if rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, rec.Code, rec.Body.String())
+			}
+			if test.status == http.StatusOK && (token == nil || token.ID != 5) {
+				t.Errorf("expected the request to be authenticated by the token, got %v", token)
+			}
+			if test.status == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected a bearer challenge")
+			}
+
+			for _, query := range db.Queries() {
+				if strings.Contains(query.Query, "token_hash") && query.Args[0] != sha256Hex("hm_secret") {
+					t.Errorf("expected the token to be looked up by its hash, got %v", query.Args[0])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/nielsvanm/homemanager/database"
+	"github.com/nielsvanm/homemanager/tools/clock"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// MinPasswordLength is the minimum length of a password
+const MinPasswordLength = 8
+
+var (
+	// ErrInvalidCredentials is returned when the username or password is wrong
+	ErrInvalidCredentials = errors.New("invalid username or password")
+
+	// ErrWeakPassword is returned when a password is too short
+	ErrWeakPassword = errors.New("the password should be at least 8 characters long")
+
+	// ErrSetupDone is returned when the first account is created while
+	// accounts already exist
+	ErrSetupDone = errors.New("the first account has already been created")
+)
+
+// setupLock makes sure only one request at a time creates the first account
+var setupLock sync.Mutex
+
+// dummyHash is compared against when a user does not exist, so the response
+// time does not reveal which usernames exist
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+
+// SetupDB creates the tables for users and their sessions
+var SetupDB = []string{
+	`CREATE TABLE IF NOT EXISTS homemanager_user (
+		id SERIAL PRIMARY KEY,
+		username TEXT NOT NULL UNIQUE,
+		password_hash TEXT NOT NULL,
+		created TIMESTAMP NOT NULL
+	);`,
+	// Accounts that existed before roles were added have no admin, the
+	// first account becomes one. This only happens when the column is added,
+	// an admin that is demoted later stays demoted
+	`DO $$
+	BEGIN
+		IF NOT EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_schema = current_schema()
+			AND table_name = 'homemanager_user' AND column_name = 'role'
+		) THEN
+			ALTER TABLE homemanager_user ADD COLUMN role TEXT NOT NULL DEFAULT 'member';
+			UPDATE homemanager_user SET role = 'admin'
+			WHERE id = (SELECT MIN(id) FROM homemanager_user);
+		END IF;
+	END $$;`,
+	`CREATE TABLE IF NOT EXISTS homemanager_session (
+		token_hash TEXT PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES homemanager_user(id) ON DELETE CASCADE,
+		created TIMESTAMP NOT NULL,
+		expires TIMESTAMP NOT NULL
+	);`,
+}
+
+// User is an account that can log in to the app
+type User struct {
+	ID       int
+	Username string
+	Role     string
+	Created  time.Time
+
+	passwordHash string
+}
+
+// CreateUser stores a new user with a hashed password and the role
+func CreateUser(username, password, role string) (*User, error) {
+	if !IsRole(role) {
+		return nil, ErrUnknownRole
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	err = database.Database.Exec(`
+	INSERT INTO homemanager_user (username, password_hash, role, created)
+	VALUES ($1, $2, $3, $4);`, username, hash, role, clock.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Auth", "Created "+role+" "+username)
+
+	return GetUserByName(username), nil
+}
+
+// CreateFirstUser stores the first account as an admin, it returns
+// ErrSetupDone when any account already exists. The check is part of the
+// insert so two requests can't both create the first account
+func CreateFirstUser(username, password string) (*User, error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	setupLock.Lock()
+	defer setupLock.Unlock()
+
+	var id int
+	err = database.Database.QueryRowContext(context.Background(), `
+	INSERT INTO homemanager_user (username, password_hash, role, created)
+	SELECT $1, $2, $3, $4
+	WHERE NOT EXISTS (SELECT 1 FROM homemanager_user)
+	RETURNING id;`, username, hash, RoleAdmin, clock.Now()).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSetupDone
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Auth", "Created "+RoleAdmin+" "+username)
+
+	return GetUserByName(username), nil
+}
+
+// hashPassword checks the length of the password and returns its bcrypt hash
+func hashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", ErrWeakPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// SetPassword replaces the password of the user
+func (u *User) SetPassword(password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return database.Database.Exec(`
+	UPDATE homemanager_user SET password_hash = $1
+	WHERE id = $2;`, hash, u.ID)
+}
+
+// SetRole changes the role of the user
+func (u *User) SetRole(role string) error {
+	if !IsRole(role) {
+		return ErrUnknownRole
+	}
+
+	err := database.Database.Exec(`
+	UPDATE homemanager_user SET role = $1
+	WHERE id = $2;`, role, u.ID)
+	if err != nil {
+		return err
+	}
+	u.Role = role
+
+	return nil
+}
+
+// Delete removes the user together with its sessions
+func (u *User) Delete() error {
+	return database.Database.Exec(`DELETE FROM homemanager_user WHERE id = $1;`, u.ID)
+}
+
+// Authenticate returns the user when the password matches
+func Authenticate(username, password string) (*User, error) {
+	user := GetUserByName(username)
+	if user == nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(user.passwordHash), []byte(password))
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+// GetUserByName returns the user with the username or nil when it does not
+// exist
+func GetUserByName(username string) *User {
+	return getUser(`
+	SELECT id, username, role, created, password_hash FROM homemanager_user
+	WHERE username = $1;`, username)
+}
+
+// GetUserByID returns the user with the id or nil when it does not exist
+func GetUserByID(id int) *User {
+	return getUser(`
+	SELECT id, username, role, created, password_hash FROM homemanager_user
+	WHERE id = $1;`, id)
+}
+
+// GetUsers returns all users ordered by username
+func GetUsers() []*User {
+	return getUsers(`
+	SELECT id, username, role, created, password_hash FROM homemanager_user
+	ORDER BY username;`)
+}
+
+// CountUsers returns the amount of users, it is -1 when the users can't be
+// counted
+func CountUsers() int {
+	rows := database.Database.Query(`SELECT COUNT(id) FROM homemanager_user;`)
+	if rows == nil {
+		return -1
+	}
+	defer rows.Close()
+
+	count := -1
+	for rows.Next() {
+		rows.Scan(&count)
+	}
+
+	return count
+}
+
+// CountAdmins returns the amount of admins, it is -1 when the admins can't
+// be counted
+func CountAdmins() int {
+	rows := database.Database.Query(`
+	SELECT COUNT(id) FROM homemanager_user
+	WHERE role = $1;`, RoleAdmin)
+	if rows == nil {
+		return -1
+	}
+	defer rows.Close()
+
+	count := -1
+	for rows.Next() {
+		rows.Scan(&count)
+	}
+
+	return count
+}
+
+func getUser(query string, value interface{}) *User {
+	users := getUsers(query, value)
+	if len(users) == 0 {
+		return nil
+	}
+
+	return users[0]
+}
+
+func getUsers(query string, values ...interface{}) []*User {
+	users := []*User{}
+
+	rows := database.Database.Query(query, values...)
+	if rows == nil {
+		return users
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user := User{}
+		err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.Role,
+			&user.Created,
+			&user.passwordHash,
+		)
+		if err != nil {
+			log.Warn("Auth", "Failed to scan user row", err.Error())
+			continue
+		}
+
+		users = append(users, &user)
+	}
+
+	return users
+}
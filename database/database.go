@@ -1,8 +1,11 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	// Import PQ for the sql package
 	_ "github.com/lib/pq"
@@ -41,6 +44,34 @@ func NewDB(username, password, name, url string, port int) *DB {
 	return &db
 }
 
+// Open connects to the database described by a postgres connection string,
+// unlike Connect it returns an error instead of stopping the app
+func Open(connectionString string) (*DB, error) {
+	return OpenDriver("postgres", connectionString)
+}
+
+// OpenDriver is Open for other sql drivers, such as the in-memory database
+// of plugin tests
+func OpenDriver(driverName, connectionString string) (*DB, error) {
+	connection, err := sql.Open(driverName, connectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	err = connection.Ping()
+	if err != nil {
+		connection.Close()
+		return nil, err
+	}
+
+	return &DB{connection: connection}, nil
+}
+
+// Close closes the connection to the database
+func (db *DB) Close() error {
+	return db.connection.Close()
+}
+
 // Connect opens a connection to the dabase
 func (db *DB) Connect() {
 	connectionString := fmt.Sprintf("user=%s password=%s dbname=%s sslmode=disable", db.Username, db.Password, db.Name)
@@ -61,6 +92,11 @@ func (db *DB) Connect() {
 	log.Info("Database", "Succesfully connected to PostgresDB")
 }
 
+// Ping checks if the database server can still be reached
+func (db *DB) Ping(ctx context.Context) error {
+	return db.connection.PingContext(ctx)
+}
+
 // CreateTable creates a single table in the database
 func (db *DB) CreateTable(query string) error {
 	_, err := db.connection.Exec(query)
@@ -71,56 +107,86 @@ func (db *DB) CreateTable(query string) error {
 	return nil
 }
 
-// CreateTables creates multiple tables based in the array of strings provided
+// CreateTables runs the queries that create the tables in one transaction,
+// nothing is created when one of them fails. The error names the query that
+// failed
 func (db *DB) CreateTables(queries []string) error {
 	tx, err := db.connection.Begin()
 	if err != nil {
-		log.Warn("Database", "Failed to create a transaction, "+err.Error())
+		return fmt.Errorf("failed to start a transaction: %w", err)
 	}
 
-	for _, query := range queries {
-		_, err := tx.Exec(query)
+	for i, query := range queries {
+		_, err = tx.Exec(query)
 		if err != nil {
-			log.Err("Database", err.Error())
-			continue
+			tx.Rollback()
+			return fmt.Errorf("query %d of %d failed: %w\n%s", i+1, len(queries), err, strings.TrimSpace(query))
 		}
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		log.Fatal("Database", err.Error())
-	}
-
-	return nil
+	return tx.Commit()
 }
 
 // Exec is short for db.connection.Exec()
-func (db *DB) Exec(query string) {
-	db.connection.Exec(query)
+func (db *DB) Exec(query string, vals ...interface{}) error {
+	start := time.Now()
+	_, err := db.connection.Exec(query, vals...)
+	observeQuery("exec", start, err)
+	if err != nil {
+		log.Warn("Database", "Error while executing query "+err.Error())
+	}
+
+	return err
 }
 
 // ExecBatch executes a query for every value in a BatchQuery struct
 // it all happens in a transaction
 func (db *DB) ExecBatch(batch BatchQuery) {
-	tx, err := db.connection.Begin()
+	db.ExecBatchContext(context.Background(), batch)
+}
+
+// ExecBatchContext is ExecBatch that stops and rolls back the transaction
+// when the context is done
+func (db *DB) ExecBatchContext(ctx context.Context, batch BatchQuery) error {
+	return db.ExecBatchesContext(ctx, []BatchQuery{batch})
+}
+
+// ExecBatchesContext executes multiple batches in a single transaction, in
+// the order they are provided
+func (db *DB) ExecBatchesContext(ctx context.Context, batches []BatchQuery) (err error) {
+	defer func(start time.Time) { observeQuery("batch", start, err) }(time.Now())
+
+	tx, err := db.connection.BeginTx(ctx, nil)
 	if err != nil {
 		log.Err("Database", err.Error())
+		return err
 	}
-	for _, vars := range batch.Values {
-		_, err = tx.Exec(batch.Query, vars...)
-		if err != nil {
-			log.Warn("Database", err.Error()+"\n"+batch.Query)
+	for _, batch := range batches {
+		for _, vars := range batch.Values {
+			if ctx.Err() != nil {
+				tx.Rollback()
+				return ctx.Err()
+			}
+
+			_, err = tx.ExecContext(ctx, batch.Query, vars...)
+			if err != nil {
+				log.Warn("Database", err.Error()+"\n"+batch.Query)
+			}
 		}
 	}
 	err = tx.Commit()
 	if err != nil {
 		log.Err("Database", err.Error())
 	}
+
+	return err
 }
 
 // Query is short for db.connection.query
 func (db *DB) Query(sql string, vals ...interface{}) *sql.Rows {
+	start := time.Now()
 	rows, err := db.connection.Query(sql, vals...)
+	observeQuery("query", start, err)
 	if err != nil {
 		log.Warn("Database", "Error while executing query "+err.Error())
 	}
@@ -128,6 +194,16 @@ func (db *DB) Query(sql string, vals ...interface{}) *sql.Rows {
 	return rows
 }
 
+// QueryRowContext is short for db.connection.QueryRowContext, errors are
+// returned by Scan
+func (db *DB) QueryRowContext(ctx context.Context, query string, vals ...interface{}) *sql.Row {
+	start := time.Now()
+	row := db.connection.QueryRowContext(ctx, query, vals...)
+	observeQuery("query", start, row.Err())
+
+	return row
+}
+
 // BatchQuery is a struct representing a query and a list of interfaces
 // as context values
 type BatchQuery struct {
@@ -139,3 +215,9 @@ type BatchQuery struct {
 func (bq *BatchQuery) AddValues(vals ...interface{}) {
 	bq.Values = append(bq.Values, vals)
 }
+
+// Sink receives the results of a plugin bit by bit, every commit is stored
+// together with a checkpoint the plugin can resume from when a run fails
+type Sink interface {
+	Commit(ctx context.Context, checkpoint string, batches ...BatchQuery) error
+}
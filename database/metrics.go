@@ -0,0 +1,67 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/nielsvanm/homemanager/metrics"
+)
+
+var (
+	queryDuration = metrics.NewHistogram("homemanager_db_query_duration_seconds",
+		"Time it took to execute queries by their operation", metrics.DefaultBuckets, "operation")
+	queryErrors = metrics.NewCounter("homemanager_db_query_errors_total",
+		"Number of queries that failed by their operation", "operation")
+)
+
+func init() {
+	metrics.MustRegister(
+		queryDuration,
+		queryErrors,
+		poolStat(metrics.TypeGauge, "homemanager_db_open_connections", "Number of open connections to the database", func(s sql.DBStats) float64 {
+			return float64(s.OpenConnections)
+		}),
+		poolStat(metrics.TypeGauge, "homemanager_db_in_use_connections", "Number of connections that are in use", func(s sql.DBStats) float64 {
+			return float64(s.InUse)
+		}),
+		poolStat(metrics.TypeGauge, "homemanager_db_idle_connections", "Number of idle connections", func(s sql.DBStats) float64 {
+			return float64(s.Idle)
+		}),
+		poolStat(metrics.TypeGauge, "homemanager_db_max_open_connections", "Maximum number of open connections, 0 is unlimited", func(s sql.DBStats) float64 {
+			return float64(s.MaxOpenConnections)
+		}),
+		poolStat(metrics.TypeCounter, "homemanager_db_wait_count_total", "Number of times a query waited for a connection", func(s sql.DBStats) float64 {
+			return float64(s.WaitCount)
+		}),
+		poolStat(metrics.TypeCounter, "homemanager_db_wait_duration_seconds_total", "Total time queries waited for a connection", func(s sql.DBStats) float64 {
+			return s.WaitDuration.Seconds()
+		}),
+	)
+}
+
+// poolStat creates a metric that reads a field of the connection pool
+// statistics of the app's database
+func poolStat(typ, name, help string, field func(s sql.DBStats) float64) metrics.Metric {
+	function := func(ctx context.Context) (float64, error) {
+		if Database == nil || Database.connection == nil {
+			return 0, errors.New("the database is not connected")
+		}
+
+		return field(Database.connection.Stats()), nil
+	}
+
+	if typ == metrics.TypeCounter {
+		return metrics.NewCounterFunc(name, help, function)
+	}
+	return metrics.NewGaugeFunc(name, help, function)
+}
+
+// observeQuery records how long a query took and if it failed
+func observeQuery(operation string, start time.Time, err error) {
+	queryDuration.Observe(time.Since(start).Seconds(), operation)
+	if err != nil {
+		queryErrors.Inc(operation)
+	}
+}
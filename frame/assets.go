@@ -0,0 +1,171 @@
+package frame
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nielsvanm/homemanager/middleware"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// StaticPrefix is the url the core static files are served under
+const StaticPrefix = "/static/"
+
+// immutableCache is sent with fingerprinted files, their url changes when
+// their content does so browsers may keep them forever
+const immutableCache = "public, max-age=31536000, immutable"
+
+// fingerprinted matches a file name with a fingerprint before its extension,
+// e.g. css/base.0123456789.css
+var fingerprinted = regexp.MustCompile(`^(.+)\.([0-9a-f]{10})(\.[^./]+)$`)
+
+// staticMounts maps the url prefixes of static files to their file systems,
+// the core static files are looked up in StaticFS
+var staticMounts = map[string]fs.FS{}
+var staticMountsLock sync.RWMutex
+
+// fingerprints caches the fingerprint of every file by its url, a file is
+// hashed again when its size or modification time changes
+var fingerprints = map[string]fingerprint{}
+var fingerprintsLock sync.Mutex
+
+type fingerprint struct {
+	Hash    string
+	Size    int64
+	ModTime time.Time
+}
+
+// templateFuncs are available in every template, url adds the base path to
+// a path of the app
+var templateFuncs = template.FuncMap{
+	"asset": AssetURL,
+	"url":   middleware.URL,
+}
+
+// AssetURL returns the public fingerprinted url of a static file, such as
+// /static/css/base.css, so it can be cached until it changes. The url is not
+// fingerprinted when the file doesn't exist
+func AssetURL(url string) string {
+	prefix, fsys := staticMount(url)
+	if fsys == nil {
+		log.Warn("WebServer", "No static files are served at", url)
+		return middleware.URL(url)
+	}
+
+	hash, err := fileFingerprint(url, fsys, strings.TrimPrefix(url, prefix))
+	if err != nil {
+		log.Warn("WebServer", "Failed to fingerprint", url, err.Error())
+		return middleware.URL(url)
+	}
+
+	ext := ""
+	if i := strings.LastIndex(url, "."); i > strings.LastIndex(url, "/") {
+		ext = url[i:]
+	}
+
+	return middleware.URL(strings.TrimSuffix(url, ext) + "." + hash + ext)
+}
+
+// mountStatic makes the files of fsys available to AssetURL
+func mountStatic(prefix string, fsys fs.FS) {
+	staticMountsLock.Lock()
+	defer staticMountsLock.Unlock()
+
+	staticMounts[prefix] = fsys
+}
+
+// staticMount returns the prefix and file system the url is served from,
+// the longest prefix wins
+func staticMount(url string) (string, fs.FS) {
+	staticMountsLock.RLock()
+	defer staticMountsLock.RUnlock()
+
+	prefix, fsys := "", fs.FS(nil)
+	for mount, mountFS := range staticMounts {
+		if strings.HasPrefix(url, mount) && len(mount) > len(prefix) {
+			prefix, fsys = mount, mountFS
+		}
+	}
+	if prefix == "" && strings.HasPrefix(url, StaticPrefix) {
+		prefix, fsys = StaticPrefix, StaticFS
+	}
+
+	return prefix, fsys
+}
+
+// fileFingerprint returns the first 10 characters of the sha256 of the file,
+// the fingerprint is cached under the url
+func fileFingerprint(url string, fsys fs.FS, name string) (string, error) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return "", err
+	}
+
+	fingerprintsLock.Lock()
+	cached, ok := fingerprints[url]
+	fingerprintsLock.Unlock()
+	if ok && cached.Size == info.Size() && cached.ModTime.Equal(info.ModTime()) {
+		return cached.Hash, nil
+	}
+
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))[:10]
+
+	fingerprintsLock.Lock()
+	fingerprints[url] = fingerprint{sum, info.Size(), info.ModTime()}
+	fingerprintsLock.Unlock()
+
+	return sum, nil
+}
+
+// staticHandler serves the files of fsys under the prefix. Fingerprinted
+// urls of the current version of a file are cached forever, other urls have
+// to be revalidated with their ETag
+func staticHandler(prefix string, fsys fs.FS) http.Handler {
+	files := http.StripPrefix(prefix, http.FileServer(http.FS(fsys)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, prefix)
+
+		cache := "no-cache"
+		if match := fingerprinted.FindStringSubmatch(name); match != nil {
+			original := match[1] + match[3]
+			hash, err := fileFingerprint(prefix+original, fsys, original)
+			if err == nil {
+				// Pages that are still open may ask for an older version,
+				// they get the current one without caching it for long
+				if hash == match[2] {
+					cache = immutableCache
+				}
+				name = original
+				r = r.Clone(r.Context())
+				r.URL.Path = prefix + original
+				r.URL.RawPath = ""
+			}
+		}
+
+		w.Header().Set("Cache-Control", cache)
+		if hash, err := fileFingerprint(r.URL.Path, fsys, name); err == nil {
+			w.Header().Set("ETag", `"`+hash+`"`)
+		}
+		files.ServeHTTP(w, r)
+	})
+}
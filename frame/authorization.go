@@ -0,0 +1,29 @@
+package frame
+
+import (
+	"net/http"
+)
+
+// Authorizer reports if the user of the request is granted the permission,
+// it is typically injected by the main.go file. Every permission is denied
+// while it is not set
+var Authorizer func(r *http.Request, permission string) bool
+
+// IsAuthorized returns true when the request is allowed to use something that
+// requires the permission, an empty permission is always allowed
+func IsAuthorized(r *http.Request, permission string) bool {
+	if permission == "" {
+		return true
+	}
+	if Authorizer == nil {
+		return false
+	}
+
+	return Authorizer(r, permission)
+}
+
+// Forbidden responds with 403, api requests get a json problem and pages get
+// the error page
+func Forbidden(w http.ResponseWriter, r *http.Request) {
+	RenderError(w, r, ForbiddenError("You don't have permission to view this page, ask an administrator if you need access."))
+}
@@ -0,0 +1,153 @@
+package frame
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nielsvanm/homemanager/middleware"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// errorLayout is the themed page errors of views are shown on
+var errorLayout = NewLayout("base.html", "errors/error.html")
+
+func init() {
+	// Let the middleware respond with the same error pages as the views
+	middleware.ErrorHandler = func(w http.ResponseWriter, r *http.Request, status int, message string) {
+		RenderError(w, r, NewHTTPError(status, message, nil))
+	}
+}
+
+// HandlerFunc is a handler that returns an error instead of writing it to
+// the response, the error is rendered by RenderError. Use Handle to turn it
+// into an endpoint function
+type HandlerFunc func(w http.ResponseWriter, r *http.Request) error
+
+// Handle returns an endpoint function that calls the handler and renders the
+// error it returns
+func Handle(handler HandlerFunc) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := handler(w, r)
+		if err != nil {
+			RenderError(w, r, err)
+		}
+	}
+}
+
+// HTTPError is an error with the status code it should be responded with,
+// the Message is shown to the user while Err is only logged
+type HTTPError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+// NewHTTPError is a constructor for the http errors
+func NewHTTPError(status int, message string, err error) *HTTPError {
+	httpErr := HTTPError{
+		status,
+		message,
+		err,
+	}
+
+	return &httpErr
+}
+
+// Error returns the message followed by the underlying error
+func (e *HTTPError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+
+	return e.Message
+}
+
+// Unwrap returns the underlying error
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// BadRequest is returned when the request can't be handled as it is sent
+func BadRequest(message string, err error) *HTTPError {
+	return NewHTTPError(http.StatusBadRequest, message, err)
+}
+
+// NotFound is returned when the requested item does not exist
+func NotFound(message string) *HTTPError {
+	return NewHTTPError(http.StatusNotFound, message, nil)
+}
+
+// ForbiddenError is returned when the user is not allowed to do something
+func ForbiddenError(message string) *HTTPError {
+	return NewHTTPError(http.StatusForbidden, message, nil)
+}
+
+// InternalError is returned when something on the server failed, the error
+// is logged but not shown
+func InternalError(err error) *HTTPError {
+	return NewHTTPError(http.StatusInternalServerError, "Something went wrong while handling the request", err)
+}
+
+// problem is a json error response as described in RFC 7807
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// RenderError responds with the error, api routes and clients that ask for
+// json get a problem response and views get the error page. Errors that are
+// not an HTTPError are treated as internal errors
+func RenderError(w http.ResponseWriter, r *http.Request, err error) {
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		httpErr = InternalError(err)
+	}
+
+	logger := log.FromContext(r.Context())
+	message := "[" + strconv.Itoa(httpErr.Status) + "] " + r.Method + " " + r.URL.Path + " " + httpErr.Error()
+	if httpErr.Status >= 500 {
+		logger.Err("WebServer", message)
+	} else {
+		logger.Warn("WebServer", message)
+	}
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(httpErr.Status)
+		json.NewEncoder(w).Encode(problem{
+			"about:blank",
+			http.StatusText(httpErr.Status),
+			httpErr.Status,
+			httpErr.Message,
+			r.URL.Path,
+			middleware.GetRequestID(r),
+		})
+		return
+	}
+
+	page := errorLayout.NewPage()
+	page.AddContext("status", httpErr.Status)
+	page.AddContext("title", http.StatusText(httpErr.Status))
+	page.AddContext("message", httpErr.Message)
+	page.AddContext("requestid", middleware.GetRequestID(r))
+
+	w.WriteHeader(httpErr.Status)
+	page.Render(w, r)
+}
+
+// wantsJSON returns true for api routes and requests that prefer json
+func wantsJSON(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		return true
+	}
+
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
@@ -0,0 +1,347 @@
+package frame
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// Events is the app-wide broker that pages subscribe to on /events/
+var Events = NewBroker(256)
+
+// Reconnection and keepalive of event streams
+const (
+	eventRetry     = 3 * time.Second
+	eventKeepalive = 30 * time.Second
+)
+
+// subscriberBuffer is the amount of events a subscriber may fall behind
+// before it is disconnected
+const subscriberBuffer = 64
+
+// Event is a message that is published on a topic
+type Event struct {
+	ID    uint64
+	Topic string
+	Type  string
+	Data  json.RawMessage
+}
+
+// Topic is a channel of events that pages can subscribe to
+type Topic struct {
+	Name string
+
+	// Permission is required to subscribe, everybody who is logged in can
+	// subscribe when it is empty
+	Permission string
+
+	// Sample is optional, it is called every Interval while the topic has
+	// subscribers and its result is published as a "sample" event. When it
+	// fails a "sample_error" event with the message is published instead
+	Sample   func(ctx context.Context) (interface{}, error)
+	Interval time.Duration
+}
+
+// Broker sends the events that are published on a topic to its subscribers,
+// it remembers the latest events so reconnecting subscribers can catch up
+type Broker struct {
+	// Internal variables
+	lock        sync.Mutex
+	nextID      uint64
+	history     []Event
+	size        int
+	topics      map[string]*topicState
+	subscribers map[*subscription]bool
+}
+
+// topicState tracks the subscribers and sampler of a registered topic
+type topicState struct {
+	Topic
+	subscribers int
+	lastSample  *Event
+	stopSampler context.CancelFunc
+}
+
+// subscription receives the events of its topics until it is closed, it is
+// closed when the subscriber falls behind
+type subscription struct {
+	topics map[string]bool
+	events chan Event
+}
+
+// NewBroker is a constructor for a broker that remembers the last size
+// events
+func NewBroker(size int) *Broker {
+	b := Broker{
+		sync.Mutex{},
+		0,
+		[]Event{},
+		size,
+		map[string]*topicState{},
+		map[*subscription]bool{},
+	}
+
+	return &b
+}
+
+// RegisterTopic makes the topic available to subscribers, only registered
+// topics can be published on. Topics are registered before the server starts
+func (b *Broker) RegisterTopic(topic Topic) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.topics[topic.Name] = &topicState{Topic: topic}
+}
+
+// HasSubscribers reports if anyone is subscribed to the topic, publishers
+// can use it to skip work nobody is waiting for
+func (b *Broker) HasSubscribers(topic string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	state, ok := b.topics[topic]
+	return ok && state.subscribers > 0
+}
+
+// Publish sends an event with the json encoding of data to the subscribers
+// of the topic
+func (b *Broker) Publish(topic, eventType string, data interface{}) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		log.Warn("Events", "Failed to marshal", eventType, "event of", topic, err.Error())
+		return
+	}
+
+	b.lock.Lock()
+	state, ok := b.topics[topic]
+	if !ok {
+		b.lock.Unlock()
+		// Not logged under the lock, the logs topic publishes log messages
+		log.Warn("Events", "Published on unknown topic", topic)
+		return
+	}
+	defer b.lock.Unlock()
+
+	b.nextID++
+	event := Event{b.nextID, topic, eventType, encoded}
+
+	b.history = append(b.history, event)
+	if len(b.history) > b.size {
+		b.history = b.history[len(b.history)-b.size:]
+	}
+	if eventType == "sample" {
+		state.lastSample = &event
+	}
+
+	for sub := range b.subscribers {
+		if !sub.topics[topic] {
+			continue
+		}
+
+		// A subscriber that can't keep up is disconnected, it reconnects
+		// and catches up from the history instead of blocking publishers
+		select {
+		case sub.events <- event:
+		default:
+			b.close(sub)
+		}
+	}
+}
+
+// subscribe returns a subscription to the topics together with the events
+// it missed since lastID. Without a lastID the latest sample of each topic
+// is returned so the subscriber starts with the current state. missed is
+// true when the history doesn't go back far enough
+func (b *Broker) subscribe(topics []string, lastID uint64) (sub *subscription, replay []Event, missed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	sub = &subscription{map[string]bool{}, make(chan Event, subscriberBuffer)}
+	for _, topic := range topics {
+		sub.topics[topic] = true
+	}
+
+	if lastID > 0 {
+		// The ids start over when the server restarts
+		missed = lastID > b.nextID || (len(b.history) > 0 && b.history[0].ID > lastID+1)
+		for _, event := range b.history {
+			if event.ID > lastID && sub.topics[event.Topic] {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	for topic := range sub.topics {
+		state := b.topics[topic]
+		if lastID == 0 && state.lastSample != nil {
+			replay = append(replay, *state.lastSample)
+		}
+
+		state.subscribers++
+		if state.subscribers == 1 && state.Sample != nil {
+			ctx, cancel := context.WithCancel(context.Background())
+			state.stopSampler = cancel
+			go b.sample(ctx, state.Topic)
+		}
+	}
+	b.subscribers[sub] = true
+
+	return sub, replay, missed
+}
+
+// unsubscribe stops the subscription, samplers stop with their last
+// subscriber
+func (b *Broker) unsubscribe(sub *subscription) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.close(sub)
+	for topic := range sub.topics {
+		state := b.topics[topic]
+		state.subscribers--
+		if state.subscribers == 0 && state.stopSampler != nil {
+			state.stopSampler()
+			state.stopSampler = nil
+			state.lastSample = nil
+		}
+	}
+}
+
+// close closes the channel of the subscription, the caller must hold the
+// lock
+func (b *Broker) close(sub *subscription) {
+	if b.subscribers[sub] {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// sample publishes the samples of the topic until ctx is done
+func (b *Broker) sample(ctx context.Context, topic Topic) {
+	interval := topic.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		data, err := topic.Sample(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			b.Publish(topic.Name, "sample_error", map[string]string{"message": err.Error()})
+		} else {
+			b.Publish(topic.Name, "sample", data)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// EventsView streams the events of the topics in the topic query parameters
+// as server-sent events, e.g. /events/?topic=stats&topic=plugins. Browsers
+// reconnect by themselves and send the Last-Event-ID header, the events they
+// missed are replayed. When too many were missed a "resync" event tells the
+// page to reload its state
+func (b *Broker) EventsView(w http.ResponseWriter, r *http.Request) error {
+	topics := r.URL.Query()["topic"]
+	if len(topics) == 0 {
+		return BadRequest("Subscribe to at least one topic with the topic query parameter", nil)
+	}
+
+	for _, topic := range topics {
+		b.lock.Lock()
+		state, ok := b.topics[topic]
+		b.lock.Unlock()
+
+		if !ok {
+			return NotFound("There is no topic " + topic)
+		}
+		if !IsAuthorized(r, state.Permission) {
+			return ForbiddenError("You don't have permission to subscribe to " + topic)
+		}
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var last uint64
+	if lastID != "" {
+		var err error
+		last, err = strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			return BadRequest("The last event id should be a number", err)
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return InternalError(errors.New("the response writer does not support streaming"))
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	sub, replay, missed := b.subscribe(topics, last)
+	defer b.unsubscribe(sub)
+
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetry.Milliseconds())
+	if missed {
+		fmt.Fprint(w, "event: resync\ndata: {}\n\n")
+	}
+	for _, event := range replay {
+		writeEvent(w, event)
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case event, open := <-sub.events:
+			if !open {
+				// Fell behind, the browser reconnects and catches up
+				return nil
+			}
+			writeEvent(w, event)
+			flusher.Flush()
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
+
+// writeEvent writes a single server-sent event, the topic is part of the
+// data so one stream can carry several topics
+func writeEvent(w http.ResponseWriter, event Event) {
+	data, err := json.Marshal(map[string]interface{}{
+		"topic": event.Topic,
+		"data":  event.Data,
+	})
+	if err != nil {
+		log.Warn("Events", "Failed to marshal event", err.Error())
+		return
+	}
+
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
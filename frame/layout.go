@@ -0,0 +1,167 @@
+package frame
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"io/fs"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// Layout is a set of templates that are rendered together, the first
+// template is the one that is executed. Layouts are parsed once and cached,
+// they are typically declared as package variables so LoadTemplates can
+// check them when the app starts
+type Layout struct {
+	pages []string
+
+	// Internal variables
+	lock     sync.Mutex
+	template *template.Template
+	err      error
+	parsed   bool
+}
+
+// layouts are all layouts that have been declared, keyed by their pages
+var layouts = map[string]*Layout{}
+var layoutsLock sync.Mutex
+
+// NewLayout returns the layout of the pages, layouts of the same pages are
+// shared so they are only parsed once
+func NewLayout(pages ...string) *Layout {
+	key := strings.Join(pages, "|")
+
+	layoutsLock.Lock()
+	defer layoutsLock.Unlock()
+
+	if l, ok := layouts[key]; ok {
+		return l
+	}
+	l := &Layout{pages: pages}
+	layouts[key] = l
+
+	return l
+}
+
+// NewPage creates a page of the layout with an empty context, every request
+// should use its own page
+func (l *Layout) NewPage() *Page {
+	return &Page{l, map[string]interface{}{}}
+}
+
+// Template returns the parsed templates of the layout, they are parsed on
+// first use and cached until ReloadTemplates is called
+func (l *Layout) Template() (*template.Template, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.parsed {
+		l.template, l.err = parseTemplates(l.pages)
+		if l.err != nil {
+			l.err = errors.New(strings.Join(l.pages, ", ") + ": " + l.err.Error())
+		}
+		l.parsed = true
+	}
+
+	return l.template, l.err
+}
+
+// reset drops the cached templates so they are parsed again on next use
+func (l *Layout) reset() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.template, l.err, l.parsed = nil, nil, false
+}
+
+// getLayouts returns all declared layouts
+func getLayouts() []*Layout {
+	layoutsLock.Lock()
+	defer layoutsLock.Unlock()
+
+	all := []*Layout{}
+	for _, l := range layouts {
+		all = append(all, l)
+	}
+
+	return all
+}
+
+// LoadTemplates parses every declared layout, it returns an error that lists
+// all layouts with missing or broken templates. It should be called once the
+// plugins have registered their templates
+func LoadTemplates() error {
+	failed := []string{}
+	for _, l := range getLayouts() {
+		_, err := l.Template()
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New("failed to load templates:\n\t" + strings.Join(failed, "\n\t"))
+	}
+
+	log.Info("PageParser", "Loaded", strconv.Itoa(len(getLayouts())), "layouts")
+	return nil
+}
+
+// ReloadTemplates drops the cached templates of every layout, they are
+// parsed again on next use
+func ReloadTemplates() {
+	for _, l := range getLayouts() {
+		l.reset()
+	}
+}
+
+// WatchTemplates reloads the templates whenever a template file changes, it
+// checks the files every interval until ctx is done. It is meant for
+// development, file systems without modification times such as the embedded
+// templates never change
+func WatchTemplates(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := templatesVersion()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		version := templatesVersion()
+		if version != last {
+			log.Info("PageParser", "Templates changed, reloading")
+			ReloadTemplates()
+			last = version
+		}
+	}
+}
+
+// templatesVersion summarises the modification times and sizes of all
+// template files, it changes when a file is changed, added or removed
+func templatesVersion() int64 {
+	var version int64
+	for _, fsys := range templateFileSystems() {
+		fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+			version += info.ModTime().UnixNano() + info.Size() + int64(len(name))
+			return nil
+		})
+	}
+
+	return version
+}
@@ -0,0 +1,76 @@
+package frame
+
+import (
+	"net/http"
+	"strings"
+)
+
+// MenuProvider returns the groups that are shown in the sidebar, it is
+// typically injected by the main.go file
+var MenuProvider func() []MenuGroup
+
+// MenuEntry is the label and icon an endpoint shows in the sidebar
+type MenuEntry struct {
+	Label string
+	Icon  string
+}
+
+// MenuGroup is a collapsible group of items in the sidebar
+type MenuGroup struct {
+	Name   string
+	Icon   string
+	Items  []MenuItem
+	Active bool
+}
+
+// MenuItem is a single link in the sidebar, it is only shown to users that
+// are granted the Permission
+type MenuItem struct {
+	Label      string
+	Icon       string
+	Path       string
+	Permission string
+	Active     bool
+}
+
+// BuildMenu returns the sidebar for the request, marking the item of the
+// current page and its group as active. Items the request is not authorized
+// for are left out, as are groups that end up empty
+func BuildMenu(r *http.Request) []MenuGroup {
+	if MenuProvider == nil {
+		return nil
+	}
+
+	groups := []MenuGroup{}
+	for _, group := range MenuProvider() {
+		// Copy the items so the provider's slices are never modified
+		items := []MenuItem{}
+		for _, item := range group.Items {
+			if r != nil && !IsAuthorized(r, item.Permission) {
+				continue
+			}
+			if r != nil && isActivePath(item.Path, r.URL.Path) {
+				item.Active = true
+				group.Active = true
+			}
+			items = append(items, item)
+		}
+
+		if len(items) > 0 {
+			group.Items = items
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+// isActivePath returns true when the current path belongs to the menu path,
+// the root path only matches itself
+func isActivePath(menuPath, currentPath string) bool {
+	if menuPath == "/" {
+		return currentPath == "/"
+	}
+
+	return strings.HasPrefix(currentPath, menuPath)
+}
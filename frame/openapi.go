@@ -0,0 +1,402 @@
+package frame
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nielsvanm/homemanager/middleware"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// APIPrefix starts the urls of the endpoints that are part of the api, only
+// they are described in the OpenAPI document
+const APIPrefix = "/api/"
+
+// OpenAPIURL is where the OpenAPI document is served
+const OpenAPIURL = "/api/openapi.json"
+
+// coreAPIPrefix starts the endpoints of the core api, the others belong to
+// plugins
+const coreAPIPrefix = "/api/v1/"
+
+// APIInfo is the info object of the OpenAPI document
+var APIInfo = map[string]interface{}{
+	"title":       "HomeManager API",
+	"version":     "1.0.0",
+	"description": "The api of HomeManager and its plugins.",
+}
+
+// APISecuritySchemes are the ways to authenticate with the api, they are
+// set by main
+var APISecuritySchemes = map[string]Schema{}
+
+// docsLayout lists every api endpoint
+var docsLayout = NewLayout("base.html", "api/docs.html")
+
+// pathParameter matches the variables in the url of an endpoint, such as
+// {pluginname} or {id:[0-9]+}
+var pathParameter = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// EndpointDoc describes an endpoint in the OpenAPI document, the request
+// and responses are go values or a Schema
+type EndpointDoc struct {
+	Summary     string
+	Description string
+	Query       []QueryParameter
+	Request     interface{}
+	RequestType string
+	Responses   map[int]interface{}
+}
+
+// QueryParameter is an optional query parameter of an endpoint
+type QueryParameter struct {
+	Name        string
+	Description string
+}
+
+// apiOperation is a single method of an api endpoint
+type apiOperation struct {
+	Tag        string
+	Method     string
+	Path       string
+	Doc        EndpointDoc
+	Permission string
+	Parameters []string
+}
+
+// docOperation is an apiOperation as shown on the documentation page
+type docOperation struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Permission  string
+	Parameters  []string
+	Query       []QueryParameter
+	Request     string
+	RequestType string
+	Responses   []docResponse
+}
+
+type docResponse struct {
+	Status int
+	Schema string
+}
+
+// docGroup are the operations of the core api or of a plugin
+type docGroup struct {
+	Name       string
+	Operations []docOperation
+}
+
+// WithDoc describes the endpoint in the OpenAPI document
+func (endp *Endpoint) WithDoc(summary, description string) *Endpoint {
+	endp.doc().Summary = summary
+	endp.doc().Description = description
+
+	return endp
+}
+
+// WithQuery documents an optional query parameter of the endpoint
+func (endp *Endpoint) WithQuery(name, description string) *Endpoint {
+	endp.doc().Query = append(endp.doc().Query, QueryParameter{name, description})
+
+	return endp
+}
+
+// WithRequest documents the json body the endpoint expects
+func (endp *Endpoint) WithRequest(body interface{}) *Endpoint {
+	endp.doc().Request = body
+	endp.doc().RequestType = "application/json"
+
+	return endp
+}
+
+// WithMultipartRequest documents the form with file uploads the endpoint
+// expects
+func (endp *Endpoint) WithMultipartRequest(form Schema) *Endpoint {
+	endp.doc().Request = form
+	endp.doc().RequestType = "multipart/form-data"
+
+	return endp
+}
+
+// WithResponse documents a json response of the endpoint, body is nil for
+// responses without a body
+func (endp *Endpoint) WithResponse(status int, body interface{}) *Endpoint {
+	if endp.doc().Responses == nil {
+		endp.doc().Responses = map[int]interface{}{}
+	}
+	endp.doc().Responses[status] = body
+
+	return endp
+}
+
+func (endp *Endpoint) doc() *EndpointDoc {
+	if endp.Doc == nil {
+		endp.Doc = &EndpointDoc{}
+	}
+
+	return endp.Doc
+}
+
+// OpenAPIView responds with the OpenAPI 3 document of the api
+func (ws *WebServer) OpenAPIView(w http.ResponseWriter, r *http.Request) {
+	doc, err := json.MarshalIndent(ws.OpenAPI(), "", "  ")
+	if err != nil {
+		RenderError(w, r, InternalError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(doc)
+}
+
+// APIDocsView lists every api endpoint of the core and the plugins
+func (ws *WebServer) APIDocsView(w http.ResponseWriter, r *http.Request) {
+	groups := []docGroup{}
+	for _, op := range ws.apiOperations() {
+		if len(groups) == 0 || groups[len(groups)-1].Name != op.Tag {
+			groups = append(groups, docGroup{Name: op.Tag})
+		}
+
+		docOp := docOperation{
+			Method:      op.Method,
+			Path:        op.Path,
+			Summary:     op.Doc.Summary,
+			Description: op.Doc.Description,
+			Permission:  op.Permission,
+			Parameters:  op.Parameters,
+			Query:       op.Doc.Query,
+			RequestType: op.Doc.RequestType,
+		}
+		if op.Doc.Request != nil {
+			docOp.Request = indentSchema(SchemaOf(op.Doc.Request))
+		}
+		for _, status := range sortedStatuses(op.Doc.Responses) {
+			schema := ""
+			if body := op.Doc.Responses[status]; body != nil {
+				schema = indentSchema(SchemaOf(body))
+			}
+			docOp.Responses = append(docOp.Responses, docResponse{status, schema})
+		}
+
+		group := &groups[len(groups)-1]
+		group.Operations = append(group.Operations, docOp)
+	}
+
+	page := docsLayout.NewPage()
+	page.AddContext("groups", groups)
+	page.Render(w, r)
+}
+
+// OpenAPI returns the OpenAPI 3 document that describes every api endpoint
+// that is registered
+func (ws *WebServer) OpenAPI() map[string]interface{} {
+	paths := map[string]map[string]interface{}{}
+	tags := []map[string]string{}
+
+	for _, op := range ws.apiOperations() {
+		if len(tags) == 0 || tags[len(tags)-1]["name"] != op.Tag {
+			tags = append(tags, map[string]string{"name": op.Tag})
+		}
+
+		operation := map[string]interface{}{
+			"tags":        []string{op.Tag},
+			"operationId": operationID(op.Method, op.Path),
+			"responses":   openAPIResponses(op.Doc),
+		}
+		if op.Doc.Summary != "" {
+			operation["summary"] = op.Doc.Summary
+		}
+		description := op.Doc.Description
+		if op.Permission != "" {
+			description = strings.TrimSpace(description + "\n\nRequires the `" + op.Permission + "` permission.")
+			operation["x-permission"] = op.Permission
+		}
+		if description != "" {
+			operation["description"] = description
+		}
+
+		parameters := []map[string]interface{}{}
+		for _, name := range op.Parameters {
+			parameters = append(parameters, map[string]interface{}{
+				"name": name, "in": "path", "required": true, "schema": Schema{"type": "string"},
+			})
+		}
+		for _, query := range op.Doc.Query {
+			parameters = append(parameters, map[string]interface{}{
+				"name": query.Name, "in": "query", "description": query.Description, "schema": Schema{"type": "string"},
+			})
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		if op.Doc.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					op.Doc.RequestType: map[string]interface{}{"schema": SchemaOf(op.Doc.Request)},
+				},
+			}
+		}
+
+		if paths[op.Path] == nil {
+			paths[op.Path] = map[string]interface{}{}
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = operation
+	}
+
+	// Any of the schemes will do
+	names := []string{}
+	for name := range APISecuritySchemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	security := []map[string][]string{}
+	for _, name := range names {
+		security = append(security, map[string][]string{name: {}})
+	}
+
+	return map[string]interface{}{
+		"openapi":  "3.0.3",
+		"info":     APIInfo,
+		"servers":  []map[string]string{{"url": apiServer()}},
+		"tags":     tags,
+		"paths":    paths,
+		"security": security,
+		"components": map[string]interface{}{
+			"securitySchemes": APISecuritySchemes,
+			"schemas": map[string]interface{}{
+				"Problem": SchemaOf(problem{}),
+			},
+			"responses": map[string]interface{}{
+				"Problem": map[string]interface{}{
+					"description": "The request failed, the problem is described as in RFC 7807",
+					"content": map[string]interface{}{
+						"application/problem+json": map[string]interface{}{
+							"schema": Schema{"$ref": "#/components/schemas/Problem"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// apiServer is the url the paths of the document are relative to
+func apiServer() string {
+	if middleware.BasePath == "" {
+		return "/"
+	}
+
+	return middleware.BasePath
+}
+
+// apiOperations returns a sorted operation for every method of every api
+// endpoint, the core api comes first and plugins follow by name
+func (ws *WebServer) apiOperations() []apiOperation {
+	operations := []apiOperation{}
+
+	for _, endp := range ws.endpoints {
+		if !strings.HasPrefix(endp.URL, APIPrefix) || endp.URL == OpenAPIURL {
+			continue
+		}
+
+		tag := "core"
+		if !strings.HasPrefix(endp.URL, coreAPIPrefix) {
+			tag = strings.SplitN(strings.TrimPrefix(endp.URL, APIPrefix), "/", 2)[0]
+		}
+
+		parameters := []string{}
+		for _, match := range pathParameter.FindAllStringSubmatch(endp.URL, -1) {
+			parameters = append(parameters, match[1])
+		}
+		path := pathParameter.ReplaceAllString(endp.URL, "{$1}")
+
+		doc := EndpointDoc{}
+		if endp.Doc != nil {
+			doc = *endp.Doc
+		}
+
+		for _, method := range endp.Methods {
+			if method == http.MethodHead {
+				continue
+			}
+			operations = append(operations, apiOperation{tag, method, path, doc, endp.Permission, parameters})
+		}
+	}
+
+	sort.SliceStable(operations, func(i, j int) bool {
+		a, b := operations[i], operations[j]
+		if a.Tag != b.Tag {
+			return a.Tag == "core" || (b.Tag != "core" && a.Tag < b.Tag)
+		}
+		return a.Path < b.Path
+	})
+
+	return operations
+}
+
+// openAPIResponses describes the documented responses, every operation can
+// fail with a problem
+func openAPIResponses(doc EndpointDoc) map[string]interface{} {
+	responses := map[string]interface{}{
+		"default": Schema{"$ref": "#/components/responses/Problem"},
+	}
+
+	if len(doc.Responses) == 0 {
+		responses["200"] = map[string]interface{}{"description": http.StatusText(http.StatusOK)}
+	}
+	for status, body := range doc.Responses {
+		response := map[string]interface{}{"description": http.StatusText(status)}
+		if body != nil {
+			response["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": SchemaOf(body)},
+			}
+		}
+		responses[strconv.Itoa(status)] = response
+	}
+
+	return responses
+}
+
+// operationID turns the method and path into a unique name for the
+// operation, e.g. get_api_v1_plugins_pluginname
+func operationID(method, path string) string {
+	parts := []string{strings.ToLower(method)}
+	for _, part := range strings.Split(path, "/") {
+		part = strings.Trim(part, "{}")
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return strings.Join(parts, "_")
+}
+
+func sortedStatuses(responses map[int]interface{}) []int {
+	statuses := []int{}
+	for status := range responses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+
+	return statuses
+}
+
+func indentSchema(schema Schema) string {
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		log.Warn("WebServer", "Failed to marshal schema", err.Error())
+		return ""
+	}
+
+	return string(data)
+}
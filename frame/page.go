@@ -1,51 +1,154 @@
 package frame
 
 import (
+	"bytes"
+	"context"
 	"html/template"
-	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
 	"strings"
+	"sync"
 
+	"github.com/nielsvanm/homemanager/middleware"
 	"github.com/nielsvanm/homemanager/tools/log"
 )
 
-// TemplateFolder is the folder where the templates are located,
-// typically injected by the main.go file
-var TemplateFolder string
+// TemplateFS is the file system where the core templates are located,
+// typically injected by the main.go file with the embedded templates
+var TemplateFS fs.FS
+
+// templateSources maps a template prefix, such as plugins/torrentplugin/, to
+// the file system that contains the templates with that prefix
+var templateSources = map[string]fs.FS{}
+var templateSourcesLock sync.RWMutex
+
+// UserProvider returns the user that is logged in for the request, it is set
+// by the main.go file so the templates can show who is logged in
+var UserProvider func(r *http.Request) interface{}
 
 func init() {
-	TemplateFolder = "./templates/"
+	TemplateFS = os.DirFS("./templates/")
+}
+
+// RegisterTemplates makes the templates in fsys available under the prefix,
+// this is used by plugins to ship their own templates
+func RegisterTemplates(prefix string, fsys fs.FS) {
+	templateSourcesLock.Lock()
+	defer templateSourcesLock.Unlock()
+
+	templateSources[prefix] = fsys
+}
+
+// templateFileSystems returns the core template file system followed by the
+// file systems of the plugins
+func templateFileSystems() []fs.FS {
+	templateSourcesLock.RLock()
+	defer templateSourcesLock.RUnlock()
+
+	sources := []fs.FS{TemplateFS}
+	for _, fsys := range templateSources {
+		sources = append(sources, fsys)
+	}
+
+	return sources
 }
 
-// Page structure that keeps the data of a page, these are used for rendering
-// pages.
+// resolveTemplate returns the file system and the path within it for the
+// provided template name
+func resolveTemplate(name string) (fs.FS, string) {
+	templateSourcesLock.RLock()
+	defer templateSourcesLock.RUnlock()
+
+	for prefix, fsys := range templateSources {
+		if strings.HasPrefix(name, prefix) {
+			return fsys, strings.TrimPrefix(name, prefix)
+		}
+	}
+
+	return TemplateFS, name
+}
+
+// parseTemplates parses the templates in order, the first template is the
+// one that is executed when rendering
+func parseTemplates(pages []string) (*template.Template, error) {
+	var root *template.Template
+
+	for _, page := range pages {
+		fsys, name := resolveTemplate(page)
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		var t *template.Template
+		if root == nil {
+			root = template.New(path.Base(page)).Funcs(templateFuncs)
+			t = root
+		} else {
+			t = root.New(path.Base(page))
+		}
+
+		_, err = t.Parse(string(content))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return root, nil
+}
+
+// Page is a layout together with the data of a single request, pages are
+// used for rendering and should not be shared between requests
 type Page struct {
-	Template *template.Template
-	Context  map[string]interface{}
+	Layout  *Layout
+	Context map[string]interface{}
 }
 
-// NewPage creates a new page in memory, it tries to parse the parent and body
-// html templates and if there is an error it returns no page and an error.
-// If it was succesfull the function populates Context with an empty
-// map[string]interface{}
+// NewPage creates a new page in memory for the templates, the templates are
+// parsed once and shared by every page of the same templates. Missing
+// templates are reported when the page is rendered, declare the layout with
+// NewLayout as a package variable to have them reported at startup instead
 func NewPage(pages []string) *Page {
-	p := Page{}
-	// Add template folder to pages
-	for i := 0; i < len(pages); i++ {
-		pages[i] = TemplateFolder + pages[i]
+	return NewLayout(pages...).NewPage()
+}
+
+// CheckTemplates parses every layout and every core and plugin template and
+// returns the first error it encounters, it is used to report the health of
+// the templates
+func CheckTemplates(ctx context.Context) error {
+	if _, err := fs.Stat(TemplateFS, "base.html"); err != nil {
+		return err
 	}
 
-	// Try to parse the files
-	var err error
-	p.Template, err = template.ParseFiles(pages...)
-	if err != nil {
-		if strings.Contains(err.Error(), "no such file") {
-			log.Fatal("PageParser", "Can't find file "+err.Error())
+	for _, l := range getLayouts() {
+		if _, err := l.Template(); err != nil {
+			return err
+		}
+	}
+
+	for _, fsys := range templateFileSystems() {
+		err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if entry.IsDir() || !strings.HasSuffix(name, ".html") {
+				return nil
+			}
+
+			_, err = template.New(path.Base(name)).Funcs(templateFuncs).ParseFS(fsys, name)
+			return err
+		})
+		if err != nil {
+			return err
 		}
-		log.Err("PageParser", "Failed to parse template "+err.Error())
 	}
-	p.Context = map[string]interface{}{}
 
-	return &p
+	return nil
 }
 
 // AddContext adds context to the page, this is passed to the templates when
@@ -59,13 +162,46 @@ func (p *Page) CleanContext() {
 	p.Context = map[string]interface{}{}
 }
 
-// Render renders the page to the io.Writer that is passed to it. It includes
-// the pages Context to load data into.
-// After rendering the page it cleans the context for the next request
-func (p *Page) Render(w io.Writer) {
-	err := p.Template.Execute(w, p.Context)
+// Render renders the page to the ResponseWriter that is passed to it. The
+// templates receive a copy of the pages Context together with the sidebar
+// menu, the logged in user and the csrf token forms have to send for the
+// request. The page is rendered to a buffer first so a failing template
+// results in an error page instead of half a page
+func (p *Page) Render(w http.ResponseWriter, r *http.Request) {
+	t, err := p.Layout.Template()
 	if err != nil {
-		log.Err("PageParser", err.Error())
+		p.renderFailed(w, r, err)
+		return
 	}
-	p.CleanContext()
+
+	data := make(map[string]interface{}, len(p.Context)+3)
+	for key, value := range p.Context {
+		data[key] = value
+	}
+	data["menu"] = BuildMenu(r)
+	if UserProvider != nil {
+		data["user"] = UserProvider(r)
+	}
+	data["csrf"] = middleware.CSRFToken(r)
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, data)
+	if err != nil {
+		p.renderFailed(w, r, err)
+		return
+	}
+
+	w.Write(buf.Bytes())
+}
+
+// renderFailed responds with the error page for a page that failed to
+// render, the error page itself falls back to plain text
+func (p *Page) renderFailed(w http.ResponseWriter, r *http.Request, err error) {
+	if p.Layout == errorLayout {
+		log.FromContext(r.Context()).Err("PageParser", err.Error())
+		http.Error(w, "Failed to render the page", http.StatusInternalServerError)
+		return
+	}
+
+	RenderError(w, r, InternalError(err))
 }
@@ -0,0 +1,100 @@
+package frame
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Schema is a json schema as it is used in the OpenAPI document
+type Schema map[string]interface{}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// SchemaOf returns the schema of the json encoding of v, a Schema is
+// returned as it is so endpoints can describe what reflection can't
+func SchemaOf(v interface{}) Schema {
+	if schema, ok := v.(Schema); ok {
+		return schema
+	}
+	if v == nil {
+		return Schema{}
+	}
+
+	return schemaOf(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+// schemaOf follows the rules of encoding/json, seen breaks recursive types
+func schemaOf(t reflect.Type, seen map[reflect.Type]bool) Schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := schemaOf(t.Elem(), seen)
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return Schema{"type": "string", "format": "byte"}
+		}
+		return Schema{"type": "array", "items": schemaOf(t.Elem(), seen)}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": schemaOf(t.Elem(), seen)}
+	case reflect.Struct:
+		if t == timeType {
+			return Schema{"type": "string", "format": "date-time"}
+		}
+		if seen[t] {
+			return Schema{"type": "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		properties := Schema{}
+		addProperties(t, properties, seen)
+		return Schema{"type": "object", "properties": properties}
+	}
+
+	// Interfaces can hold anything
+	return Schema{}
+}
+
+// addProperties adds the json fields of the struct to the properties, the
+// fields of embedded structs are added as if they were fields of t
+func addProperties(t reflect.Type, properties Schema, seen map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name, tagged := jsonName(field)
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && !tagged && field.Type.Kind() == reflect.Struct {
+			addProperties(field.Type, properties, seen)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		properties[name] = schemaOf(field.Type, seen)
+	}
+}
+
+// jsonName returns the name of the field in json and whether the json tag
+// named it
+func jsonName(field reflect.StructField) (string, bool) {
+	tag := strings.Split(field.Tag.Get("json"), ",")[0]
+	if tag == "" {
+		return field.Name, false
+	}
+
+	return tag, true
+}
@@ -1,8 +1,14 @@
 package frame
 
 import (
+	"context"
+	"io/fs"
+	"net"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/nielsvanm/homemanager/middleware"
 
@@ -10,12 +16,22 @@ import (
 	"github.com/nielsvanm/homemanager/tools/log"
 )
 
+// StaticFS is the file system that is served under /static/, typically
+// injected by the main.go file with the embedded static files
+var StaticFS fs.FS
+
+func init() {
+	StaticFS = os.DirFS("./static/")
+}
+
 // WebServer is an object responsible for
 type WebServer struct {
 
 	// Internal variables
-	router    *mux.Router
-	endpoints []*Endpoint
+	router     *mux.Router
+	endpoints  []*Endpoint
+	statics    map[string]fs.FS
+	middleware []middleware.Middleware
 }
 
 // NewWebServer creates a webserver struct with the provided
@@ -24,16 +40,35 @@ func NewWebServer() *WebServer {
 	wb := WebServer{
 		mux.NewRouter(),
 		[]*Endpoint{},
+		map[string]fs.FS{},
+		[]middleware.Middleware{},
 	}
 
 	return &wb
 }
 
-// RegisterEndpoint adds an endpoint to the router
-func (ws *WebServer) RegisterEndpoint(name string, function func(http.ResponseWriter, *http.Request)) {
+// AddStatic serves the files in fsys under the provided url prefix, it is
+// used to mount the assets of plugins under /static/plugins/{pluginname}/
+func (ws *WebServer) AddStatic(prefix string, fsys fs.FS) {
+	ws.statics[prefix] = fsys
+	mountStatic(prefix, fsys)
+}
+
+// Use adds middleware that wraps every request, including requests for
+// unknown pages. They run in the order they are added, before the middleware
+// of the endpoint
+func (ws *WebServer) Use(mw ...middleware.Middleware) {
+	ws.middleware = append(ws.middleware, mw...)
+}
+
+// RegisterEndpoint adds an endpoint to the router, the endpoint is returned
+// so a permission can be added to it
+func (ws *WebServer) RegisterEndpoint(name string, function func(http.ResponseWriter, *http.Request)) *Endpoint {
 	// Add to the map
 	endp := NewEndpoint(name, function)
 	ws.AddEndpoint(endp)
+
+	return endp
 }
 
 // AddEndpoint adds the provided enpoint to the list of known endpoints
@@ -46,8 +81,44 @@ func (ws *WebServer) AddEndpoints(endp []*Endpoint) {
 	ws.endpoints = append(ws.endpoints, endp...)
 }
 
-// Run starts the webserver on the provided port
-func (ws *WebServer) Run(port int) {
+// ShutdownTimeout is the time running requests get to finish when the server
+// is stopped
+var ShutdownTimeout = 10 * time.Second
+
+// Run starts the webserver on the provided port, it returns after ctx is done
+// and the running requests have finished
+func (ws *WebServer) Run(ctx context.Context, port int) {
+	handler := ws.Handler()
+
+	// Run server
+	server := &http.Server{
+		Addr:        ":" + strconv.Itoa(port),
+		Handler:     handler,
+		BaseContext: func(_ net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+		log.Info("WebServer", "Shutting down Webserver")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Warn("WebServer", "Failed to shut down gracefully", err.Error())
+		}
+	}()
+
+	log.Info("WebServer", "Running Webserver at port "+strconv.Itoa(port))
+	err := server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		log.Fatal("WebServer", err.Error())
+	}
+}
+
+// Handler registers the endpoints and static files to the router and returns
+// it, it is used by Run and by tests that serve the app with httptest
+func (ws *WebServer) Handler() http.Handler {
 	// Parse endpoints and register them to the router
 	if len(ws.endpoints) == 0 {
 		log.Warn("WebServer", "No endpoints found for server, i'll be useless")
@@ -55,28 +126,64 @@ func (ws *WebServer) Run(port int) {
 
 	for _, endp := range ws.endpoints {
 		log.Info("WebServer", "Registered Endpoint: "+endp.URL)
-		ws.router.HandleFunc(
-			endp.URL, endp.Function,
+		ws.router.Handle(
+			endp.URL, endp.handler(),
 		)
 	}
 
-	// TODO Make this dynamic, the static path and middlware
-	// Add static file handler
-	ws.router.PathPrefix("/static/").Handler(
-		http.StripPrefix("/static/", http.FileServer(http.Dir("./static/"))))
+	// Add static file handlers, the more specific plugin prefixes have to be
+	// registered before the general static handler
+	for prefix, fsys := range ws.statics {
+		log.Info("WebServer", "Registered static files: "+prefix)
+		ws.router.PathPrefix(prefix).Handler(staticHandler(prefix, fsys))
+	}
+	ws.router.PathPrefix(StaticPrefix).Handler(staticHandler(StaticPrefix, StaticFS))
 
-	ws.router.Use(middleware.LogHTTP)
+	ws.router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RenderError(w, r, NotFound("The page "+r.URL.Path+" does not exist"))
+	})
 
-	// Run server
-	log.Info("WebServer", "Running Webserver at port "+strconv.Itoa(port))
-	err := http.ListenAndServe(":"+strconv.Itoa(port), ws.router)
-	log.Fatal("WebServer", err.Error())
+	return middleware.Chain(ws.router, ws.middleware...)
+}
+
+// Route returns the url template of the endpoint or the prefix of the static
+// files the request is routed to, it labels the metrics of the request
+func (ws *WebServer) Route(r *http.Request) string {
+	var match mux.RouteMatch
+	if !ws.router.Match(r, &match) || match.Route == nil {
+		return middleware.UnmatchedRoute
+	}
+
+	route, err := match.Route.GetPathTemplate()
+	if err != nil {
+		return middleware.UnmatchedRoute
+	}
+
+	return route
 }
 
 // Endpoint represents an endpoint for the webapp
 type Endpoint struct {
 	URL      string
 	Function func(http.ResponseWriter, *http.Request)
+
+	// Methods are the http methods the endpoint accepts, other methods get
+	// 405 Method Not Allowed. Endpoints accept GET and HEAD by default
+	Methods []string
+
+	// Menu is set when the endpoint should be shown in the sidebar
+	Menu *MenuEntry
+
+	// Permission is required to visit the endpoint, everybody who is logged
+	// in can visit it when it is empty
+	Permission string
+
+	// Middleware only wraps this endpoint, it runs after the middleware of
+	// the webserver
+	Middleware []middleware.Middleware
+
+	// Doc describes api endpoints in the OpenAPI document, it is optional
+	Doc *EndpointDoc
 }
 
 // NewEndpoint is a constructor for the endoints
@@ -84,7 +191,81 @@ func NewEndpoint(URL string, function func(http.ResponseWriter, *http.Request))
 	endp := Endpoint{
 		URL,
 		function,
+		[]string{http.MethodGet, http.MethodHead},
+		nil,
+		"",
+		nil,
+		nil,
 	}
 
 	return &endp
 }
+
+// WithMenu shows the endpoint in the sidebar with the provided label and
+// font awesome icon classes
+func (endp *Endpoint) WithMenu(label, icon string) *Endpoint {
+	endp.Menu = &MenuEntry{label, icon}
+
+	return endp
+}
+
+// WithPermission only allows users that are granted the permission to visit
+// the endpoint
+func (endp *Endpoint) WithPermission(permission string) *Endpoint {
+	endp.Permission = permission
+
+	return endp
+}
+
+// WithMethods sets the http methods the endpoint accepts, HEAD is accepted
+// as well when GET is
+func (endp *Endpoint) WithMethods(methods ...string) *Endpoint {
+	endp.Methods = []string{}
+	for _, method := range methods {
+		endp.Methods = append(endp.Methods, method)
+		if method == http.MethodGet {
+			endp.Methods = append(endp.Methods, http.MethodHead)
+		}
+	}
+
+	return endp
+}
+
+// WithMiddleware wraps the endpoint with the middleware, the first one is the
+// outermost
+func (endp *Endpoint) WithMiddleware(mw ...middleware.Middleware) *Endpoint {
+	endp.Middleware = append(endp.Middleware, mw...)
+
+	return endp
+}
+
+// allowsMethod returns true when the endpoint accepts the method
+func (endp *Endpoint) allowsMethod(method string) bool {
+	for _, m := range endp.Methods {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handler returns the function of the endpoint, wrapped with the method and
+// permission checks and the middleware of the endpoint
+func (endp *Endpoint) handler() http.Handler {
+	e := *endp
+	allow := strings.Join(e.Methods, ", ")
+
+	return middleware.Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !e.allowsMethod(r.Method) {
+			w.Header().Set("Allow", allow)
+			RenderError(w, r, NewHTTPError(http.StatusMethodNotAllowed, r.Method+" is not allowed here, use "+allow, nil))
+			return
+		}
+		if !IsAuthorized(r, e.Permission) {
+			Forbidden(w, r)
+			return
+		}
+		e.Function(w, r)
+	}), e.Middleware...)
+}
@@ -0,0 +1,60 @@
+package frame
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// Widget is a card on the home dashboard, it renders a template fragment
+// with the data returned by its data provider. Widgets are loaded
+// asynchronously so a slow widget does not block the dashboard
+type Widget struct {
+	Name     string
+	Title    string
+	Template string
+
+	// Data provides the context for the template, it is available in the
+	// template as .data
+	Data func(r *http.Request) (interface{}, error)
+
+	// URL is where the rendered widget can be retrieved, it is set when the
+	// widget is registered
+	URL string
+
+	// layout is the parsed template of the widget
+	layout *Layout
+}
+
+// NewWidget is a constructor for the widgets
+func NewWidget(name, title, template string, data func(r *http.Request) (interface{}, error)) *Widget {
+	widget := Widget{
+		name,
+		title,
+		template,
+		data,
+		"",
+		NewLayout(template),
+	}
+
+	return &widget
+}
+
+// Render writes the widget's template to the response, errors from the data
+// provider are rendered as an alert inside the card
+func (wg *Widget) Render(w http.ResponseWriter, r *http.Request) {
+	if wg.Data == nil {
+		wg.layout.NewPage().Render(w, r)
+		return
+	}
+
+	data, err := wg.Data(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`<div class="alert alert-danger" role="alert">` + template.HTMLEscapeString(err.Error()) + `</div>`))
+		return
+	}
+
+	page := wg.layout.NewPage()
+	page.AddContext("data", data)
+	page.Render(w, r)
+}
@@ -0,0 +1,125 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nielsvanm/homemanager/tools/clock"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// Status values reported by checks and reports
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+)
+
+// Timeout is the maximum amount of time a single check may take
+var Timeout = 2 * time.Second
+
+var checks = []*Check{}
+var checksLock sync.Mutex
+
+// Check is a single component that can report its health
+type Check struct {
+	Name string
+
+	// Critical checks make the app unready when they fail, non critical
+	// checks only degrade the status
+	Critical bool
+
+	Function func(ctx context.Context) error
+}
+
+// Result is the outcome of a single check
+type Result struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Critical bool    `json:"critical"`
+	Latency  float64 `json:"latency_ms"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Report is the aggregated outcome of all the checks
+type Report struct {
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+	Checks    []Result  `json:"checks"`
+}
+
+// Register adds a check that is executed for every report
+func Register(name string, critical bool, function func(ctx context.Context) error) {
+	checksLock.Lock()
+	defer checksLock.Unlock()
+
+	checks = append(checks, &Check{name, critical, function})
+}
+
+// Run executes all registered checks concurrently and aggregates the
+// results into a report
+func Run(ctx context.Context) Report {
+	checksLock.Lock()
+	current := make([]*Check, len(checks))
+	copy(current, checks)
+	checksLock.Unlock()
+
+	results := make([]Result, len(current))
+	var wg sync.WaitGroup
+	for i, check := range current {
+		wg.Add(1)
+		go func(i int, check *Check) {
+			defer wg.Done()
+			results[i] = check.run(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{StatusOK, clock.Now().UTC(), results}
+	for _, res := range results {
+		if res.Status == StatusOK {
+			continue
+		}
+		if res.Critical {
+			report.Status = StatusDown
+			break
+		}
+		report.Status = StatusDegraded
+	}
+
+	return report
+}
+
+// run executes the check with a timeout and measures how long it took
+func (c *Check) run(ctx context.Context) Result {
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Function(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	res := Result{
+		Name:     c.Name,
+		Status:   StatusOK,
+		Critical: c.Critical,
+		Latency:  float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		res.Status = StatusDown
+		res.Error = err.Error()
+		log.Warn("Health", "Check "+c.Name+" failed", err.Error())
+	}
+
+	return res
+}
@@ -1,80 +1,335 @@
 package main
 
 import (
+	"context"
+	"embed"
 	"flag"
+	"io"
+	"io/fs"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/nielsvanm/homemanager/api"
+	"github.com/nielsvanm/homemanager/auth"
 	"github.com/nielsvanm/homemanager/database"
 	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/health"
+	"github.com/nielsvanm/homemanager/middleware"
 	"github.com/nielsvanm/homemanager/plugin"
+	"github.com/nielsvanm/homemanager/settings"
+	"github.com/nielsvanm/homemanager/storage"
 	"github.com/nielsvanm/homemanager/tools/log"
+	"github.com/nielsvanm/homemanager/tools/scaffold"
 	"github.com/nielsvanm/homemanager/views"
 )
 
+//go:embed templates static
+var embeddedFiles embed.FS
+
 var serverPort = 8080
 var server *frame.WebServer
 var db *database.DB
+var disabledPlugins string
+var devMode bool
+var maxBodySize int64 = 10 << 20
+var requestTimeout = time.Minute
+var accessLogPath = "access.log"
+var accessLogFormat = middleware.FormatCombined
+var accessLogExclude = "/static/"
+var accessLogSize int64 = 10
+var accessLogBackups = 5
+var trustedProxies string
+var basePath string
+var apiRateLimit = 10.0
+var apiBurst = 50
 
-func init() {
+// setup initialises the app, it runs after the command line flags have been
+// parsed so they can influence the setup
+func setup() {
 	// General app setup
+	// Use the embedded templates and static files so the binary runs from
+	// any working directory
+	frame.TemplateFS, _ = fs.Sub(embeddedFiles, "templates")
+	frame.StaticFS, _ = fs.Sub(embeddedFiles, "static")
+	if devMode {
+		frame.TemplateFS = os.DirFS("./templates/")
+		frame.StaticFS = os.DirFS("./static/")
+	}
+
 	// Setup database for webapp
 	db = database.NewDB("postgres", "SuperSecure8", "homemanager", "127.0.0.1", 5432)
 	db.Connect()
 
 	// Setup pluginmanager and plugins
+	for _, p := range plugin.PluginManager.Plugins {
+		for _, name := range strings.Split(disabledPlugins, ",") {
+			if strings.EqualFold(p.Name, strings.TrimSpace(name)) {
+				p.Disable()
+			}
+		}
+	}
 	plugin.PluginManager.DB = db
 	plugin.PluginManager.Setup()
 	database.Database = db
+	if devMode {
+		useDevTemplates()
+	}
+
+	// Parse every template now so missing templates are found right away
+	err := frame.LoadTemplates()
+	if err != nil {
+		log.Fatal("PageParser", err.Error())
+	}
 
 	// Create database tables
-	queries := plugin.PluginManager.GetSetupQueries()
-	db.CreateTables(queries)
+	queries := append([]string{}, auth.SetupDB...)
+	queries = append(queries, auth.TokenSetupDB...)
+	queries = append(queries, settings.SetupDB...)
+	queries = append(queries, plugin.PluginManager.GetSetupQueries()...)
+	err = db.CreateTables(queries)
+	if err != nil {
+		log.Err("Database", "Failed to create the tables,", err.Error())
+		db.Close()
+		os.Exit(1)
+	}
+	plugin.PluginManager.LoadState()
+
+	// Register health checks
+	health.Register("database", true, db.Ping)
+	health.Register("templates", true, frame.CheckTemplates)
+	plugin.PluginManager.RegisterHealthChecks()
+
+	// Register the topics pages subscribe to for live updates
+	views.RegisterTopics()
+	plugin.PluginManager.RegisterTopics()
+
+	// Publish the metrics of the plugins on /metrics
+	plugin.PluginManager.RegisterMetrics()
 
 	// Create server manager
 	server = frame.NewWebServer()
+	frame.MenuProvider = views.Menu
+	frame.UserProvider = views.CurrentUser
+	frame.Authorizer = auth.Authorize
+	middleware.CSRFExempt = auth.IsTokenRequest
+	err = middleware.SetTrustedProxies(strings.Split(trustedProxies, ","))
+	if err != nil {
+		log.Fatal("Main", err.Error())
+	}
+	err = middleware.SetBasePath(basePath)
+	if err != nil {
+		log.Fatal("Main", err.Error())
+	}
+
+	pipeline := []middleware.Middleware{middleware.RequestID, middleware.Forwarded}
+	if accessLog := newAccessLog(); accessLog != nil {
+		// Outside of Recover so panics are logged with their 500
+		pipeline = append(pipeline, accessLog.Middleware)
+	}
+	pipeline = append(pipeline,
+		middleware.StripBasePath,
+		middleware.Metrics(server.Route),
+		middleware.Recover,
+		middleware.Gzip,
+		middleware.Timeout(requestTimeout),
+		middleware.BodyLimit(maxBodySize),
+		auth.Middleware,
+		middleware.RateLimit(middleware.NewLimiter(apiRateLimit, apiBurst), apiRateLimitKey),
+		middleware.CSRF,
+	)
+	server.Use(pipeline...)
+
+	// Slow down password guessing, failed logins lock out on top of this.
+	// Only submitted forms count, loading the pages is free
+	loginLimit := middleware.RateLimit(middleware.NewLimiter(0.2, 10), middleware.ForMethods(middleware.ByIP, http.MethodPost))
 
 	// Setup global enpoints
 	server.RegisterEndpoint("/", views.DashboardView)
+	server.RegisterEndpoint("/login/", views.LoginView).WithMethods(http.MethodGet, http.MethodPost).WithMiddleware(loginLimit)
+	server.RegisterEndpoint("/logout/", views.LogoutView).WithMethods(http.MethodPost)
+	server.RegisterEndpoint("/setup/", views.SetupView).WithMethods(http.MethodGet, http.MethodPost).WithMiddleware(loginLimit)
+	server.RegisterEndpoint("/widgets/{pluginname}/{widgetname}/", frame.Handle(views.WidgetView))
 	server.RegisterEndpoint("/stats/", views.StatisticsView)
 	server.RegisterEndpoint("/stats/processorcount/", views.ProcessorCountView)
 	server.RegisterEndpoint("/stats/memory/", views.MemoryStatView)
 	server.RegisterEndpoint("/stats/plugincount/", views.PluginCountView)
 	server.RegisterEndpoint("/stats/logsize/", views.LogSizeView)
-	server.RegisterEndpoint("/database/", views.DatabaseView)
-	server.RegisterEndpoint("/database/create/{pluginname}/", views.CreateTablesView)
-	server.RegisterEndpoint("/database/drop/{pluginname}/", views.DropTablesView)
+	server.RegisterEndpoint("/storage/", views.StorageView).WithPermission(auth.PermViewStorage)
+	server.RegisterEndpoint("/plugins/", views.PluginsView).WithPermission(auth.PermViewPlugins)
+	server.RegisterEndpoint("/plugins/run/{pluginname}/", frame.Handle(views.RunPluginView)).WithMethods(http.MethodPost).WithPermission(auth.PermRunPlugins)
+	server.RegisterEndpoint("/plugins/jobs/{jobid}/events/", frame.Handle(views.JobEventsView)).WithPermission(auth.PermRunPlugins)
+	server.RegisterEndpoint("/plugins/jobs/{jobid}/cancel/", frame.Handle(views.CancelJobView)).WithMethods(http.MethodPost).WithPermission(auth.PermRunPlugins)
+	server.RegisterEndpoint("/events/", frame.Handle(frame.Events.EventsView))
+	server.RegisterEndpoint("/healthz", views.HealthzView)
+	server.RegisterEndpoint("/readyz", views.ReadyzView)
+	server.RegisterEndpoint("/metrics", views.MetricsView).WithPermission(auth.PermViewMetrics)
+	server.RegisterEndpoint("/database/", views.DatabaseView).WithPermission(auth.PermManageDatabase)
+	server.RegisterEndpoint("/database/create/{pluginname}/", frame.Handle(views.CreateTablesView)).WithMethods(http.MethodPost).WithPermission(auth.PermManageDatabase)
+	server.RegisterEndpoint("/database/truncate/{pluginname}/", frame.Handle(views.TruncateTablesView)).WithMethods(http.MethodGet, http.MethodPost).WithPermission(auth.PermManageDatabase)
+	server.RegisterEndpoint("/database/drop/{pluginname}/", frame.Handle(views.DropTablesView)).WithMethods(http.MethodGet, http.MethodPost).WithPermission(auth.PermManageDatabase)
+	server.RegisterEndpoint("/users/", views.UsersView).WithPermission(auth.PermManageUsers)
+	server.RegisterEndpoint("/users/create/", views.CreateUserView).WithMethods(http.MethodPost).WithPermission(auth.PermManageUsers)
+	server.RegisterEndpoint("/users/{userid}/role/", frame.Handle(views.UserRoleView)).WithMethods(http.MethodPost).WithPermission(auth.PermManageUsers)
+	server.RegisterEndpoint("/users/{userid}/delete/", frame.Handle(views.DeleteUserView)).WithMethods(http.MethodPost, http.MethodDelete).WithPermission(auth.PermManageUsers)
+	server.RegisterEndpoint("/tokens/", views.TokensView).WithPermission(auth.PermAPITokens)
+	server.RegisterEndpoint("/tokens/create/", views.CreateTokenView).WithMethods(http.MethodPost).WithPermission(auth.PermAPITokens)
+	server.RegisterEndpoint("/tokens/{tokenid}/revoke/", frame.Handle(views.RevokeTokenView)).WithMethods(http.MethodPost, http.MethodDelete).WithPermission(auth.PermAPITokens)
+
+	// Setup the versioned json api and its documentation
+	server.AddEndpoints(api.Endpoints)
+	server.RegisterEndpoint(frame.OpenAPIURL, server.OpenAPIView)
+	server.RegisterEndpoint("/apidocs/", server.APIDocsView)
+	frame.APISecuritySchemes = map[string]frame.Schema{
+		"token":   {"type": "http", "scheme": "bearer", "description": "A personal api token, created on the API tokens page"},
+		"session": {"type": "apiKey", "in": "cookie", "name": auth.CookieName},
+	}
 
-	// Setup plugin endpoints
+	// Setup plugin endpoints and static files
 	server.AddEndpoints(
 		plugin.PluginManager.GetEndpoints(),
 	)
+	for prefix, fsys := range plugin.PluginManager.GetStatics() {
+		server.AddStatic(prefix, fsys)
+	}
+	if devMode {
+		for _, p := range plugin.PluginManager.Plugins {
+			dir := filepath.Join("plugin", strings.ToLower(p.Name), "static")
+			if _, err := os.Stat(dir); err == nil {
+				server.AddStatic("/static/plugins/"+strings.ToLower(p.Name)+"/", os.DirFS(dir))
+			}
+		}
+	}
+}
+
+// useDevTemplates serves the plugin templates from the source folders
+// instead of the embedded copies, so changes show up without rebuilding
+func useDevTemplates() {
+	for _, p := range plugin.PluginManager.Plugins {
+		dir := filepath.Join("plugin", strings.ToLower(p.Name), "templates")
+		if _, err := os.Stat(dir); err == nil {
+			frame.RegisterTemplates("plugins/"+strings.ToLower(p.Name)+"/", os.DirFS(dir))
+		}
+	}
+}
+
+// apiRateLimitKey rate limits the api by token or user, other paths are left
+// alone
+func apiRateLimitKey(r *http.Request) string {
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		return ""
+	}
+
+	return auth.RateLimitKey(r)
+}
+
+// newAccessLog returns the access log configured with the command line
+// flags, it is nil when the access log is disabled
+func newAccessLog() *middleware.AccessLog {
+	if accessLogPath == "" {
+		return nil
+	}
+
+	var out io.Writer = os.Stdout
+	if accessLogPath != "-" {
+		out = log.NewRotatingFile(accessLogPath, accessLogSize*1000*1000, accessLogBackups)
+	}
+
+	exclude := []string{}
+	for _, prefix := range strings.Split(accessLogExclude, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			exclude = append(exclude, prefix)
+		}
+	}
+
+	accessLog, err := middleware.NewAccessLog(out, accessLogFormat, exclude)
+	if err != nil {
+		log.Fatal("Main", err.Error())
+	}
+
+	return accessLog
 }
 
 func main() {
+	// homemanager plugin new <name> --category X
+	if len(os.Args) > 1 && os.Args[1] == "plugin" {
+		err := scaffold.Run(os.Args[2:])
+		if err != nil {
+			log.Err("Scaffold", err.Error())
+			os.Exit(-1)
+		}
+		os.Exit(0)
+	}
+
+	// homemanager token create --user <username> --name <name>
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		setup()
+		err := auth.RunTokenCommand(os.Args[2:])
+		if err != nil {
+			log.Err("Token", err.Error())
+			os.Exit(-1)
+		}
+		os.Exit(0)
+	}
+
 	str := flag.String("runplugin", "", "--runplugin <pluginname>")
+	flag.StringVar(&storage.Root, "dataroot", storage.Root, "--dataroot <folder where plugin data is stored>")
+	flag.StringVar(&disabledPlugins, "disableplugins", "", "--disableplugins <comma separated plugin names>")
+	flag.BoolVar(&auth.SecureCookies, "securecookies", auth.SecureCookies, "--securecookies only send the session cookie over https")
+	flag.BoolVar(&devMode, "dev", false, "--dev serve templates and static files from the source folders and reload changed templates")
+	flag.Int64Var(&maxBodySize, "maxbodysize", maxBodySize, "--maxbodysize <largest request body in bytes>")
+	flag.DurationVar(&requestTimeout, "requesttimeout", requestTimeout, "--requesttimeout <time a request may take, e.g. 30s>")
+	flag.StringVar(&accessLogPath, "accesslog", accessLogPath, "--accesslog <file for the access log, - for stdout, empty to disable>")
+	flag.StringVar(&accessLogFormat, "accesslogformat", accessLogFormat, "--accesslogformat <common, combined or json>")
+	flag.StringVar(&accessLogExclude, "accesslogexclude", accessLogExclude, "--accesslogexclude <comma separated path prefixes that are not logged>")
+	flag.Int64Var(&accessLogSize, "accesslogsize", accessLogSize, "--accesslogsize <megabytes before the access log is rotated>")
+	flag.IntVar(&accessLogBackups, "accesslogbackups", accessLogBackups, "--accesslogbackups <amount of rotated access logs to keep>")
+	flag.StringVar(&trustedProxies, "trustedproxies", "", "--trustedproxies <comma separated addresses or networks of reverse proxies, e.g. 127.0.0.1,10.0.0.0/8>")
+	flag.StringVar(&basePath, "basepath", "", "--basepath <path the app is served under behind a reverse proxy, e.g. /manager>")
+	flag.Float64Var(&apiRateLimit, "apiratelimit", apiRateLimit, "--apiratelimit <api requests per second per client, 0 disables the limit>")
+	flag.IntVar(&apiBurst, "apiburst", apiBurst, "--apiburst <api requests a client may make at once>")
+	flag.IntVar(&plugin.PluginManager.Workers, "workers", plugin.PluginManager.Workers, "--workers <amount of plugins that may run at once>")
 
 	flag.Parse()
 
+	// Stop running plugins and the webserver on ctrl+c or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	plugin.PluginManager.Context = ctx
+
+	setup()
+	if devMode {
+		go frame.WatchTemplates(ctx, time.Second)
+	}
+
 	if *str != "" {
-		RunSinglePlugin(*str, db)
+		RunSinglePlugin(ctx, *str)
 	}
 
 	// Start webserver
-	server.Run(serverPort)
+	server.Run(ctx, serverPort)
+
+	// Give the running plugins time to store their run history before the
+	// database is closed
+	plugin.PluginManager.Shutdown(frame.ShutdownTimeout)
+	db.Close()
 }
 
 // RunSinglePlugin runs a single plugin for one iteration
-func RunSinglePlugin(name string, db *database.DB) {
+func RunSinglePlugin(ctx context.Context, name string) {
 
-	for _, plugin := range plugin.PluginManager.Plugins {
-		if strings.ToLower(plugin.Name) == name {
-			batches := plugin.Main()
+	for _, p := range plugin.PluginManager.Plugins {
+		if strings.ToLower(p.Name) == name {
+			job := plugin.PluginManager.StartRun(ctx, p)
+			job.Wait()
 
-			for _, batch := range batches {
-				db.ExecBatch(batch)
+			if status, _ := job.Result(); status != plugin.JobFinished {
+				os.Exit(-1)
 			}
-
 			os.Exit(0)
 		}
 	}
@@ -0,0 +1,264 @@
+package metrics
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// DefaultBuckets are the upper bounds in seconds of histograms that time
+// requests and queries
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// vector keeps a series for every combination of label values
+type vector struct {
+	desc Desc
+
+	lock   sync.Mutex
+	series map[string]*series
+}
+
+// series is a single combination of label values, histograms count the
+// observations per bucket in counts
+type series struct {
+	labels []string
+	value  float64
+	counts []uint64
+	count  uint64
+}
+
+func newVector(name, help, typ string, labels []string) vector {
+	return vector{
+		Desc{name, help, typ, labels},
+		sync.Mutex{},
+		map[string]*series{},
+	}
+}
+
+// Desc returns the description of the metric
+func (v *vector) Desc() Desc {
+	return v.desc
+}
+
+// update calls fn with the series of the label values while holding the lock
+func (v *vector) update(labelValues []string, fn func(s *series)) {
+	if len(labelValues) != len(v.desc.Labels) {
+		log.Warn("Metrics", "Metric", v.desc.Name, "expects", strconv.Itoa(len(v.desc.Labels)), "label values, got", strconv.Itoa(len(labelValues)))
+		return
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labels: append([]string{}, labelValues...)}
+		v.series[key] = s
+	}
+	fn(s)
+}
+
+// sorted returns copies of the series ordered by their label values, so the
+// output stays the same between scrapes
+func (v *vector) sorted() []series {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	list := make([]series, 0, len(keys))
+	for _, key := range keys {
+		s := *v.series[key]
+		s.counts = append([]uint64{}, s.counts...)
+		list = append(list, s)
+	}
+
+	return list
+}
+
+func (v *vector) labels(values []string) []Label {
+	labels := make([]Label, len(values))
+	for i, value := range values {
+		labels[i] = Label{v.desc.Labels[i], value}
+	}
+
+	return labels
+}
+
+// Counter is a value that only goes up, such as the amount of requests
+type Counter struct {
+	vector
+}
+
+// NewCounter creates a counter with the label names, it has to be
+// registered before it shows up
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{newVector(name, help, TypeCounter, labels)}
+}
+
+// Inc adds one to the counter of the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds the value to the counter of the label values, counters can't
+// decrease so negative values are ignored
+func (c *Counter) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		log.Warn("Metrics", "Counter", c.desc.Name, "can't decrease")
+		return
+	}
+
+	c.update(labelValues, func(s *series) { s.value += value })
+}
+
+// Collect returns the value of every combination of label values
+func (c *Counter) Collect(ctx context.Context) []Sample {
+	samples := []Sample{}
+	for _, s := range c.sorted() {
+		samples = append(samples, Sample{"", c.labels(s.labels), s.value})
+	}
+
+	return samples
+}
+
+// Gauge is a value that goes up and down, such as the amount of running jobs
+type Gauge struct {
+	vector
+}
+
+// NewGauge creates a gauge with the label names, it has to be registered
+// before it shows up
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{newVector(name, help, TypeGauge, labels)}
+}
+
+// Set sets the gauge of the label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.update(labelValues, func(s *series) { s.value = value })
+}
+
+// Add adds the value to the gauge of the label values, it may be negative
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	g.update(labelValues, func(s *series) { s.value += value })
+}
+
+// Inc adds one to the gauge of the label values
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+// Dec subtracts one from the gauge of the label values
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// Collect returns the value of every combination of label values
+func (g *Gauge) Collect(ctx context.Context) []Sample {
+	samples := []Sample{}
+	for _, s := range g.sorted() {
+		samples = append(samples, Sample{"", g.labels(s.labels), s.value})
+	}
+
+	return samples
+}
+
+// Histogram counts observations, such as durations, in buckets
+type Histogram struct {
+	vector
+	buckets []float64
+}
+
+// NewHistogram creates a histogram with the upper bounds of its buckets and
+// the label names, it has to be registered before it shows up
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+
+	return &Histogram{newVector(name, help, TypeHistogram, labels), sorted}
+}
+
+// Observe adds the value to the histogram of the label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	bucket := sort.SearchFloat64s(h.buckets, value)
+
+	h.update(labelValues, func(s *series) {
+		if s.counts == nil {
+			s.counts = make([]uint64, len(h.buckets))
+		}
+		if bucket < len(h.buckets) {
+			s.counts[bucket]++
+		}
+		s.count++
+		s.value += value
+	})
+}
+
+// Collect returns the cumulative buckets, sum and count of every combination
+// of label values
+func (h *Histogram) Collect(ctx context.Context) []Sample {
+	samples := []Sample{}
+	for _, s := range h.sorted() {
+		labels := h.labels(s.labels)
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			le := append(append([]Label{}, labels...), Label{"le", formatValue(bound)})
+			samples = append(samples, Sample{"_bucket", le, float64(cumulative)})
+		}
+		le := append(append([]Label{}, labels...), Label{"le", "+Inf"})
+		samples = append(samples,
+			Sample{"_bucket", le, float64(s.count)},
+			Sample{"_sum", labels, s.value},
+			Sample{"_count", labels, float64(s.count)},
+		)
+	}
+
+	return samples
+}
+
+// Func is a metric without labels that is read when it is collected, such as
+// the size of a table. The metric is left out when the function fails
+type Func struct {
+	desc     Desc
+	function func(ctx context.Context) (float64, error)
+}
+
+// NewGaugeFunc creates a gauge that calls the function for its value
+func NewGaugeFunc(name, help string, function func(ctx context.Context) (float64, error)) *Func {
+	return &Func{Desc{name, help, TypeGauge, nil}, function}
+}
+
+// NewCounterFunc creates a counter that calls the function for its value,
+// the function should only return values that are at least as large as the
+// previous one
+func NewCounterFunc(name, help string, function func(ctx context.Context) (float64, error)) *Func {
+	return &Func{Desc{name, help, TypeCounter, nil}, function}
+}
+
+// Desc returns the description of the metric
+func (f *Func) Desc() Desc {
+	return f.desc
+}
+
+// Collect calls the function of the metric
+func (f *Func) Collect(ctx context.Context) []Sample {
+	value, err := f.function(ctx)
+	if err != nil {
+		log.Warn("Metrics", "Failed to collect", f.desc.Name, err.Error())
+		return nil
+	}
+
+	return []Sample{{"", nil, value}}
+}
@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric types of the Prometheus text format
+const (
+	TypeCounter   = "counter"
+	TypeGauge     = "gauge"
+	TypeHistogram = "histogram"
+)
+
+// ContentType is the content type of the Prometheus text format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var metricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+var registry = map[string]Metric{}
+var registryLock sync.RWMutex
+
+// Metric is a set of samples that share a name, help text and type
+type Metric interface {
+	Desc() Desc
+
+	// Collect returns the current samples of the metric, it should return
+	// quickly when the context is done
+	Collect(ctx context.Context) []Sample
+}
+
+// Desc describes a metric, Labels are the names of its labels
+type Desc struct {
+	Name   string
+	Help   string
+	Type   string
+	Labels []string
+}
+
+// Sample is a single value of a metric, Suffix is added to the name of the
+// metric, histograms use it for their _bucket, _sum and _count samples
+type Sample struct {
+	Suffix string
+	Labels []Label
+	Value  float64
+}
+
+// Label is the name and value of a label of a sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Register makes the metrics available on /metrics, nothing is registered
+// when one of the names is invalid or already taken
+func Register(metrics ...Metric) error {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	names := map[string]bool{}
+	for _, metric := range metrics {
+		desc := metric.Desc()
+		err := desc.validate()
+		if err != nil {
+			return err
+		}
+		if _, ok := registry[desc.Name]; ok || names[desc.Name] {
+			return fmt.Errorf("metric %s is already registered", desc.Name)
+		}
+		names[desc.Name] = true
+	}
+
+	for _, metric := range metrics {
+		registry[metric.Desc().Name] = metric
+	}
+
+	return nil
+}
+
+// MustRegister is Register for the metrics of the app itself, it panics
+// when they can't be registered
+func MustRegister(metrics ...Metric) {
+	err := Register(metrics...)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Write collects every registered metric and writes them sorted by name in
+// the Prometheus text format
+func Write(ctx context.Context, w io.Writer) error {
+	registryLock.RLock()
+	metrics := make([]Metric, 0, len(registry))
+	for _, metric := range registry {
+		metrics = append(metrics, metric)
+	}
+	registryLock.RUnlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Desc().Name < metrics[j].Desc().Name })
+
+	buf := bufio.NewWriter(w)
+	for _, metric := range metrics {
+		desc := metric.Desc()
+		fmt.Fprintf(buf, "# HELP %s %s\n", desc.Name, helpEscaper.Replace(desc.Help))
+		fmt.Fprintf(buf, "# TYPE %s %s\n", desc.Name, desc.Type)
+
+		for _, sample := range metric.Collect(ctx) {
+			buf.WriteString(desc.Name + sample.Suffix)
+			writeLabels(buf, sample.Labels)
+			buf.WriteString(" " + formatValue(sample.Value) + "\n")
+		}
+	}
+
+	return buf.Flush()
+}
+
+// validate checks the names of the metric and its labels
+func (d Desc) validate() error {
+	if !metricName.MatchString(d.Name) {
+		return fmt.Errorf("invalid metric name %q", d.Name)
+	}
+
+	switch d.Type {
+	case TypeCounter, TypeGauge, TypeHistogram:
+	default:
+		return fmt.Errorf("metric %s has unknown type %q", d.Name, d.Type)
+	}
+
+	for _, label := range d.Labels {
+		if !labelName.MatchString(label) || strings.HasPrefix(label, "__") {
+			return fmt.Errorf("metric %s has invalid label name %q", d.Name, label)
+		}
+		if d.Type == TypeHistogram && label == "le" {
+			return fmt.Errorf("histogram %s can't use the le label", d.Name)
+		}
+	}
+
+	return nil
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func writeLabels(buf *bufio.Writer, labels []Label) {
+	if len(labels) == 0 {
+		return
+	}
+
+	buf.WriteString("{")
+	for i, label := range labels {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(label.Name + `="` + labelEscaper.Replace(label.Value) + `"`)
+	}
+	buf.WriteString("}")
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/nielsvanm/homemanager/tools/clock"
+)
+
+// startTime is when the process started, as far as the app can tell
+var startTime = clock.Now()
+
+// memStats caches runtime.ReadMemStats for a second, it stops the world and
+// every memory metric of a scrape needs it
+var memStats runtime.MemStats
+var memStatsRead time.Time
+var memStatsLock sync.Mutex
+
+func init() {
+	info := NewGauge("go_info", "Information about the Go environment", "version")
+	info.Set(1, runtime.Version())
+
+	MustRegister(
+		info,
+		NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist", func(ctx context.Context) (float64, error) {
+			return float64(runtime.NumGoroutine()), nil
+		}),
+		NewGaugeFunc("process_start_time_seconds", "Start time of the process since the unix epoch in seconds", func(ctx context.Context) (float64, error) {
+			return float64(startTime.UnixNano()) / 1e9, nil
+		}),
+		memStat(TypeGauge, "go_memstats_alloc_bytes", "Number of heap bytes allocated and still in use", func(m *runtime.MemStats) float64 {
+			return float64(m.Alloc)
+		}),
+		memStat(TypeCounter, "go_memstats_alloc_bytes_total", "Total number of heap bytes allocated, even if freed", func(m *runtime.MemStats) float64 {
+			return float64(m.TotalAlloc)
+		}),
+		memStat(TypeGauge, "go_memstats_sys_bytes", "Number of bytes obtained from the system", func(m *runtime.MemStats) float64 {
+			return float64(m.Sys)
+		}),
+		memStat(TypeGauge, "go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use", func(m *runtime.MemStats) float64 {
+			return float64(m.HeapInuse)
+		}),
+		memStat(TypeGauge, "go_memstats_heap_objects", "Number of allocated objects", func(m *runtime.MemStats) float64 {
+			return float64(m.HeapObjects)
+		}),
+		memStat(TypeCounter, "go_gc_cycles_total", "Number of completed garbage collection cycles", func(m *runtime.MemStats) float64 {
+			return float64(m.NumGC)
+		}),
+		memStat(TypeCounter, "go_gc_pause_seconds_total", "Total time the garbage collector stopped the world", func(m *runtime.MemStats) float64 {
+			return float64(m.PauseTotalNs) / 1e9
+		}),
+	)
+}
+
+// memStat creates a metric that reads a field of the memory statistics
+func memStat(typ, name, help string, field func(m *runtime.MemStats) float64) *Func {
+	return &Func{Desc{name, help, typ, nil}, func(ctx context.Context) (float64, error) {
+		memStatsLock.Lock()
+		defer memStatsLock.Unlock()
+
+		if time.Since(memStatsRead) > time.Second {
+			runtime.ReadMemStats(&memStats)
+			memStatsRead = time.Now()
+		}
+
+		return field(&memStats), nil
+	}}
+}
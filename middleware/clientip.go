@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the networks of the reverse proxies in front of the
+// server, only they are believed when they send an X-Forwarded-For header
+var TrustedProxies []*net.IPNet
+
+// SetTrustedProxies parses a list of addresses and networks in CIDR
+// notation, such as 127.0.0.1 or 10.0.0.0/8, into TrustedProxies
+func SetTrustedProxies(proxies []string) error {
+	networks := []*net.IPNet{}
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %s", proxy, err.Error())
+		}
+		networks = append(networks, network)
+	}
+
+	TrustedProxies = networks
+	return nil
+}
+
+// ClientIP returns the address of the client. When the request comes from a
+// trusted proxy the X-Forwarded-For header is read from right to left and the
+// first address that isn't a trusted proxy is the client
+func ClientIP(r *http.Request) string {
+	ip := remoteHost(r)
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(hop) == nil {
+			// Anything before a malformed entry can't be trusted
+			break
+		}
+
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+func isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range TrustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	previous := TrustedProxies
+	defer func() { TrustedProxies = previous }()
+
+	err := SetTrustedProxies([]string{"127.0.0.1", " 10.0.0.0/8", "", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded []string
+		ip        string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"spoofed by untrusted peer", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "127.0.0.1:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted ipv6 proxy", "[::1]:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"chain of proxies", "127.0.0.1:5000", []string{"198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"spoofed before the client", "127.0.0.1:5000", []string{"192.0.2.66, 198.51.100.1"}, "198.51.100.1"},
+		{"multiple headers", "127.0.0.1:5000", []string{"192.0.2.66", "198.51.100.1"}, "198.51.100.1"},
+		{"malformed entry", "127.0.0.1:5000", []string{"192.0.2.66, garbage, 10.0.0.2"}, "10.0.0.2"},
+		{"no header from proxy", "127.0.0.1:5000", nil, "127.0.0.1"},
+		{"only proxies", "127.0.0.1:5000", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = test.remote
+		for _, header := range test.forwarded {
+			r.Header.Add("X-Forwarded-For", header)
+		}
+
+		if ip := ClientIP(r); ip != test.ip {
+			t.Errorf("%s: expected %s, got %s", test.name, test.ip, ip)
+		}
+	}
+}
+
+func TestSetTrustedProxies(t *testing.T) {
+	previous := TrustedProxies
+	defer func() { TrustedProxies = previous }()
+
+	for _, invalid := range []string{"localhost", "10.0.0.0/33", "10.0.0"} {
+		if err := SetTrustedProxies([]string{invalid}); err == nil {
+			t.Errorf("expected %q to be refused", invalid)
+		}
+	}
+
+	if err := SetTrustedProxies(nil); err != nil || len(TrustedProxies) != 0 {
+		t.Errorf("expected no trusted proxies, got %v %v", TrustedProxies, err)
+	}
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// CSRFCookie is the cookie that holds the csrf token of the browser
+const CSRFCookie = "homemanager_csrf"
+
+// CSRFField is the form field and CSRFHeader the header a request can send
+// the csrf token in
+const (
+	CSRFField  = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+type csrfKey struct{}
+
+// CSRFExempt reports if the request was authenticated by something a browser
+// can't send along with a forged request, like an api token. It is typically
+// injected by the main.go file and has to run after the authentication.
+// Every request is checked while it is not set
+var CSRFExempt func(r *http.Request) bool
+
+// CSRF makes sure every browser has a csrf token and rejects requests that
+// change state but don't send the token back, unless they are CSRFExempt
+func CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if cookie, err := r.Cookie(CSRFCookie); err == nil && len(cookie.Value) >= 32 {
+			token = cookie.Value
+		} else {
+			token = newCSRFToken()
+			http.SetCookie(w, &http.Cookie{
+				Name:     CSRFCookie,
+				Value:    token,
+				Path:     URL("/"),
+				HttpOnly: true,
+				Secure:   IsHTTPS(r),
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		r = r.WithContext(context.WithValue(r.Context(), csrfKey{}, token))
+
+		if !isSafeMethod(r.Method) && !isCSRFExempt(r) {
+			sent := r.Header.Get(CSRFHeader)
+			if sent == "" {
+				sent = r.PostFormValue(CSRFField)
+			}
+
+			if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				log.FromContext(r.Context()).Warn("CSRF", "Rejected", r.Method, r.URL.Path, "from", r.RemoteAddr)
+				rejectCSRF(w, r)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CSRFToken returns the csrf token of the request, pages add it to their
+// forms
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return token
+}
+
+func isCSRFExempt(r *http.Request) bool {
+	return CSRFExempt != nil && CSRFExempt(r)
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	return false
+}
+
+func rejectCSRF(w http.ResponseWriter, r *http.Request) {
+	ErrorHandler(w, r, http.StatusForbidden, "The form has expired or was sent from another site, go back, reload the page and try again.")
+}
+
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		log.Err("CSRF", "Failed to create a csrf token", err.Error())
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testCSRFToken = "0123456789abcdef0123456789abcdef0123456789a"
+
+type exemptKey struct{}
+
+func TestCSRF(t *testing.T) {
+	previous := CSRFExempt
+	CSRFExempt = func(r *http.Request) bool { return r.Context().Value(exemptKey{}) != nil }
+	defer func() { CSRFExempt = previous }()
+
+	tests := []struct {
+		name   string
+		method string
+		cookie string
+		header string
+		form   string
+		auth   string
+		exempt bool
+		status int
+	}{
+		{"safe method", http.MethodGet, "", "", "", "", false, http.StatusOK},
+		{"token in header", http.MethodPost, testCSRFToken, testCSRFToken, "", "", false, http.StatusOK},
+		{"token in form", http.MethodPost, testCSRFToken, "", testCSRFToken, "", false, http.StatusOK},
+		{"missing token", http.MethodPost, testCSRFToken, "", "", "", false, http.StatusForbidden},
+		{"wrong token", http.MethodDelete, testCSRFToken, "forged", "", "", false, http.StatusForbidden},
+		{"no cookie", http.MethodPost, "", testCSRFToken, "", "", false, http.StatusForbidden},
+		{"short cookie", http.MethodPost, "short", "short", "", "", false, http.StatusForbidden},
+		{"unauthenticated authorization header", http.MethodPost, testCSRFToken, "", "", "Bearer forged", false, http.StatusForbidden},
+		{"basic authorization header", http.MethodPost, testCSRFToken, "", "", "Basic YTpi", false, http.StatusForbidden},
+		{"exempt request", http.MethodPost, "", "", "", "Bearer valid", true, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		var body *strings.Reader
+		if test.form != "" {
+			body = strings.NewReader(url.Values{CSRFField: {test.form}}.Encode())
+		} else {
+			body = strings.NewReader("")
+		}
+
+		r := httptest.NewRequest(test.method, "/plugins/", body)
+		if test.form != "" {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if test.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: CSRFCookie, Value: test.cookie})
+		}
+		if test.header != "" {
+			r.Header.Set(CSRFHeader, test.header)
+		}
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+		if test.exempt {
+			r = r.WithContext(context.WithValue(r.Context(), exemptKey{}, true))
+		}
+
+		var token string
+		rec := httptest.NewRecorder()
+		CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token = CSRFToken(r)
+		})).ServeHTTP(rec, r)
+
+		if rec.Code != test.status {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.status, rec.Code)
+		}
+		if rec.Code == http.StatusOK && token == "" {
+			t.Errorf("%s: expected the handler to get a csrf token", test.name)
+		}
+	}
+}
+
+func TestCSRFChecksEverythingWithoutExempt(t *testing.T) {
+	previous := CSRFExempt
+	CSRFExempt = nil
+	defer func() { CSRFExempt = previous }()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/plugins/", nil)
+	r.Header.Set("Authorization", "Bearer forged")
+	rec := httptest.NewRecorder()
+	CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected the request to be checked, got %d", rec.Code)
+	}
+}
+
+func TestCSRFSetsCookie(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var token string
+	CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = CSRFToken(r)
+	})).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != CSRFCookie || cookies[0].Value != token || !cookies[0].HttpOnly {
+		t.Fatalf("expected an http only cookie with the token, got %v", cookies)
+	}
+	if len(token) < 32 {
+		t.Errorf("expected a random token of at least 32 characters, got %q", token)
+	}
+
+	rec = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookies[0])
+	CSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rec, r)
+	if len(rec.Result().Cookies()) != 0 {
+		t.Error("expected the cookie to be kept once it is set")
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// BodyLimit refuses request bodies larger than limit bytes, reading past the
+// limit returns an error to the handler. A limit of 0 or less disables it
+func BodyLimit(limit int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				ErrorHandler(w, r, http.StatusRequestEntityTooLarge, "The request body is too large")
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Timeout cancels the context of the request after the timeout and responds
+// with 503 instead of what the handler wrote. Event streams are long lived on
+// purpose and are left alone. A timeout of 0 or less disables it
+func Timeout(timeout time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+
+		timeoutHandler := http.TimeoutHandler(next, timeout, "The request took too long")
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			timeoutHandler.ServeHTTP(w, r)
+		})
+	}
+}
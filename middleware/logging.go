@@ -1,22 +1,228 @@
 package middleware
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nielsvanm/homemanager/tools/log"
 )
 
-// LogHTTP logs the http request to the console
-func LogHTTP(next http.Handler) http.Handler {
+// Access log formats
+const (
+	// FormatCommon is the Common Log Format used by most web servers
+	FormatCommon = "common"
+
+	// FormatCombined is the Common Log Format followed by the referer and
+	// the user agent
+	FormatCombined = "combined"
+
+	// FormatJSON writes an object per line that also holds the latency and
+	// the request id
+	FormatJSON = "json"
+)
+
+// clfTime is the time layout of the Common Log Format
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLog writes a line for every request once it has been handled
+type AccessLog struct {
+	Out    io.Writer
+	Format string
+
+	// Exclude are path prefixes that are not logged, such as /static/
+	Exclude []string
+
+	// Internal variables
+	lock sync.Mutex
+}
+
+// accessEntry is shared with the inner handlers through the request context
+// so they can fill in what the access log can't see from the outside
+type accessEntry struct {
+	lock sync.Mutex
+	user string
+}
+
+type accessEntryKey struct{}
+
+// NewAccessLog returns an access log that writes to out in the format
+func NewAccessLog(out io.Writer, format string, exclude []string) (*AccessLog, error) {
+	switch format {
+	case FormatCommon, FormatCombined, FormatJSON:
+	default:
+		return nil, fmt.Errorf("unknown access log format %q, use %s, %s or %s", format, FormatCommon, FormatCombined, FormatJSON)
+	}
+
+	return &AccessLog{Out: out, Format: format, Exclude: exclude}, nil
+}
+
+// Middleware records the status, size and duration of the response and logs
+// the request after the handler returns
+func (a *AccessLog) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// TODO: Fix long time format in log.txt
-		// str := "[" + r.Method + "] " + r.RequestURI + "\tAddress: " + r.RemoteAddr + "\tUser-Agent: " + r.UserAgent() + "\tTimeStamp: " + time.Now().String()
-		str := "[" + r.Method + "] " + r.RequestURI + "\tAddress: " + r.RemoteAddr + "\tTimeStamp: " + strings.Split(time.Now().UTC().String(), ".")[0]
+		if a.excluded(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		entry := &accessEntry{}
+		rec := &responseRecorder{ResponseWriter: w}
 
-		go log.Info("HTTPLog", str)
+		defer func() {
+			entry.lock.Lock()
+			user := entry.user
+			entry.lock.Unlock()
 
-		next.ServeHTTP(w, r)
+			a.write(r, rec, user, start)
+		}()
+
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, entry)))
 	})
 }
+
+// SetAccessUser sets the user that is logged for the request
+func SetAccessUser(r *http.Request, user string) {
+	entry, ok := r.Context().Value(accessEntryKey{}).(*accessEntry)
+	if !ok {
+		return
+	}
+
+	entry.lock.Lock()
+	entry.user = user
+	entry.lock.Unlock()
+}
+
+func (a *AccessLog) excluded(path string) bool {
+	// The prefixes are paths of the app, the log runs before the base path
+	// is stripped
+	if BasePath != "" && strings.HasPrefix(path, BasePath+"/") {
+		path = strings.TrimPrefix(path, BasePath)
+	}
+
+	for _, prefix := range a.Exclude {
+		if prefix != "" && strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *AccessLog) write(r *http.Request, rec *responseRecorder, user string, start time.Time) {
+	status := rec.status
+	if status == 0 {
+		// The handler wrote nothing, net/http sends an empty 200
+		status = http.StatusOK
+	}
+
+	var line []byte
+	if a.Format == FormatJSON {
+		line, _ = json.Marshal(map[string]interface{}{
+			"time":        start.UTC().Format(time.RFC3339Nano),
+			"remote":      ClientIP(r),
+			"user":        user,
+			"method":      r.Method,
+			"uri":         r.RequestURI,
+			"proto":       r.Proto,
+			"status":      status,
+			"bytes":       rec.bytes,
+			"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"referer":     r.Referer(),
+			"user_agent":  r.UserAgent(),
+			"request_id":  GetRequestID(r),
+		})
+		line = append(line, '\n')
+	} else {
+		size := "-"
+		if rec.bytes > 0 {
+			size = strconv.FormatInt(rec.bytes, 10)
+		}
+
+		str := fmt.Sprintf("%s - %s [%s] %s %d %s",
+			ClientIP(r),
+			orDash(strings.ReplaceAll(user, " ", "_")),
+			start.Format(clfTime),
+			strconv.Quote(r.Method+" "+r.RequestURI+" "+r.Proto),
+			status,
+			size,
+		)
+		if a.Format == FormatCombined {
+			str += " " + strconv.Quote(orDash(r.Referer())) + " " + strconv.Quote(orDash(r.UserAgent()))
+		}
+		line = []byte(str + "\n")
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	_, err := a.Out.Write(line)
+	if err != nil {
+		log.FromContext(r.Context()).Warn("AccessLog", "Failed to write access log", err.Error())
+	}
+}
+
+// remoteHost returns the address of the client without the port
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// orDash returns "-" for empty fields as the Common Log Format expects
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// responseRecorder passes the response through while counting what is sent
+type responseRecorder struct {
+	http.ResponseWriter
+
+	status int
+	bytes  int64
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+
+	return n, err
+}
+
+// Flush keeps event streams working through the recorder
+func (rec *responseRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the original writer
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
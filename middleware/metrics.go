@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nielsvanm/homemanager/metrics"
+)
+
+// UnmatchedRoute is the route of requests that don't match an endpoint
+const UnmatchedRoute = "unmatched"
+
+var (
+	httpRequests = metrics.NewCounter("homemanager_http_requests_total",
+		"Number of handled http requests", "method", "route", "status")
+	httpDuration = metrics.NewHistogram("homemanager_http_request_duration_seconds",
+		"Time it took to handle http requests", metrics.DefaultBuckets, "method", "route")
+	httpInFlight = metrics.NewGauge("homemanager_http_requests_in_flight",
+		"Number of http requests that are being handled")
+)
+
+func init() {
+	metrics.MustRegister(httpRequests, httpDuration, httpInFlight)
+}
+
+// Metrics counts and times every request by its method, route and status.
+// The route function returns the url template of the endpoint the request is
+// routed to, such as /users/{userid}/role/, so every user doesn't get series
+// of their own
+func Metrics(route func(r *http.Request) string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &responseRecorder{ResponseWriter: w}
+			httpInFlight.Inc()
+
+			defer func() {
+				httpInFlight.Dec()
+
+				status := rec.status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				method, name := metricMethod(r.Method), route(r)
+				httpRequests.Inc(method, name, strconv.Itoa(status))
+				httpDuration.Observe(time.Since(start).Seconds(), method, name)
+			}()
+
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// metricMethod returns the method of the request, made up methods are
+// grouped as other
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+
+	return "other"
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// Middleware wraps a handler, it has the same type as mux.MiddlewareFunc so
+// both can be used interchangeably
+type Middleware = func(http.Handler) http.Handler
+
+// Chain wraps the handler with the middleware, the first middleware is the
+// outermost one and sees the request first
+func Chain(handler http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
+
+// ErrorHandler writes the error responses of the middleware, the frame
+// replaces it to render its themed error pages
+var ErrorHandler = func(w http.ResponseWriter, r *http.Request, status int, message string) {
+	http.Error(w, message, status)
+}
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// BasePath is the path the app is served under behind a reverse proxy, such
+// as /manager. It is empty when the app is served at the root of the site
+var BasePath string
+
+type schemeKey struct{}
+
+// SetBasePath validates the base path and stores it without its trailing
+// slash in BasePath, / and the empty path serve the app at the root
+func SetBasePath(path string) error {
+	path = strings.TrimRight(strings.TrimSpace(path), "/")
+	if path == "" {
+		BasePath = ""
+		return nil
+	}
+
+	if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, "?#\\ ") || strings.Contains(path, "//") {
+		return fmt.Errorf("invalid base path %q, it should look like /manager", path)
+	}
+
+	BasePath = path
+	return nil
+}
+
+// URL returns the public url of a path of the app by adding the base path,
+// e.g. /login/ becomes /manager/login/. Other urls are returned as they are
+func URL(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") {
+		return path
+	}
+
+	return BasePath + path
+}
+
+// Redirect redirects the request to a path of the app
+func Redirect(w http.ResponseWriter, r *http.Request, path string, code int) {
+	http.Redirect(w, r, URL(path), code)
+}
+
+// StripBasePath removes the base path from the url of the request, so the
+// routes and everything after it only deal with paths of the app. Requests
+// without the base path are left alone, this supports proxies that strip the
+// prefix themselves
+func StripBasePath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if BasePath == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.URL.Path == BasePath {
+			target := BasePath + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+
+		if !strings.HasPrefix(r.URL.Path, BasePath+"/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = strings.TrimPrefix(r.URL.Path, BasePath)
+		r2.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, BasePath)
+		r2.RequestURI = r2.URL.RequestURI()
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// Forwarded believes the X-Forwarded-Host and X-Forwarded-Proto headers of
+// requests from trusted proxies, the host of the request is replaced and
+// Scheme reports the scheme the client used
+func Forwarded(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isTrustedProxy(remoteHost(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The first proxy added the values the client sent
+		ctx := r.Context()
+		if proto := strings.ToLower(firstForwarded(r, "X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			ctx = context.WithValue(ctx, schemeKey{}, proto)
+		}
+		r = r.WithContext(ctx)
+		if host := firstForwarded(r, "X-Forwarded-Host"); host != "" {
+			r.Host = host
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Scheme returns http or https, depending on how the client connected to
+// the server or to the trusted proxy in front of it
+func Scheme(r *http.Request) string {
+	if scheme, ok := r.Context().Value(schemeKey{}).(string); ok {
+		return scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+// IsHTTPS reports if the client connected over https
+func IsHTTPS(r *http.Request) bool {
+	return Scheme(r) == "https"
+}
+
+func firstForwarded(r *http.Request, header string) string {
+	value := strings.Split(r.Header.Get(header), ",")[0]
+	return strings.TrimSpace(value)
+}
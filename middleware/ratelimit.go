@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nielsvanm/homemanager/tools/clock"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// KeyFunc returns the key a request is rate limited by, such as the address
+// of the client. Requests with an empty key are not limited
+type KeyFunc func(r *http.Request) string
+
+// ByIP rate limits requests by the address of the client
+func ByIP(r *http.Request) string {
+	return "ip:" + ClientIP(r)
+}
+
+// ForMethods rate limits the requests with one of the methods by key, other
+// requests are not limited
+func ForMethods(key KeyFunc, methods ...string) KeyFunc {
+	return func(r *http.Request) string {
+		for _, method := range methods {
+			if r.Method == method {
+				return key(r)
+			}
+		}
+
+		return ""
+	}
+}
+
+// Limiter hands out tokens from a bucket per key. A bucket holds Burst
+// tokens and is refilled with Rate tokens per second, every request takes
+// one token
+type Limiter struct {
+	Rate  float64
+	Burst int
+
+	// Internal variables
+	lock    sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewLimiter returns a limiter that allows burst requests at once and rate
+// requests per second after that. A rate of 0 or less disables it
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Limiter{Rate: rate, Burst: burst, buckets: map[string]*bucket{}}
+}
+
+// Allow takes a token for the key, when there is none it returns false and
+// the time until the next token is available
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l.Rate <= 0 {
+		return true, 0
+	}
+
+	now := clock.Now()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{float64(l.Burst), now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = l.refill(b, now)
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+// refill returns the tokens in the bucket at now
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	return math.Min(float64(l.Burst), b.tokens+now.Sub(b.updated).Seconds()*l.Rate)
+}
+
+// prune forgets the buckets that are full again, a new bucket would be the
+// same. The caller must hold the lock
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < time.Minute {
+		return
+	}
+	l.pruned = now
+
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RateLimit responds with 429 Too Many Requests when the limiter has no
+// token left for the key of the request, the Retry-After header tells the
+// client when to try again
+func RateLimit(limiter *Limiter, key KeyFunc) Middleware {
+	return func(next http.Handler) http.Handler {
+		if limiter == nil || limiter.Rate <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k := key(r)
+			if k == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			allowed, wait := limiter.Allow(k)
+			if !allowed {
+				seconds := int(math.Ceil(wait.Seconds()))
+				if seconds < 1 {
+					seconds = 1
+				}
+
+				log.FromContext(r.Context()).Warn("RateLimit", "Rate limited", k, "on", r.URL.Path)
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				ErrorHandler(w, r, http.StatusTooManyRequests, "Too many requests, try again in "+strconv.Itoa(seconds)+" seconds")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nielsvanm/homemanager/tools/clock"
+)
+
+// testClock is a clock that only moves when the test moves it
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func useTestClock(t *testing.T) *testClock {
+	c := &testClock{time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)}
+	previous := clock.Set(c)
+	t.Cleanup(func() { clock.Set(previous) })
+
+	return c
+}
+
+func TestLimiter(t *testing.T) {
+	c := useTestClock(t)
+	l := NewLimiter(2, 3)
+
+	// Every step moves the clock and asks for a token
+	tests := []struct {
+		advance time.Duration
+		key     string
+		allowed bool
+		wait    time.Duration
+	}{
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, 500 * time.Millisecond},
+		{0, "b", true, 0},
+		{250 * time.Millisecond, "a", false, 250 * time.Millisecond},
+		{250 * time.Millisecond, "a", true, 0},
+		{0, "a", false, 500 * time.Millisecond},
+		// The bucket never holds more than the burst
+		{time.Hour, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, 500 * time.Millisecond},
+	}
+
+	for i, test := range tests {
+		c.now = c.now.Add(test.advance)
+		allowed, wait := l.Allow(test.key)
+		if allowed != test.allowed || wait != test.wait {
+			t.Errorf("step %d: expected %v %s, got %v %s", i, test.allowed, test.wait, allowed, wait)
+		}
+	}
+}
+
+func TestLimiterForgetsFullBuckets(t *testing.T) {
+	c := useTestClock(t)
+	l := NewLimiter(1, 2)
+
+	l.Allow("a")
+	l.Allow("b")
+	c.now = c.now.Add(2 * time.Minute)
+	l.Allow("c")
+
+	if len(l.buckets) != 1 {
+		t.Errorf("expected only the bucket in use to be kept, got %d buckets", len(l.buckets))
+	}
+}
+
+func TestDisabledLimiter(t *testing.T) {
+	l := NewLimiter(0, 1)
+	for i := 0; i < 10; i++ {
+		if allowed, _ := l.Allow("a"); !allowed {
+			t.Fatal("expected a limiter without rate to allow everything")
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	useTestClock(t)
+	handler := RateLimit(NewLimiter(0.5, 1), func(r *http.Request) string {
+		return r.Header.Get("X-Key")
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		key        string
+		status     int
+		retryAfter string
+	}{
+		{"a", http.StatusOK, ""},
+		{"a", http.StatusTooManyRequests, "2"},
+		{"b", http.StatusOK, ""},
+		{"", http.StatusOK, ""},
+		{"", http.StatusOK, ""},
+	}
+
+	for i, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api/plugins/", nil)
+		r.Header.Set("X-Key", test.key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+
+		if rec.Code != test.status || rec.Header().Get("Retry-After") != test.retryAfter {
+			t.Errorf("request %d: expected %d with Retry-After %q, got %d with %q", i, test.status, test.retryAfter, rec.Code, rec.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestRateLimitForMethods(t *testing.T) {
+	useTestClock(t)
+	handler := RateLimit(NewLimiter(0.5, 1), ForMethods(ByIP, http.MethodPost))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		method string
+		status int
+	}{
+		{http.MethodGet, http.StatusOK},
+		{http.MethodGet, http.StatusOK},
+		{http.MethodPost, http.StatusOK},
+		{http.MethodGet, http.StatusOK},
+		{http.MethodPost, http.StatusTooManyRequests},
+	}
+
+	for i, test := range tests {
+		r := httptest.NewRequest(test.method, "/login/", nil)
+		r.RemoteAddr = "192.0.2.1:5000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+
+		if rec.Code != test.status {
+			t.Errorf("request %d: expected %s to respond with %d, got %d", i, test.method, test.status, rec.Code)
+		}
+	}
+}
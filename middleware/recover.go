@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// Recover turns a panic in a handler into a 500 response and logs the panic
+// with its stack, so a single broken request can't take down the server
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				// Aborting a response panics on purpose, the server handles it
+				panic(err)
+			}
+
+			log.FromContext(r.Context()).Err("WebServer", "Panic while handling", r.Method, r.URL.Path+":",
+				fmt.Sprint(err), "\n"+string(debug.Stack()))
+			ErrorHandler(w, r, http.StatusInternalServerError, "Something went wrong while handling the request")
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
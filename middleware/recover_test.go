@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+func TestRecoverLogsRequestID(t *testing.T) {
+	var lock sync.Mutex
+	logged := []string{}
+	id := log.AddHook(func(level, module, message string) {
+		lock.Lock()
+		defer lock.Unlock()
+		if module == "WebServer" || module == "Test" {
+			logged = append(logged, message)
+		}
+	})
+	defer log.RemoveHook(id)
+
+	handler := RequestID(Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.FromContext(r.Context()).Info("Test", "handling")
+		panic("broken")
+	})))
+
+	r := httptest.NewRequest(http.MethodGet, "/broken/", nil)
+	r.Header.Set(RequestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected the panic to be a 500, got %d", rec.Code)
+	}
+	if rec.Header().Get(RequestIDHeader) != "abc-123" {
+		t.Errorf("expected the request id to be returned, got %q", rec.Header().Get(RequestIDHeader))
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(logged) != 2 {
+		t.Fatalf("expected the handler and the panic to be logged, got %v", logged)
+	}
+	for _, message := range logged {
+		if !strings.HasPrefix(message, "[req=abc-123]") {
+			t.Errorf("expected the request id in %q", message)
+		}
+	}
+}
+
+func TestRequestIDRejectsInvalidIDs(t *testing.T) {
+	for _, sent := range []string{"", "abc 123", "abc\n[req=forged]", strings.Repeat("a", 65)} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(RequestIDHeader, sent)
+		rec := httptest.NewRecorder()
+
+		var got string
+		RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = GetRequestID(r)
+		})).ServeHTTP(rec, r)
+
+		if got == sent || len(got) != 16 || rec.Header().Get(RequestIDHeader) != got {
+			t.Errorf("%q: expected a new request id, got %q", sent, got)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// RequestIDHeader is the header the request id is read from and returned in
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID limits the ids that are accepted from clients and proxies,
+// so they can't inject anything into the logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type requestIDKey struct{}
+
+// RequestID gives every request an id, an id sent by a proxy in the
+// X-Request-ID header is reused. The id is returned in the response headers
+// and added to the lines that are logged with log.FromContext
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = log.NewContext(ctx, "req="+id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetRequestID returns the id of the request, it is empty when the RequestID
+// middleware is not used
+func GetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,22 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// TestMain keeps the log of the tests out of the package folder
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "homemanager-log")
+	if err != nil {
+		panic(err)
+	}
+	log.File = filepath.Join(dir, "log.txt")
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
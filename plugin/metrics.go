@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/nielsvanm/homemanager/metrics"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+var (
+	runsTotal = metrics.NewCounter("homemanager_plugin_runs_total",
+		"Number of finished plugin runs by their status", "plugin", "status")
+	runDuration = metrics.NewHistogram("homemanager_plugin_run_duration_seconds",
+		"Time plugin runs took", []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 7200}, "plugin")
+	runsRunning = metrics.NewGauge("homemanager_plugin_runs_running",
+		"Number of plugin runs that are in progress", "plugin")
+)
+
+func init() {
+	metrics.MustRegister(runsTotal, runDuration, runsRunning)
+}
+
+// metricPrefixChars are replaced in plugin names to form a metric name
+var metricPrefixChars = regexp.MustCompile(`[^a-z0-9_]`)
+
+// pluginMetric is a metric of a plugin, its name is prefixed with the name of
+// the plugin and it has no samples while the plugin is disabled
+type pluginMetric struct {
+	metrics.Metric
+
+	name   string
+	plugin *Plugin
+}
+
+// Desc returns the description of the metric with the prefixed name
+func (pm *pluginMetric) Desc() metrics.Desc {
+	desc := pm.Metric.Desc()
+	desc.Name = pm.name
+
+	return desc
+}
+
+// Collect returns the samples of the metric while the plugin is enabled
+func (pm *pluginMetric) Collect(ctx context.Context) []metrics.Sample {
+	if !pm.plugin.IsEnabled() {
+		return nil
+	}
+
+	return pm.Metric.Collect(ctx)
+}
+
+// metricName returns the name a metric of the plugin is published under,
+// homemanager_{pluginname}_{name}
+func (p *Plugin) metricName(name string) string {
+	prefix := metricPrefixChars.ReplaceAllString(strings.ToLower(p.Name), "_")
+
+	return "homemanager_" + prefix + "_" + name
+}
+
+// RegisterMetrics makes the metrics of every plugin available on /metrics, a
+// plugin metric that can't be registered is left out
+func (m *Manager) RegisterMetrics() {
+	for _, plugin := range m.Plugins {
+		for _, metric := range plugin.Metrics {
+			err := metrics.Register(metric)
+			if err != nil {
+				log.Warn("PluginManager", "Failed to register a metric of", plugin.Name, err.Error())
+			}
+		}
+	}
+}
@@ -1,19 +1,41 @@
 package plugin
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"io/fs"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/nielsvanm/homemanager/auth"
 	"github.com/nielsvanm/homemanager/database"
 	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/health"
+	"github.com/nielsvanm/homemanager/metrics"
+	"github.com/nielsvanm/homemanager/middleware"
+	"github.com/nielsvanm/homemanager/settings"
+	"github.com/nielsvanm/homemanager/storage"
 
 	"github.com/nielsvanm/homemanager/tools"
 	"github.com/nielsvanm/homemanager/tools/log"
 )
 
 // PluginManager is the app-wide plugin management object
-var PluginManager = Manager{[]*Plugin{}, nil}
+var PluginManager = Manager{
+	Plugins:        []*Plugin{},
+	Workers:        2,
+	DefaultTimeout: 30 * time.Minute,
+	CategoryIcons: map[string]string{
+		"Entertainment": "fas fa-film",
+		"Internet":      "fas fa-wifi",
+	},
+}
+
+// defaultCategoryIcon is used for categories without an icon
+const defaultCategoryIcon = "fas fa-puzzle-piece"
 
 // Plugin is a type that represents actions that have to be executed on the server
 // it wraps logic and settings that specify it's behaviour
@@ -23,6 +45,10 @@ type Plugin struct {
 	Description string
 	Category    string
 
+	// disabled plugins are not run and don't show up in the app, it is
+	// changed while requests are served so it is only accessed atomically
+	disabled int32
+
 	// Database queries
 	SetupDatabase []string
 	Tables        []string
@@ -31,52 +57,179 @@ type Plugin struct {
 	APIEndpoints  []*frame.Endpoint
 	ViewEndpoints []*frame.Endpoint
 
-	// Main Function
-	Main func() []database.BatchQuery
+	// Middleware wraps every endpoint of the plugin, it runs before the
+	// middleware of the endpoints themselves
+	Middleware []middleware.Middleware
+
+	// Widgets shown on the home dashboard
+	Widgets []*frame.Widget
+
+	// Topics pages can subscribe to for live updates, they are available as
+	// {pluginname}.{topic}
+	Topics []frame.Topic
+
+	// Metrics are published on /metrics as homemanager_{pluginname}_{name},
+	// for example the amount of active downloads
+	Metrics []metrics.Metric
+
+	// Settings describes the options the plugin can be configured with
+	Settings []settings.Setting
+
+	// Permissions the plugin's endpoints require, they are registered when
+	// the plugin is set up so they can be granted to roles
+	Permissions []auth.Permission
+
+	// Templates are available to the plugin as plugins/{pluginname}/, the
+	// Static files are served under /static/plugins/{pluginname}/
+	Templates fs.FS
+	Static    fs.FS
+
+	// Main Function, it should stop and return as soon as the context is
+	// done
+	Main func(ctx context.Context) []database.BatchQuery
+
+	// Stream is an alternative to Main for plugins that produce a lot of
+	// data. It commits its results to the sink bit by bit, each commit with
+	// a checkpoint. When a run fails the next run receives the last committed
+	// checkpoint so it can resume, a fresh run receives an empty checkpoint
+	Stream func(ctx context.Context, checkpoint string, sink database.Sink) error
+
+	// Timeout is the maximum duration of a single run, when it is 0 the
+	// DefaultTimeout of the manager is used
+	Timeout time.Duration
+
+	// DependsOn lists plugins that have to finish before this plugin runs
+	// when all plugins are run together
+	DependsOn []string
+
+	// HealthCheck reports if the services the plugin depends on are
+	// available, it is optional
+	HealthCheck func(ctx context.Context) error
 
 	// Data dirs
 	DataDirs []string
+
+	// Storage quotas in bytes, a value of 0 disables the quota
+	SoftQuota int64
+	HardQuota int64
+
+	// Storage is the data folder of the plugin, it is available after Setup
+	Storage *storage.Storage
 }
 
 // Setup adds the name of the plugin at any %s that is provided
 // by the plugin
+// The plugin's queries, endpoints and widgets are copied before they are
+// changed, so the package variables they come from are left untouched and a
+// copy of the plugin can be set up again, for example in tests
 func (p *Plugin) Setup() {
 	// Add name to database queries
-	for i := 0; i < len(p.SetupDatabase); i++ {
-		p.SetupDatabase[i] = p.AddPluginNameToQuery(p.SetupDatabase[i])
+	queries := []string{}
+	for _, query := range p.SetupDatabase {
+		queries = append(queries, p.AddPluginNameToQuery(query))
 	}
-	for i := 0; i < len(p.Tables); i++ {
-		p.Tables[i] = p.AddPluginNameToQuery(p.Tables[i])
+	p.SetupDatabase = queries
+
+	tables := []string{}
+	for _, table := range p.Tables {
+		tables = append(tables, p.AddPluginNameToQuery(table))
 	}
+	p.Tables = tables
+
+	// Every endpoint checks if the plugin is enabled before anything else
+	pluginMiddleware := append([]middleware.Middleware{p.requireEnabled}, p.Middleware...)
 
 	// Add /api/{pluginname}/ to api endpoints
 	newEndpoints := []*frame.Endpoint{}
 	for _, endp := range p.APIEndpoints {
-		endp.URL = "/api/" + strings.ToLower(p.Name) + endp.URL
-		newEndpoints = append(newEndpoints, endp)
+		newEndp := *endp
+		newEndp.URL = "/api/" + strings.ToLower(p.Name) + endp.URL
+		newEndp.Middleware = append(append([]middleware.Middleware{}, pluginMiddleware...), endp.Middleware...)
+		newEndpoints = append(newEndpoints, &newEndp)
 	}
 	p.APIEndpoints = newEndpoints
 
 	// Add {pluginname}/ to api endpoints
 	newEndpoints = []*frame.Endpoint{}
 	for _, endp := range p.ViewEndpoints {
-		endp.URL = "/" + strings.ToLower(p.Name) + endp.URL
-		newEndpoints = append(newEndpoints, endp)
+		newEndp := *endp
+		newEndp.URL = "/" + strings.ToLower(p.Name) + endp.URL
+		newEndp.Middleware = append(append([]middleware.Middleware{}, pluginMiddleware...), endp.Middleware...)
+		newEndpoints = append(newEndpoints, &newEndp)
 	}
 	p.ViewEndpoints = newEndpoints
 
-	// Create data dirs
+	// Make the permissions of the plugin known
+	for _, perm := range p.Permissions {
+		auth.RegisterPermission(perm)
+	}
+
+	// Make the templates of the plugin available
+	if p.Templates != nil {
+		frame.RegisterTemplates("plugins/"+strings.ToLower(p.Name)+"/", p.Templates)
+	}
+
+	// Add /widgets/{pluginname}/ to widgets
+	newWidgets := []*frame.Widget{}
+	for _, widget := range p.Widgets {
+		newWidget := *widget
+		newWidget.URL = "/widgets/" + strings.ToLower(p.Name) + "/" + widget.Name + "/"
+		newWidgets = append(newWidgets, &newWidget)
+	}
+	p.Widgets = newWidgets
+
+	// Add {pluginname}. to topics, samples are skipped while the plugin is
+	// disabled
+	newTopics := []frame.Topic{}
+	for _, topic := range p.Topics {
+		newTopic := topic
+		newTopic.Name = strings.ToLower(p.Name) + "." + topic.Name
+		if sample := topic.Sample; sample != nil {
+			newTopic.Sample = func(ctx context.Context) (interface{}, error) {
+				if !p.IsEnabled() {
+					return nil, errors.New(p.Name + " is disabled")
+				}
+				return sample(ctx)
+			}
+		}
+		newTopics = append(newTopics, newTopic)
+	}
+	p.Topics = newTopics
+
+	// Add homemanager_{pluginname}_ to metrics, they have no samples while
+	// the plugin is disabled
+	newMetrics := []metrics.Metric{}
+	for _, metric := range p.Metrics {
+		newMetrics = append(newMetrics, &pluginMetric{metric, p.metricName(metric.Desc().Name), p})
+	}
+	p.Metrics = newMetrics
+
+	// Setup storage and create data dirs
+	p.Storage = storage.Get(p.Name)
+	p.Storage.SoftQuota = p.SoftQuota
+	p.Storage.HardQuota = p.HardQuota
+
 	for _, dir := range p.DataDirs {
-		err := os.MkdirAll(p.GetDir(dir), os.ModePerm)
+		err := p.Storage.MkdirAll(dir)
 		if err != nil {
-			if strings.Contains(err.Error(), "exists") {
-				continue
-			}
 			log.Warn(p.Name, "Failed to create folder", err.Error())
 		}
 	}
 }
 
+// GetSetting returns the value of a setting of the plugin, the default is
+// returned when it has not been changed. It is empty for unknown settings
+func (p *Plugin) GetSetting(key string) string {
+	for _, setting := range p.Settings {
+		if setting.Key == key {
+			return settings.Get(p.Name, setting)
+		}
+	}
+
+	log.Warn(p.Name, "Unknown setting", key)
+	return ""
+}
+
 // AddPluginNameToQuery replaces all the %s in the query with the plugin
 // name
 func (p *Plugin) AddPluginNameToQuery(query string) string {
@@ -99,15 +252,48 @@ func (p *Plugin) DropTables() []string {
 	return queries
 }
 
+// TruncateTables provides the queries that delete all rows of the tables
+func (p *Plugin) TruncateTables() []string {
+	queries := []string{}
+
+	for _, table := range p.Tables {
+		queries = append(
+			queries,
+			fmt.Sprintf(`TRUNCATE TABLE %s CASCADE;`, table),
+		)
+	}
+
+	return queries
+}
+
 // GetDir returns the path of a plugin folder
 func (p *Plugin) GetDir(name string) string {
-	return "./__data/" + strings.ToLower(p.Name) + "/" + name
+	return filepath.Join(storage.Root, strings.ToLower(p.Name), name)
 }
 
 // Manager is a management object for the plugin struct
 type Manager struct {
 	Plugins []*Plugin
 	DB      *database.DB
+
+	// Context is the parent of every run, cancelling it stops all running
+	// plugins. It defaults to context.Background()
+	Context context.Context
+
+	// Workers is the maximum amount of plugins RunPlugins runs at once
+	Workers int
+
+	// DefaultTimeout is used for plugins that don't specify a timeout
+	DefaultTimeout time.Duration
+
+	// CategoryIcons are the font awesome classes shown in front of the
+	// categories in the sidebar
+	CategoryIcons map[string]string
+
+	// Background runs
+	jobs      []*Job
+	jobsLock  sync.Mutex
+	nextJobID int
 }
 
 // Setup runs initial functionality of plugins to ensure they are operationalIn
@@ -118,9 +304,35 @@ func (m *Manager) Setup() {
 	}
 }
 
+// RegisterHealthChecks adds the health check of every plugin that provides
+// one to the health checks
+func (m *Manager) RegisterHealthChecks() {
+	for _, plugin := range m.Plugins {
+		if plugin.HealthCheck == nil {
+			continue
+		}
+		health.Register("plugin/"+strings.ToLower(plugin.Name), false, plugin.HealthCheck)
+	}
+}
+
+// RegisterTopics makes the run status of the plugins and the topics of every
+// plugin available to subscribers
+func (m *Manager) RegisterTopics() {
+	frame.Events.RegisterTopic(frame.Topic{Name: JobTopic})
+
+	for _, plugin := range m.Plugins {
+		for _, topic := range plugin.Topics {
+			frame.Events.RegisterTopic(topic)
+		}
+	}
+}
+
 // GetSetupQueries returns the queries necessarry to setup the database
 func (m *Manager) GetSetupQueries() []string {
 	allQueries := []string{}
+	allQueries = append(allQueries, RunSetupDB...)
+	allQueries = append(allQueries, CheckpointSetupDB...)
+	allQueries = append(allQueries, StateSetupDB...)
 
 	for _, plugin := range m.Plugins {
 		allQueries = append(allQueries, plugin.SetupDatabase...)
@@ -129,7 +341,8 @@ func (m *Manager) GetSetupQueries() []string {
 	return allQueries
 }
 
-// GetEndpoints returns a list of all the endpoints any plugin has registered
+// GetEndpoints returns a list of all the endpoints any plugin has registered,
+// the endpoints of disabled plugins respond with 404 until they are enabled
 func (m *Manager) GetEndpoints() []*frame.Endpoint {
 	endpoints := []*frame.Endpoint{}
 
@@ -141,13 +354,59 @@ func (m *Manager) GetEndpoints() []*frame.Endpoint {
 	return endpoints
 }
 
+// GetStatics returns the static file systems of the plugins mapped by the
+// url prefix they should be served under
+func (m *Manager) GetStatics() map[string]fs.FS {
+	statics := map[string]fs.FS{}
+
+	for _, plugin := range m.Plugins {
+		if plugin.Static == nil {
+			continue
+		}
+		statics["/static/plugins/"+strings.ToLower(plugin.Name)+"/"] = plugin.Static
+	}
+
+	return statics
+}
+
+// GetWidgets returns a list of all the widgets any plugin has registered
+func (m *Manager) GetWidgets() []*frame.Widget {
+	widgets := []*frame.Widget{}
+
+	for _, plugin := range m.Plugins {
+		if !plugin.IsEnabled() {
+			continue
+		}
+		widgets = append(widgets, plugin.Widgets...)
+	}
+
+	return widgets
+}
+
+// GetWidget returns the widget of the plugin with the provided name or nil
+// when it does not exist
+func (m *Manager) GetWidget(pluginName, widgetName string) *frame.Widget {
+	for _, plugin := range m.Plugins {
+		if !plugin.IsEnabled() || strings.ToLower(plugin.Name) != strings.ToLower(pluginName) {
+			continue
+		}
+		for _, widget := range plugin.Widgets {
+			if widget.Name == widgetName {
+				return widget
+			}
+		}
+	}
+
+	return nil
+}
+
 // GetCategories returns a list of categories that are registered by the plugin
 // it also removes the duplicates from the list
 func (m *Manager) GetCategories() []string {
 	categories := []string{}
 
 	for _, plugin := range m.Plugins {
-		if tools.IsInList(plugin.Category, categories) {
+		if !plugin.IsEnabled() || tools.IsInList(plugin.Category, categories) {
 			continue
 		}
 		categories = append(categories, plugin.Category)
@@ -156,18 +415,152 @@ func (m *Manager) GetCategories() []string {
 	return categories
 }
 
-func (m *Manager) RunPlugins() {
+// GetMenuGroups returns a sidebar group for every category, containing the
+// view endpoints of the enabled plugins that have a menu entry
+func (m *Manager) GetMenuGroups() []frame.MenuGroup {
+	groups := []frame.MenuGroup{}
+
+	for _, category := range m.GetCategories() {
+		icon, ok := m.CategoryIcons[category]
+		if !ok {
+			icon = defaultCategoryIcon
+		}
+		group := frame.MenuGroup{Name: category, Icon: icon}
+
+		for _, plugin := range m.Plugins {
+			if !plugin.IsEnabled() || plugin.Category != category {
+				continue
+			}
+			for _, endp := range plugin.ViewEndpoints {
+				if endp.Menu == nil {
+					continue
+				}
+				group.Items = append(group.Items, frame.MenuItem{
+					Label:      endp.Menu.Label,
+					Icon:       endp.Menu.Icon,
+					Path:       endp.URL,
+					Permission: endp.Permission,
+				})
+			}
+		}
+
+		if len(group.Items) > 0 {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+// RunPlugins runs every plugin once and blocks until they are done. Plugins
+// run concurrently with at most Workers at a time, a plugin only starts after
+// the plugins it depends on have finished
+func (m *Manager) RunPlugins(ctx context.Context) {
+	workers := m.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	pool := make(chan struct{}, workers)
+
+	// Plugins that can never start would wait on each other forever
+	blocked := m.blockedPlugins()
+
+	// Every plugin gets a channel that is closed when it is done
+	done := map[string]chan struct{}{}
 	for _, plugin := range m.Plugins {
-		batches := plugin.Main()
-		for _, batch := range batches {
-			m.DB.ExecBatch(batch)
+		done[plugin.Name] = make(chan struct{})
+	}
+
+	var wg sync.WaitGroup
+	for _, plugin := range m.Plugins {
+		wg.Add(1)
+		go func(p *Plugin) {
+			defer wg.Done()
+			defer close(done[p.Name])
+
+			if !p.IsEnabled() {
+				return
+			}
+			if reason, ok := blocked[p.Name]; ok {
+				m.failRun(p, reason)
+				return
+			}
+
+			for _, dependency := range p.DependsOn {
+				select {
+				case <-done[dependency]:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case pool <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-pool }()
+
+			m.StartRun(ctx, p).Wait()
+		}(plugin)
+	}
+	wg.Wait()
+}
+
+// blockedPlugins returns the plugins that can never run together with the
+// reason, because they depend on a plugin that does not exist or on
+// themselves, directly or through the plugins they depend on
+func (m *Manager) blockedPlugins() map[string]string {
+	plugins := map[string]*Plugin{}
+	for _, plugin := range m.Plugins {
+		plugins[plugin.Name] = plugin
+	}
+
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := map[string]int{}
+	blocked := map[string]string{}
+
+	var visit func(p *Plugin)
+	visit = func(p *Plugin) {
+		state[p.Name] = visiting
+		for _, dependency := range p.DependsOn {
+			dep, ok := plugins[dependency]
+			switch {
+			case !ok:
+				blocked[p.Name] = "Depends on unknown plugin " + dependency
+			case state[dependency] == visiting:
+				blocked[p.Name] = "Depends on " + dependency + " which depends on " + p.Name
+			case state[dependency] == 0:
+				visit(dep)
+			}
+			if _, ok := blocked[p.Name]; ok {
+				break
+			}
+			if _, ok := blocked[dependency]; ok {
+				blocked[p.Name] = "Depends on " + dependency + " which can't run"
+				break
+			}
+		}
+		state[p.Name] = visited
+	}
+
+	for _, plugin := range m.Plugins {
+		if state[plugin.Name] == 0 {
+			visit(plugin)
 		}
 	}
+
+	return blocked
 }
 
+// GetPlugin returns the plugin with the provided name, ignoring case, or nil
+// when it does not exist
 func (m *Manager) GetPlugin(pluginName string) *Plugin {
 	for _, plugin := range m.Plugins {
-		if plugin.Name == pluginName {
+		if strings.EqualFold(plugin.Name, pluginName) {
 			return plugin
 		}
 	}
@@ -0,0 +1,105 @@
+package plugin_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nielsvanm/homemanager/database"
+	"github.com/nielsvanm/homemanager/plugin"
+	"github.com/nielsvanm/homemanager/plugin/plugintest"
+)
+
+// storedRuns returns the status of every run in the run history by plugin
+func storedRuns(db *plugintest.FakeDB) map[string]string {
+	runs := map[string]string{}
+	for _, exec := range db.Execs() {
+		runs[exec.Args[0].(string)] = exec.Args[1].(string)
+	}
+
+	return runs
+}
+
+func newPlugin(name string, dependsOn ...string) *plugin.Plugin {
+	return &plugin.Plugin{
+		Name:      name,
+		DependsOn: dependsOn,
+		Main:      func(ctx context.Context) []database.BatchQuery { return nil },
+	}
+}
+
+func TestRunPluginsFailsBlockedPlugins(t *testing.T) {
+	db := plugintest.UseFakeDB(t)
+	m := &plugin.Manager{
+		Plugins: []*plugin.Plugin{
+			newPlugin("A", "B"),
+			newPlugin("B", "A"),
+			newPlugin("Self", "Self"),
+			newPlugin("C", "Missing"),
+			newPlugin("D", "C"),
+			newPlugin("E"),
+			newPlugin("F", "E"),
+		},
+		DB:      database.Database,
+		Workers: 2,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.RunPlugins(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected RunPlugins to return")
+	}
+
+	expected := map[string]string{
+		"A":    plugin.JobFailed,
+		"B":    plugin.JobFailed,
+		"Self": plugin.JobFailed,
+		"C":    plugin.JobFailed,
+		"D":    plugin.JobFailed,
+		"E":    plugin.JobFinished,
+		"F":    plugin.JobFinished,
+	}
+	runs := storedRuns(db)
+	for name, status := range expected {
+		if runs[name] != status {
+			t.Errorf("%s: expected a %s run, got %q", name, status, runs[name])
+		}
+	}
+}
+
+func TestShutdownWaitsForRuns(t *testing.T) {
+	db := plugintest.UseFakeDB(t)
+	release := make(chan struct{})
+
+	stops := &plugin.Plugin{Name: "Stops", Main: func(ctx context.Context) []database.BatchQuery {
+		<-ctx.Done()
+		return nil
+	}}
+	hangs := &plugin.Plugin{Name: "Hangs", Main: func(ctx context.Context) []database.BatchQuery {
+		<-release
+		return nil
+	}}
+	m := &plugin.Manager{Plugins: []*plugin.Plugin{stops, hangs}, DB: database.Database}
+	t.Cleanup(func() {
+		close(release)
+		m.Shutdown(time.Minute)
+	})
+
+	m.StartBackgroundRun(stops)
+	if !m.Shutdown(5 * time.Second) {
+		t.Fatal("expected the run to stop before the deadline")
+	}
+	if runs := storedRuns(db); runs["Stops"] != plugin.JobCancelled {
+		t.Errorf("expected the cancelled run to be stored, got %v", runs)
+	}
+
+	m.StartBackgroundRun(hangs)
+	if m.Shutdown(50 * time.Millisecond) {
+		t.Error("expected a run that ignores its context to miss the deadline")
+	}
+}
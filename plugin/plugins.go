@@ -1,6 +1,8 @@
 package plugin
 
 import (
+	"time"
+
 	"github.com/nielsvanm/homemanager/plugin/torrentplugin"
 	"github.com/nielsvanm/homemanager/plugin/ytsamplugin"
 )
@@ -9,26 +11,42 @@ import (
 func init() {
 	PluginManager.Plugins = []*Plugin{
 		&Plugin{
-			"YTSAMPlugin",
-			"Pulls movies from YTS.AM and allows you to download them",
-			"Entertainment",
-			ytsamplugin.SetupDB,
-			ytsamplugin.Tables,
-			ytsamplugin.APIEndpoints,
-			ytsamplugin.ViewEndpoints,
-			ytsamplugin.GetMovies,
-			[]string{"torrents"},
+			Name:          "YTSAMPlugin",
+			Description:   "Pulls movies from YTS.AM and allows you to download them",
+			Category:      "Entertainment",
+			SetupDatabase: ytsamplugin.SetupDB,
+			Tables:        ytsamplugin.Tables,
+			APIEndpoints:  ytsamplugin.APIEndpoints,
+			ViewEndpoints: ytsamplugin.ViewEndpoints,
+			Widgets:       ytsamplugin.Widgets,
+			Metrics:       ytsamplugin.Metrics,
+			Permissions:   ytsamplugin.Permissions,
+			Templates:     ytsamplugin.Templates,
+			Static:        ytsamplugin.Static,
+			Stream:        ytsamplugin.StreamMovies,
+			Timeout:       2 * time.Hour,
+			DataDirs:      []string{"torrents"},
+			SoftQuota:     100 * 1000 * 1000,
+			HardQuota:     500 * 1000 * 1000,
 		},
 		&Plugin{
-			"TorrentPlugin",
-			"Download torrents",
-			"Internet",
-			torrentplugin.SetupDB,
-			torrentplugin.Tables,
-			torrentplugin.APIEndpoints,
-			torrentplugin.ViewEndpoints,
-			torrentplugin.UpdateTorrents,
-			[]string{"torrents", "downloads"},
+			Name:          "TorrentPlugin",
+			Description:   "Download torrents",
+			Category:      "Internet",
+			SetupDatabase: torrentplugin.SetupDB,
+			Tables:        torrentplugin.Tables,
+			APIEndpoints:  torrentplugin.APIEndpoints,
+			ViewEndpoints: torrentplugin.ViewEndpoints,
+			Widgets:       torrentplugin.Widgets,
+			Topics:        torrentplugin.Topics,
+			Metrics:       torrentplugin.Metrics,
+			Permissions:   torrentplugin.Permissions,
+			Templates:     torrentplugin.Templates,
+			Main:          torrentplugin.UpdateTorrents,
+			Timeout:       time.Minute,
+			HealthCheck:   torrentplugin.HealthCheck,
+			DataDirs:      []string{"torrents", "downloads"},
 		},
+		// New plugins are added above this line by the plugin generator
 	}
 }
@@ -0,0 +1,90 @@
+package plugintest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nielsvanm/homemanager/database"
+)
+
+// AssertStatus fails the test when the response has a different status
+func AssertStatus(t testing.TB, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+
+	if rec.Code != status {
+		t.Fatalf("expected status %d, got %d: %s", status, rec.Code, rec.Body.String())
+	}
+}
+
+// AssertPage fails the test when the response is not a succesfully rendered
+// page that contains all the provided strings
+func AssertPage(t testing.TB, rec *httptest.ResponseRecorder, contains ...string) {
+	t.Helper()
+
+	AssertStatus(t, rec, http.StatusOK)
+
+	body := rec.Body.String()
+	if body == "" {
+		t.Fatal("expected a rendered page, got an empty body")
+	}
+	for _, str := range contains {
+		if !strings.Contains(body, str) {
+			t.Errorf("expected page to contain %q", str)
+		}
+	}
+}
+
+// AssertJSON fails the test when the response is not a succesful json
+// response, the body is decoded into v
+func AssertJSON(t testing.TB, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	AssertStatus(t, rec, http.StatusOK)
+
+	contentType := rec.Header().Get("Content-Type")
+	if !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("expected a json content type, got %q", contentType)
+	}
+
+	err := json.Unmarshal(rec.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("failed to decode json response: %s\n%s", err.Error(), rec.Body.String())
+	}
+}
+
+// AssertBatch fails the test when none of the batches has a query containing
+// the provided string or when it does not have the expected amount of rows,
+// a negative amount skips the row check. It returns the matching batch
+func AssertBatch(t testing.TB, batches []database.BatchQuery, query string, rows int) database.BatchQuery {
+	t.Helper()
+
+	for _, batch := range batches {
+		if !strings.Contains(batch.Query, query) {
+			continue
+		}
+		if rows >= 0 && len(batch.Values) != rows {
+			t.Errorf("expected %d rows for %q, got %d", rows, query, len(batch.Values))
+		}
+		return batch
+	}
+
+	t.Fatalf("expected a batch with a query containing %q", query)
+	return database.BatchQuery{}
+}
+
+// AssertBatchRow fails the test when the row of the batch does not have the
+// provided values
+func AssertBatchRow(t testing.TB, batch database.BatchQuery, row int, values ...interface{}) {
+	t.Helper()
+
+	if row >= len(batch.Values) {
+		t.Fatalf("expected at least %d rows, got %d", row+1, len(batch.Values))
+	}
+	if !reflect.DeepEqual(batch.Values[row], values) {
+		t.Errorf("expected row %d to be %v, got %v", row, values, batch.Values[row])
+	}
+}
@@ -0,0 +1,287 @@
+package plugintest
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nielsvanm/homemanager/database"
+)
+
+// fakeDriverName is the sql driver of the fake database
+const fakeDriverName = "plugintest"
+
+var fakeDBs = map[string]*FakeDB{}
+var fakeDBsLock sync.Mutex
+var nextFakeDB = 0
+
+func init() {
+	sql.Register(fakeDriverName, fakeDriver{})
+}
+
+// FakeDB is the in-memory database tests use when no postgres test database
+// is configured. It doesn't run the queries, it records the statements that
+// are executed and answers queries with the rows that were set up with
+// HandleQuery. Queries without rows return an empty result
+type FakeDB struct {
+	t testing.TB
+
+	lock     sync.Mutex
+	name     string
+	handlers []queryHandler
+	execs    []Statement
+	queries  []Statement
+}
+
+// Statement is a statement that was sent to the fake database
+type Statement struct {
+	Query string
+	Args  []interface{}
+}
+
+type queryHandler struct {
+	match   string
+	columns []string
+	rows    [][]driver.Value
+	err     error
+}
+
+// NewFakeDB creates an empty fake database, it is forgotten when the test
+// finishes
+func NewFakeDB(t testing.TB) *FakeDB {
+	fakeDBsLock.Lock()
+	defer fakeDBsLock.Unlock()
+
+	nextFakeDB++
+	f := &FakeDB{t: t, name: "fakedb" + strconv.Itoa(nextFakeDB)}
+	fakeDBs[f.name] = f
+
+	t.Cleanup(func() {
+		fakeDBsLock.Lock()
+		delete(fakeDBs, f.name)
+		fakeDBsLock.Unlock()
+	})
+
+	return f
+}
+
+// UseFakeDB makes a new fake database the app's database until the test
+// finishes, for tests of code that uses database.Database without a harness
+func UseFakeDB(t testing.TB) *FakeDB {
+	t.Helper()
+
+	f := NewFakeDB(t)
+	db, err := database.OpenDriver(fakeDriverName, f.name)
+	if err != nil {
+		t.Fatalf("plugintest: failed to open the fake database: %s", err.Error())
+	}
+
+	previous := database.Database
+	database.Database = db
+
+	t.Cleanup(func() {
+		database.Database = previous
+		db.Close()
+	})
+
+	return f
+}
+
+// HandleQuery answers queries that contain match with the rows, the values of
+// a row are in the order of the columns. Whitespace in the query and match is
+// compared loosely and handlers that are added later win
+func (f *FakeDB) HandleQuery(match string, columns []string, rows ...[]interface{}) {
+	f.t.Helper()
+
+	handler := queryHandler{normalizeQuery(match), columns, nil, nil}
+	for _, row := range rows {
+		if len(row) != len(columns) {
+			f.t.Fatalf("plugintest: row %v has %d values for %d columns", row, len(row), len(columns))
+		}
+
+		values := make([]driver.Value, len(row))
+		for i, value := range row {
+			converted, err := driver.DefaultParameterConverter.ConvertValue(value)
+			if err != nil {
+				f.t.Fatalf("plugintest: unsupported value %v in row: %s", value, err.Error())
+			}
+			values[i] = converted
+		}
+		handler.rows = append(handler.rows, values)
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.handlers = append(f.handlers, handler)
+}
+
+// FailQuery makes queries that contain match fail with err, like HandleQuery
+// handlers that are added later win
+func (f *FakeDB) FailQuery(match string, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.handlers = append(f.handlers, queryHandler{normalizeQuery(match), nil, nil, err})
+}
+
+// Execs returns the statements that have been executed so far
+func (f *FakeDB) Execs() []Statement {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]Statement{}, f.execs...)
+}
+
+// Queries returns the queries that have been made so far
+func (f *FakeDB) Queries() []Statement {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return append([]Statement{}, f.queries...)
+}
+
+// Reset forgets the statements that have been sent so far, the handlers are
+// kept
+func (f *FakeDB) Reset() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.execs = nil
+	f.queries = nil
+}
+
+func (f *FakeDB) exec(query string, args []driver.Value) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.execs = append(f.execs, newStatement(query, args))
+}
+
+func (f *FakeDB) query(query string, args []driver.Value) (*fakeRows, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.queries = append(f.queries, newStatement(query, args))
+
+	normalized := normalizeQuery(query)
+	for i := len(f.handlers) - 1; i >= 0; i-- {
+		handler := f.handlers[i]
+		if strings.Contains(normalized, handler.match) {
+			if handler.err != nil {
+				return nil, handler.err
+			}
+			return &fakeRows{columns: handler.columns, rows: handler.rows}, nil
+		}
+	}
+
+	return &fakeRows{}, nil
+}
+
+func newStatement(query string, args []driver.Value) Statement {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg
+	}
+
+	return Statement{query, values}
+}
+
+// normalizeQuery collapses the whitespace of a query
+func normalizeQuery(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// fakeDriver opens connections to the fake database with the name
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBsLock.Lock()
+	defer fakeDBsLock.Unlock()
+
+	f, ok := fakeDBs[name]
+	if !ok {
+		return nil, driver.ErrBadConn
+	}
+
+	return &fakeConn{f}, nil
+}
+
+type fakeConn struct {
+	db *FakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{c.db, query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+// fakeTx is a transaction of the fake database, the statements in it are
+// recorded right away
+type fakeTx struct{}
+
+func (fakeTx) Commit() error {
+	return nil
+}
+
+func (fakeTx) Rollback() error {
+	return nil
+}
+
+type fakeStmt struct {
+	db    *FakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.exec(s.query, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.db.query(s.query, args)
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+	next    int
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[r.next])
+	r.next++
+
+	return nil
+}
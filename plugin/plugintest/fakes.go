@@ -0,0 +1,171 @@
+package plugintest
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nielsvanm/homemanager/database"
+)
+
+// FakeClock is a clock that only moves when the test tells it to
+type FakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+// NewFakeClock creates a fake clock that is set to now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the time of the fake clock
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+// Set changes the time of the fake clock
+func (c *FakeClock) Set(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = now
+}
+
+// Advance moves the fake clock forward
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// FakeTransport answers outbound http requests with canned responses, a
+// request without a matching response fails the test
+type FakeTransport struct {
+	t        testing.TB
+	lock     sync.Mutex
+	handlers map[string]func(r *http.Request) (*http.Response, error)
+	requests []*http.Request
+}
+
+// NewFakeTransport creates a fake transport without any responses
+func NewFakeTransport(t testing.TB) *FakeTransport {
+	return &FakeTransport{
+		t:        t,
+		handlers: map[string]func(r *http.Request) (*http.Response, error){},
+	}
+}
+
+// Handle responds to requests for the url with the status and body. The url
+// is matched with its query, or without it when no handler has the query
+func (ft *FakeTransport) Handle(url string, status int, body string) {
+	ft.HandleFunc(url, func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+			Request:    r,
+		}, nil
+	})
+}
+
+// HandleFunc responds to requests for the url with the function
+func (ft *FakeTransport) HandleFunc(url string, handler func(r *http.Request) (*http.Response, error)) {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	ft.handlers[url] = handler
+}
+
+// Requests returns the requests that have been made so far
+func (ft *FakeTransport) Requests() []*http.Request {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+
+	requests := make([]*http.Request, len(ft.requests))
+	copy(requests, ft.requests)
+
+	return requests
+}
+
+// RoundTrip implements http.RoundTripper
+func (ft *FakeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ft.lock.Lock()
+	ft.requests = append(ft.requests, r)
+
+	handler, ok := ft.handlers[r.URL.String()]
+	if !ok {
+		withoutQuery := *r.URL
+		withoutQuery.RawQuery = ""
+		handler, ok = ft.handlers[withoutQuery.String()]
+	}
+	ft.lock.Unlock()
+
+	if err := r.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		ft.t.Errorf("plugintest: unexpected outbound request to %s", r.URL.String())
+		return nil, errors.New("plugintest: no fake response for " + r.URL.String())
+	}
+
+	return handler(r)
+}
+
+// Commit is a single commit of a streaming plugin
+type Commit struct {
+	Checkpoint string
+	Batches    []database.BatchQuery
+}
+
+// RecordingSink is a sink that remembers the commits instead of storing them
+type RecordingSink struct {
+	Commits []Commit
+
+	// Err is returned by Commit when it is set
+	Err error
+}
+
+// Commit implements database.Sink
+func (rs *RecordingSink) Commit(ctx context.Context, checkpoint string, batches ...database.BatchQuery) error {
+	if rs.Err != nil {
+		return rs.Err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	rs.Commits = append(rs.Commits, Commit{checkpoint, batches})
+
+	return nil
+}
+
+// Batches returns the batches of all commits
+func (rs *RecordingSink) Batches() []database.BatchQuery {
+	batches := []database.BatchQuery{}
+	for _, commit := range rs.Commits {
+		batches = append(batches, commit.Batches...)
+	}
+
+	return batches
+}
+
+// Checkpoint returns the checkpoint of the last commit
+func (rs *RecordingSink) Checkpoint() string {
+	if len(rs.Commits) == 0 {
+		return ""
+	}
+
+	return rs.Commits[len(rs.Commits)-1].Checkpoint
+}
@@ -0,0 +1,312 @@
+// Package plugintest runs a plugin in isolation for tests. It mounts the
+// plugin's endpoints on a router, gives it a temporary data folder and
+// database, and replaces the clock and outbound http with fakes.
+//
+// By default the database is a FakeDB in memory, it records the statements
+// the plugin executes and answers queries with canned rows, so views can be
+// tested without a database server. The fake doesn't run sql: rows that are
+// written can't be read back and constraints are not checked. Tests that rely
+// on that call RequirePostgres, they run against a temporary schema of the
+// postgres server in the HOMEMANAGER_TEST_DB environment variable, e.g.
+// "user=postgres password=secret dbname=test sslmode=disable", and are
+// skipped when it is unset.
+package plugintest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nielsvanm/homemanager/auth"
+	"github.com/nielsvanm/homemanager/database"
+	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/middleware"
+	"github.com/nielsvanm/homemanager/plugin"
+	"github.com/nielsvanm/homemanager/storage"
+	"github.com/nielsvanm/homemanager/tools/clock"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// DBEnvironment is the environment variable with the test database
+const DBEnvironment = "HOMEMANAGER_TEST_DB"
+
+// Harness is a plugin that is set up for a single test
+type Harness struct {
+	T      testing.TB
+	Plugin *plugin.Plugin
+
+	// DB is the database of the test, it is backed by FakeDB until
+	// RequirePostgres switches it to a temporary postgres schema
+	DB     *database.DB
+	FakeDB *FakeDB
+
+	// Handler serves the endpoints and static files of the plugin
+	Handler http.Handler
+
+	Clock *FakeClock
+	HTTP  *FakeTransport
+
+	// Role is the role requests are authorized as, it defaults to admin so
+	// every endpoint is allowed
+	Role string
+}
+
+// New sets up a copy of the plugin for the test, everything it changes is
+// restored when the test finishes
+func New(t testing.TB, p *plugin.Plugin) *Harness {
+	t.Helper()
+
+	if p == nil {
+		t.Fatal("plugintest: plugin is nil, is it registered in plugins.go?")
+	}
+
+	// Work on a copy so the registered plugin is left alone
+	cp := *p
+	h := &Harness{T: t, Plugin: &cp, Role: auth.RoleAdmin}
+
+	h.useCoreFiles()
+	h.useLogFile()
+	h.useStorage()
+	h.useFakes()
+	h.useDB()
+	h.useRole()
+
+	h.Plugin.Setup()
+	h.createTables()
+	h.FakeDB.Reset()
+
+	server := frame.NewWebServer()
+	server.Use(middleware.RequestID, middleware.Recover)
+	server.AddEndpoints(h.Plugin.APIEndpoints)
+	server.AddEndpoints(h.Plugin.ViewEndpoints)
+	if h.Plugin.Static != nil {
+		server.AddStatic("/static/plugins/"+strings.ToLower(h.Plugin.Name)+"/", h.Plugin.Static)
+	}
+	h.Handler = server.Handler()
+
+	return h
+}
+
+// RequirePostgres switches the test from the fake database to a temporary
+// postgres schema with the tables of the plugin, the test is skipped when no
+// postgres test database is configured
+func (h *Harness) RequirePostgres() {
+	h.T.Helper()
+
+	if h.FakeDB == nil {
+		return
+	}
+
+	connectionString := os.Getenv(DBEnvironment)
+	if connectionString == "" {
+		h.T.Skip("plugintest: " + DBEnvironment + " is not set, skipping test that needs postgres")
+	}
+
+	h.usePostgres(connectionString)
+	h.createTables()
+}
+
+// Run calls the main function of the plugin and returns its batches, for
+// streaming plugins it returns the batches of all commits of a fresh run
+func (h *Harness) Run(ctx context.Context) []database.BatchQuery {
+	h.T.Helper()
+
+	if h.Plugin.Main == nil && h.Plugin.Stream != nil {
+		sink, err := h.Stream(ctx, "")
+		if err != nil {
+			h.T.Fatalf("plugintest: stream failed: %s", err.Error())
+		}
+		return sink.Batches()
+	}
+
+	return h.Plugin.Main(ctx)
+}
+
+// Stream calls the stream function of the plugin from the checkpoint and
+// returns the sink that recorded its commits
+func (h *Harness) Stream(ctx context.Context, checkpoint string) (*RecordingSink, error) {
+	h.T.Helper()
+
+	if h.Plugin.Stream == nil {
+		h.T.Fatal("plugintest: plugin has no stream function")
+	}
+
+	sink := &RecordingSink{}
+	err := h.Plugin.Stream(ctx, checkpoint, sink)
+
+	return sink, err
+}
+
+// Commit executes the batches in the test database, the fake database only
+// records them
+func (h *Harness) Commit(batches []database.BatchQuery) {
+	h.T.Helper()
+
+	for _, batch := range batches {
+		err := h.DB.ExecBatchContext(context.Background(), batch)
+		if err != nil {
+			h.T.Fatalf("plugintest: failed to commit batch: %s", err.Error())
+		}
+	}
+}
+
+// Do serves the request with the plugin's router and returns the recorded
+// response
+func (h *Harness) Do(r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.Handler.ServeHTTP(rec, r)
+
+	return rec
+}
+
+// Request builds a request for the path and serves it
+func (h *Harness) Request(method, path string, body io.Reader) *httptest.ResponseRecorder {
+	return h.Do(httptest.NewRequest(method, path, body))
+}
+
+// Get serves a GET request for the path
+func (h *Harness) Get(path string) *httptest.ResponseRecorder {
+	return h.Request(http.MethodGet, path, nil)
+}
+
+// PostForm serves a POST request with the form values for the path
+func (h *Harness) PostForm(path string, values url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return h.Do(r)
+}
+
+// useCoreFiles points the framework at the core templates and static files of
+// the repository, the embedded copies live in the main package which tests
+// can't import
+func (h *Harness) useCoreFiles() {
+	h.T.Helper()
+
+	dir, err := os.Getwd()
+	if err != nil {
+		h.T.Fatal(err)
+	}
+
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "templates", "base.html")); err == nil {
+			break
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			h.T.Fatal("plugintest: can't find templates/base.html in any parent folder")
+		}
+		dir = parent
+	}
+
+	previousTemplates, previousStatic := frame.TemplateFS, frame.StaticFS
+	frame.TemplateFS = os.DirFS(filepath.Join(dir, "templates"))
+	frame.StaticFS = os.DirFS(filepath.Join(dir, "static"))
+	frame.ReloadTemplates()
+	h.T.Cleanup(func() {
+		frame.TemplateFS, frame.StaticFS = previousTemplates, previousStatic
+		frame.ReloadTemplates()
+	})
+}
+
+// useLogFile writes the log to a temporary file instead of the package folder
+func (h *Harness) useLogFile() {
+	previous := log.File
+	log.File = filepath.Join(h.T.TempDir(), "log.txt")
+	h.T.Cleanup(func() { log.File = previous })
+}
+
+// useStorage stores plugin data in a temporary folder
+func (h *Harness) useStorage() {
+	previous := storage.Root
+	storage.Root = h.T.TempDir()
+	h.T.Cleanup(func() { storage.Root = previous })
+}
+
+// useFakes installs the fake clock and the fake http transport
+func (h *Harness) useFakes() {
+	h.Clock = NewFakeClock(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	previousClock := clock.Set(h.Clock)
+
+	h.HTTP = NewFakeTransport(h.T)
+	previousTransport := http.DefaultTransport
+	http.DefaultTransport = h.HTTP
+
+	h.T.Cleanup(func() {
+		clock.Set(previousClock)
+		http.DefaultTransport = previousTransport
+	})
+}
+
+// useRole authorizes the requests of the test as a user with the Role of the
+// harness
+func (h *Harness) useRole() {
+	previous := frame.Authorizer
+	frame.Authorizer = func(r *http.Request, permission string) bool {
+		return auth.RoleHasPermission(h.Role, permission)
+	}
+	h.T.Cleanup(func() { frame.Authorizer = previous })
+}
+
+// createTables creates the run history and the tables of the plugin
+func (h *Harness) createTables() {
+	queries := []string{}
+	queries = append(queries, plugin.RunSetupDB...)
+	queries = append(queries, h.Plugin.SetupDatabase...)
+	err := h.DB.CreateTables(queries)
+	if err != nil {
+		h.T.Fatalf("plugintest: failed to create the tables: %s", err.Error())
+	}
+}
+
+// useDB makes a fake database the app's database for the duration of the test
+func (h *Harness) useDB() {
+	h.FakeDB = UseFakeDB(h.T)
+	h.DB = database.Database
+}
+
+// usePostgres creates a temporary schema in the postgres test database and
+// makes it the app's database instead of the fake
+func (h *Harness) usePostgres(connectionString string) {
+	h.T.Helper()
+
+	admin, err := database.Open(connectionString)
+	if err != nil {
+		h.T.Fatalf("plugintest: failed to connect to the test database: %s", err.Error())
+	}
+
+	schema := fmt.Sprintf("plugintest_%d_%d", time.Now().UnixNano(), rand.Intn(100000))
+	err = admin.Exec("CREATE SCHEMA " + schema)
+	if err != nil {
+		admin.Close()
+		h.T.Fatalf("plugintest: failed to create schema: %s", err.Error())
+	}
+
+	db, err := database.Open(connectionString + " search_path=" + schema)
+	if err != nil {
+		admin.Close()
+		h.T.Fatalf("plugintest: failed to connect to the test schema: %s", err.Error())
+	}
+	h.DB = db
+	h.FakeDB = nil
+
+	// The cleanup of useDB, which runs after this one, restores the app's
+	// database
+	database.Database = db
+
+	h.T.Cleanup(func() {
+		db.Close()
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+}
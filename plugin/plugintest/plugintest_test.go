@@ -0,0 +1,329 @@
+package plugintest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nielsvanm/homemanager/auth"
+	"github.com/nielsvanm/homemanager/database"
+	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/plugin"
+	"github.com/nielsvanm/homemanager/storage"
+	"github.com/nielsvanm/homemanager/tools/clock"
+)
+
+// recorder is a testing.TB that records failures instead of failing the test
+type recorder struct {
+	testing.TB
+
+	lock     sync.Mutex
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+// run calls fn in its own goroutine so Fatalf can stop it
+func (r *recorder) run(fn func(t testing.TB)) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(r)
+	}()
+	<-done
+}
+
+func (r *recorder) failed(contains string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, failure := range r.failures {
+		if strings.Contains(failure, contains) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func get(t *testing.T, client *http.Client, url string) (string, error) {
+	t.Helper()
+
+	res, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	return string(body), err
+}
+
+func TestFakeTransportMatchesQueryFirst(t *testing.T) {
+	ft := NewFakeTransport(t)
+	ft.Handle("https://api.test/list?page=2", http.StatusOK, "page two")
+	ft.Handle("https://api.test/list", http.StatusOK, "any page")
+	client := &http.Client{Transport: ft}
+
+	tests := []struct {
+		url  string
+		body string
+	}{
+		{"https://api.test/list?page=2", "page two"},
+		{"https://api.test/list?page=3", "any page"},
+		{"https://api.test/list", "any page"},
+	}
+	for _, test := range tests {
+		body, err := get(t, client, test.url)
+		if err != nil || body != test.body {
+			t.Errorf("%s: expected %q, got %q %v", test.url, test.body, body, err)
+		}
+	}
+
+	if requests := ft.Requests(); len(requests) != len(tests) {
+		t.Errorf("expected %d recorded requests, got %d", len(tests), len(requests))
+	}
+}
+
+func TestFakeTransportFailsUnexpectedRequests(t *testing.T) {
+	rec := &recorder{}
+	ft := NewFakeTransport(rec)
+	ft.Handle("https://api.test/list", http.StatusOK, "")
+
+	_, err := get(t, &http.Client{Transport: ft}, "https://other.test/list")
+	if err == nil {
+		t.Error("expected the unexpected request to fail")
+	}
+	if !rec.failed("unexpected outbound request to https://other.test/list") {
+		t.Errorf("expected the test to be failed, got %v", rec.failures)
+	}
+}
+
+func TestFakeTransportHonorsContext(t *testing.T) {
+	ft := NewFakeTransport(t)
+	ft.Handle("https://api.test/list", http.StatusOK, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.test/list", nil)
+
+	_, err := (&http.Client{Transport: ft}).Do(r)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancelled request to fail, got %v", err)
+	}
+}
+
+func TestRecordingSink(t *testing.T) {
+	sink := &RecordingSink{}
+	if sink.Checkpoint() != "" {
+		t.Error("expected an empty checkpoint before the first commit")
+	}
+
+	first := database.BatchQuery{Query: "INSERT INTO a"}
+	second := database.BatchQuery{Query: "INSERT INTO b"}
+	sink.Commit(context.Background(), "2", first)
+	sink.Commit(context.Background(), "3", second)
+
+	if sink.Checkpoint() != "3" {
+		t.Errorf("expected checkpoint 3, got %q", sink.Checkpoint())
+	}
+	if batches := sink.Batches(); len(batches) != 2 || batches[1].Query != "INSERT INTO b" {
+		t.Errorf("expected the batches of both commits in order, got %v", batches)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := sink.Commit(ctx, "4"); err != context.Canceled {
+		t.Errorf("expected a commit with a cancelled context to fail, got %v", err)
+	}
+
+	sink.Err = errors.New("disk full")
+	if err := sink.Commit(context.Background(), "4"); err != sink.Err {
+		t.Errorf("expected the commit to fail with Err, got %v", err)
+	}
+	if len(sink.Commits) != 2 {
+		t.Errorf("expected failed commits not to be recorded, got %d commits", len(sink.Commits))
+	}
+}
+
+func TestAssertBatch(t *testing.T) {
+	batch := database.BatchQuery{Query: "INSERT INTO demo_item (name, size) VALUES ($1, $2)"}
+	batch.AddValues("first", 1)
+	batch.AddValues("second", 2)
+	batches := []database.BatchQuery{{Query: "INSERT INTO other"}, batch}
+
+	tests := []struct {
+		name    string
+		assert  func(t testing.TB)
+		failure string
+	}{
+		{"matching batch", func(t testing.TB) { AssertBatch(t, batches, "demo_item", 2) }, ""},
+		{"any amount of rows", func(t testing.TB) { AssertBatch(t, batches, "demo_item", -1) }, ""},
+		{"wrong amount of rows", func(t testing.TB) { AssertBatch(t, batches, "demo_item", 3) }, "expected 3 rows"},
+		{"missing batch", func(t testing.TB) { AssertBatch(t, batches, "missing", 0) }, "expected a batch"},
+		{"matching row", func(t testing.TB) { AssertBatchRow(t, batch, 1, "second", 2) }, ""},
+		{"different row", func(t testing.TB) { AssertBatchRow(t, batch, 0, "second", 2) }, "expected row 0"},
+		{"missing row", func(t testing.TB) { AssertBatchRow(t, batch, 2, "third", 3) }, "expected at least 3 rows"},
+	}
+
+	for _, test := range tests {
+		rec := &recorder{}
+		rec.run(test.assert)
+
+		if test.failure == "" && len(rec.failures) > 0 {
+			t.Errorf("%s: expected no failures, got %v", test.name, rec.failures)
+		}
+		if test.failure != "" && !rec.failed(test.failure) {
+			t.Errorf("%s: expected a failure with %q, got %v", test.name, test.failure, rec.failures)
+		}
+	}
+
+	if got := AssertBatch(t, batches, "demo_item", 2); got.Query != batch.Query {
+		t.Errorf("expected the matching batch to be returned, got %q", got.Query)
+	}
+}
+
+func TestFakeDB(t *testing.T) {
+	h := New(t, &plugin.Plugin{Name: "Harness"})
+
+	h.FakeDB.HandleQuery("SELECT id, name FROM items", []string{"id", "name"},
+		[]interface{}{1, "first"},
+		[]interface{}{2, "second"},
+	)
+	h.FakeDB.HandleQuery("SELECT COUNT(*) FROM items", []string{"count"}, []interface{}{2})
+
+	rows := h.DB.Query(`
+	SELECT id, name
+	FROM items
+	ORDER BY id;`)
+	names := []string{}
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if !reflect.DeepEqual(names, []string{"first", "second"}) {
+		t.Errorf("expected the canned rows, got %v", names)
+	}
+
+	var count int
+	h.DB.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM items").Scan(&count)
+	if count != 2 {
+		t.Errorf("expected the canned count, got %d", count)
+	}
+
+	rows = h.DB.Query("SELECT id FROM unknown")
+	if rows.Next() {
+		t.Error("expected queries without canned rows to be empty")
+	}
+	rows.Close()
+
+	h.FakeDB.FailQuery("FROM items", errors.New("connection refused"))
+	if rows := h.DB.Query("SELECT id, name FROM items"); rows != nil {
+		t.Error("expected the failing query to return no rows")
+	}
+	if err := h.DB.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM items").Scan(&count); err == nil {
+		t.Error("expected the failing query to return its error")
+	}
+
+	batch := database.BatchQuery{Query: "INSERT INTO items (name) VALUES ($1)"}
+	batch.AddValues("third")
+	h.Commit([]database.BatchQuery{batch})
+
+	execs := h.FakeDB.Execs()
+	if len(execs) != 1 || execs[0].Query != batch.Query || !reflect.DeepEqual(execs[0].Args, []interface{}{"third"}) {
+		t.Errorf("expected only the committed statement to be recorded, got %v", execs)
+	}
+	if queries := h.FakeDB.Queries(); len(queries) != 5 {
+		t.Errorf("expected 5 recorded queries, got %d", len(queries))
+	}
+}
+
+func TestNewRestoresGlobals(t *testing.T) {
+	transport := http.DefaultTransport
+	root := storage.Root
+	authorizer := reflect.ValueOf(frame.Authorizer).Pointer()
+	db := database.Database
+	templates, static := frame.TemplateFS, frame.StaticFS
+
+	t.Run("harness", func(t *testing.T) {
+		h := New(t, &plugin.Plugin{Name: "Harness"})
+
+		if http.DefaultTransport != h.HTTP {
+			t.Error("expected outbound http to use the fake transport")
+		}
+		if storage.Root == root {
+			t.Error("expected a temporary storage root")
+		}
+		if database.Database != h.DB {
+			t.Error("expected the fake database to be the app's database")
+		}
+		if !clock.Now().Equal(h.Clock.Now()) {
+			t.Error("expected the fake clock to be the app's clock")
+		}
+	})
+
+	if http.DefaultTransport != transport {
+		t.Error("expected http.DefaultTransport to be restored")
+	}
+	if storage.Root != root {
+		t.Errorf("expected storage.Root to be restored to %q, got %q", root, storage.Root)
+	}
+	if reflect.ValueOf(frame.Authorizer).Pointer() != authorizer {
+		t.Error("expected frame.Authorizer to be restored")
+	}
+	if database.Database != db {
+		t.Error("expected database.Database to be restored")
+	}
+	if frame.TemplateFS != templates || frame.StaticFS != static {
+		t.Error("expected the core files to be restored")
+	}
+	if clock.Since(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)) < time.Hour {
+		t.Error("expected the clock to be restored")
+	}
+}
+
+func TestRoleAuthorizesRequests(t *testing.T) {
+	p := &plugin.Plugin{
+		Name: "Harness",
+		ViewEndpoints: []*frame.Endpoint{
+			frame.NewEndpoint("/secret/", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("secret"))
+			}).WithPermission(auth.PermManageUsers),
+		},
+	}
+	h := New(t, p)
+
+	if rec := h.Get("/harness/secret/"); rec.Code != http.StatusOK {
+		t.Errorf("expected admins to be allowed, got %d", rec.Code)
+	}
+
+	h.Role = auth.RoleGuest
+	if rec := h.Get("/harness/secret/"); rec.Code != http.StatusForbidden {
+		t.Errorf("expected guests to be forbidden, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,550 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nielsvanm/homemanager/database"
+	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/tools/clock"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// Job states
+const (
+	JobRunning   = "running"
+	JobFinished  = "finished"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// JobTopic is the topic the state of every job is published on as a "job"
+// event, when it starts, logs a line, is cancelled and is done
+const JobTopic = "plugins"
+
+// maxJobLines is the amount of log lines a job keeps in memory
+const maxJobLines = 1000
+
+// Finished jobs stay in memory for jobRetention so their pages keep working,
+// at most maxFinishedJobs of them. Older runs are in the run history
+const (
+	jobRetention    = time.Hour
+	maxFinishedJobs = 50
+)
+
+// RunSetupDB creates the table that keeps the history of plugin runs
+var RunSetupDB = []string{
+	`CREATE TABLE IF NOT EXISTS homemanager_plugin_run (
+		id SERIAL PRIMARY KEY,
+		plugin TEXT NOT NULL,
+		status TEXT NOT NULL,
+		started TIMESTAMP NOT NULL,
+		finished TIMESTAMP,
+		summary TEXT
+	);`,
+}
+
+// JobEvent is a message that is sent to the subscribers of a job
+type JobEvent struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// Job is a single run of a plugin's main function in the background
+type Job struct {
+	ID       int
+	Plugin   *Plugin
+	Status   string
+	Progress string
+	Summary  string
+	Started  time.Time
+	Finished time.Time
+
+	// Internal variables
+	lock        sync.Mutex
+	lines       []string
+	subscribers map[chan JobEvent]bool
+	done        chan struct{}
+	stored      chan struct{}
+	cancelled   bool
+	ctx         context.Context
+	cancel      context.CancelFunc
+}
+
+// JobInfo is a copy of the state of a job at one moment
+type JobInfo struct {
+	ID       int        `json:"id"`
+	Plugin   string     `json:"plugin"`
+	Status   string     `json:"status"`
+	Progress string     `json:"progress"`
+	Summary  string     `json:"summary"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished"`
+}
+
+// RunRecord is a finished run as stored in the run history
+type RunRecord struct {
+	ID       int
+	Plugin   string
+	Status   string
+	Started  time.Time
+	Finished time.Time
+	Summary  string
+}
+
+// StartRun runs the main function of the plugin in the background and
+// returns the job that tracks it. If the plugin is already running the
+// running job is returned instead. The run is stopped when ctx is done
+func (m *Manager) StartRun(ctx context.Context, p *Plugin) *Job {
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = m.DefaultTimeout
+	}
+
+	m.jobsLock.Lock()
+	defer m.jobsLock.Unlock()
+
+	if job := m.runningJob(p); job != nil {
+		return job
+	}
+
+	job := m.newJob(p)
+	if timeout > 0 {
+		job.ctx, job.cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		job.ctx, job.cancel = context.WithCancel(ctx)
+	}
+
+	go m.runJob(job)
+	publishJob(job)
+
+	return job
+}
+
+// StartBackgroundRun is StartRun for runs that should outlive the request
+// that started them, they are stopped when the manager's Context is done
+func (m *Manager) StartBackgroundRun(p *Plugin) *Job {
+	ctx := m.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return m.StartRun(ctx, p)
+}
+
+// newJob adds a running job for the plugin, callers hold the jobs lock
+func (m *Manager) newJob(p *Plugin) *Job {
+	m.nextJobID++
+	job := &Job{
+		ID:          m.nextJobID,
+		Plugin:      p,
+		Status:      JobRunning,
+		Started:     clock.Now(),
+		subscribers: map[chan JobEvent]bool{},
+		done:        make(chan struct{}),
+		stored:      make(chan struct{}),
+	}
+	m.pruneJobs()
+	m.jobs = append(m.jobs, job)
+
+	return job
+}
+
+// failRun records a run of the plugin that failed before it could start
+func (m *Manager) failRun(p *Plugin, summary string) *Job {
+	m.jobsLock.Lock()
+	job := m.newJob(p)
+	m.jobsLock.Unlock()
+
+	job.finish(JobFailed, summary)
+	runsTotal.Inc(p.Name, job.Status)
+
+	publishJob(job)
+	log.Err("PluginManager", p.Name, "run", strconv.Itoa(job.ID), job.Status+":", job.Summary)
+	m.storeRun(job)
+
+	return job
+}
+
+// Shutdown cancels the running jobs and waits until they have stored their
+// run history, at most for timeout. It returns false when jobs were still
+// running at the deadline
+func (m *Manager) Shutdown(timeout time.Duration) bool {
+	m.jobsLock.Lock()
+	jobs := append([]*Job{}, m.jobs...)
+	m.jobsLock.Unlock()
+
+	for _, job := range jobs {
+		job.Cancel()
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for _, job := range jobs {
+		select {
+		case <-job.stored:
+		case <-deadline.C:
+			log.Warn("PluginManager", job.Plugin.Name, "run", strconv.Itoa(job.ID), "did not stop in time, its run history is lost")
+			return false
+		}
+	}
+
+	return true
+}
+
+// pruneJobs forgets finished jobs that are older than jobRetention or that
+// are not among the newest maxFinishedJobs, callers hold the jobs lock
+func (m *Manager) pruneJobs() {
+	kept := []*Job{}
+	finished := 0
+	for i := len(m.jobs) - 1; i >= 0; i-- {
+		job := m.jobs[i]
+		if info := job.Info(); info.Finished != nil {
+			if finished >= maxFinishedJobs || clock.Since(*info.Finished) > jobRetention {
+				continue
+			}
+			finished++
+		}
+		kept = append([]*Job{job}, kept...)
+	}
+
+	m.jobs = kept
+}
+
+// GetJob returns the job with the provided id or nil when it is unknown
+func (m *Manager) GetJob(id int) *Job {
+	m.jobsLock.Lock()
+	defer m.jobsLock.Unlock()
+
+	for _, job := range m.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+
+	return nil
+}
+
+// GetRunningJob returns the job that is running the plugin or nil when it
+// isn't running
+func (m *Manager) GetRunningJob(p *Plugin) *Job {
+	m.jobsLock.Lock()
+	defer m.jobsLock.Unlock()
+
+	return m.runningJob(p)
+}
+
+// runningJob is GetRunningJob for callers that hold the jobs lock
+func (m *Manager) runningJob(p *Plugin) *Job {
+	for _, job := range m.jobs {
+		if job.Plugin == p && job.GetStatus() == JobRunning {
+			return job
+		}
+	}
+
+	return nil
+}
+
+// GetRunHistory returns the latest runs of a plugin, newest first
+func (m *Manager) GetRunHistory(pluginName string, limit int) []RunRecord {
+	records, _ := m.GetRunHistoryPage(pluginName, limit, 0)
+	return records
+}
+
+// GetRunHistoryPage returns limit runs of a plugin after skipping offset
+// runs, newest first, together with the total amount of runs
+func (m *Manager) GetRunHistoryPage(pluginName string, limit, offset int) ([]RunRecord, int) {
+	records := []RunRecord{}
+
+	total := 0
+	countRows := m.DB.Query(`
+	SELECT COUNT(*) FROM homemanager_plugin_run
+	WHERE plugin = $1;`, pluginName)
+	if countRows == nil {
+		return records, 0
+	}
+	if countRows.Next() {
+		countRows.Scan(&total)
+	}
+	countRows.Close()
+
+	rows := m.DB.Query(`
+	SELECT id, plugin, status, started, COALESCE(finished, started), COALESCE(summary, '')
+	FROM homemanager_plugin_run
+	WHERE plugin = $1
+	ORDER BY started DESC
+	LIMIT $2 OFFSET $3;`, pluginName, limit, offset)
+	if rows == nil {
+		return records, total
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record := RunRecord{}
+		err := rows.Scan(
+			&record.ID,
+			&record.Plugin,
+			&record.Status,
+			&record.Started,
+			&record.Finished,
+			&record.Summary,
+		)
+		if err != nil {
+			log.Warn("PluginManager", "Failed to scan run history row", err.Error())
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	return records, total
+}
+
+// runJob executes the plugin, commits the results and stores the outcome in
+// the run history
+func (m *Manager) runJob(job *Job) {
+	p := job.Plugin
+	runsRunning.Inc(p.Name)
+
+	// Forward the log lines of the plugin to the job
+	hookID := log.AddHook(func(level, module, message string) {
+		if module == p.Name {
+			job.addLine(level, message)
+			publishJob(job)
+		}
+	})
+
+	defer job.cancel()
+
+	var summary string
+	var err error
+	if p.Stream != nil {
+		summary, err = m.runStream(job)
+	} else {
+		summary, err = m.runBatches(job)
+	}
+	log.RemoveHook(hookID)
+
+	job.finish(runOutcome(job.isCancelled(), err, summary))
+
+	runsRunning.Dec(p.Name)
+	runsTotal.Inc(p.Name, job.Status)
+	runDuration.Observe(job.Finished.Sub(job.Started).Seconds(), p.Name)
+
+	publishJob(job)
+	log.Info("PluginManager", p.Name, "run", strconv.Itoa(job.ID), job.Status+":", job.Summary)
+
+	// The job context may already be done, the history is stored regardless
+	m.storeRun(job)
+}
+
+// storeRun adds the finished job to the run history
+func (m *Manager) storeRun(job *Job) {
+	defer close(job.stored)
+
+	m.DB.Exec(`
+	INSERT INTO homemanager_plugin_run (plugin, status, started, finished, summary)
+	VALUES ($1, $2, $3, $4, $5);`,
+		job.Plugin.Name, job.Status, job.Started, job.Finished, job.Summary)
+}
+
+// runOutcome returns the status and summary of a finished run, plugins often
+// return the context errors wrapped, for example by net/http
+func runOutcome(cancelled bool, err error, summary string) (string, string) {
+	switch {
+	case cancelled || errors.Is(err, context.Canceled):
+		return JobCancelled, "Cancelled, " + summary
+	case errors.Is(err, context.DeadlineExceeded):
+		return JobFailed, "Timed out, " + summary
+	case err != nil:
+		return JobFailed, err.Error() + ", " + summary
+	}
+
+	return JobFinished, summary
+}
+
+// runBatches runs the main function of the plugin and commits all of its
+// batches once it is done
+func (m *Manager) runBatches(job *Job) (string, error) {
+	batches, err := runMain(job.ctx, job.Plugin)
+	if err == nil {
+		err = job.ctx.Err()
+	}
+	if err != nil {
+		return "results have been discarded", err
+	}
+
+	rows := 0
+	for _, batch := range batches {
+		err = m.DB.ExecBatchContext(job.ctx, batch)
+		if err != nil {
+			return fmt.Sprintf("failed to commit results after %d rows", rows), err
+		}
+		rows += len(batch.Values)
+	}
+
+	return fmt.Sprintf("Committed %d batches with %d rows", len(batches), rows), nil
+}
+
+// runMain calls the main function of the plugin and turns a panic into an
+// error so a broken plugin can't take down the server
+func runMain(ctx context.Context, p *Plugin) (batches []database.BatchQuery, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Err("PluginManager", p.Name, "panicked:", fmt.Sprint(r), "\n"+string(debug.Stack()))
+			err = fmt.Errorf("plugin panicked: %v", r)
+		}
+	}()
+
+	return p.Main(ctx), nil
+}
+
+// Cancel stops the job by cancelling its context, results the plugin has not
+// committed yet are discarded
+func (j *Job) Cancel() {
+	j.lock.Lock()
+	if j.Status != JobRunning {
+		j.lock.Unlock()
+		return
+	}
+	j.cancelled = true
+	j.cancel()
+	j.broadcast(JobEvent{"status", "Cancelling, waiting for the plugin to return", j.Status})
+	j.lock.Unlock()
+
+	publishJob(j)
+}
+
+// Wait blocks until the job is done
+func (j *Job) Wait() {
+	<-j.done
+}
+
+// GetStatus returns the current status of the job
+func (j *Job) GetStatus() string {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	return j.Status
+}
+
+// Result returns the status and summary of the job
+func (j *Job) Result() (string, string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	return j.Status, j.Summary
+}
+
+// Info returns a copy of the state of the job
+func (j *Job) Info() JobInfo {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	info := JobInfo{
+		ID:       j.ID,
+		Plugin:   j.Plugin.Name,
+		Status:   j.Status,
+		Progress: j.Progress,
+		Summary:  j.Summary,
+		Started:  j.Started,
+	}
+	if j.Status != JobRunning {
+		finished := j.Finished
+		info.Finished = &finished
+	}
+
+	return info
+}
+
+// Subscribe returns the log lines so far and a channel that receives the
+// events of the job, the channel is closed when the job is done
+func (j *Job) Subscribe() ([]string, chan JobEvent) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	lines := make([]string, len(j.lines))
+	copy(lines, j.lines)
+
+	ch := make(chan JobEvent, 64)
+	if j.Status != JobRunning {
+		ch <- JobEvent{"done", j.Summary, j.Status}
+		close(ch)
+		return lines, ch
+	}
+	j.subscribers[ch] = true
+
+	return lines, ch
+}
+
+// Unsubscribe stops sending events to the channel
+func (j *Job) Unsubscribe(ch chan JobEvent) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.subscribers[ch] {
+		delete(j.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (j *Job) isCancelled() bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	return j.cancelled
+}
+
+func (j *Job) addLine(level, message string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	line := "[" + level + "] " + message
+	j.lines = append(j.lines, line)
+	if len(j.lines) > maxJobLines {
+		j.lines = j.lines[len(j.lines)-maxJobLines:]
+	}
+	j.Progress = message
+
+	j.broadcast(JobEvent{"log", line, j.Status})
+}
+
+func (j *Job) finish(status, summary string) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.Status = status
+	j.Summary = summary
+	j.Finished = clock.Now()
+
+	j.broadcast(JobEvent{"done", summary, status})
+	for ch := range j.subscribers {
+		close(ch)
+	}
+	j.subscribers = map[chan JobEvent]bool{}
+	close(j.done)
+}
+
+// broadcast sends the event to all subscribers, slow subscribers miss events
+// instead of blocking the plugin. The caller must hold the lock
+func (j *Job) broadcast(event JobEvent) {
+	for ch := range j.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// publishJob publishes the state of the job when anybody is listening
+func publishJob(job *Job) {
+	if frame.Events.HasSubscribers(JobTopic) {
+		frame.Events.Publish(JobTopic, "job", job.Info())
+	}
+}
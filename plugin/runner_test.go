@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/nielsvanm/homemanager/tools/clock"
+)
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+func TestPruneJobs(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	defer clock.Set(clock.Set(fixedClock(now)))
+
+	p := &Plugin{Name: "Test"}
+	m := &Manager{}
+	running := &Job{ID: 1, Plugin: p, Status: JobRunning, Started: now.Add(-2 * time.Hour)}
+	old := &Job{ID: 2, Plugin: p, Status: JobFinished, Finished: now.Add(-2 * time.Hour)}
+	m.jobs = []*Job{running, old}
+	for i := 0; i < maxFinishedJobs+5; i++ {
+		m.jobs = append(m.jobs, &Job{ID: 3 + i, Plugin: p, Status: JobFailed, Finished: now.Add(-time.Minute)})
+	}
+
+	m.pruneJobs()
+
+	if len(m.jobs) != maxFinishedJobs+1 {
+		t.Fatalf("expected %d jobs to be kept, got %d", maxFinishedJobs+1, len(m.jobs))
+	}
+	if m.jobs[0] != running {
+		t.Error("expected the running job to be kept")
+	}
+	for _, job := range m.jobs {
+		if job == old {
+			t.Error("expected the job that finished before the retention to be dropped")
+		}
+	}
+	if last := m.jobs[len(m.jobs)-1]; last.ID != 3+maxFinishedJobs+4 {
+		t.Errorf("expected the newest job to be kept last, got job %d", last.ID)
+	}
+}
+
+func TestRunOutcome(t *testing.T) {
+	wrapped := &url.Error{Op: "Get", URL: "https://yts.am", Err: context.DeadlineExceeded}
+
+	tests := []struct {
+		name      string
+		cancelled bool
+		err       error
+		status    string
+		summary   string
+	}{
+		{"finished", false, nil, JobFinished, "2 pages"},
+		{"failed", false, errors.New("boom"), JobFailed, "boom, 2 pages"},
+		{"timed out", false, context.DeadlineExceeded, JobFailed, "Timed out, 2 pages"},
+		{"timed out wrapped", false, wrapped, JobFailed, "Timed out, 2 pages"},
+		{"timed out with %w", false, fmt.Errorf("page 3: %w", context.DeadlineExceeded), JobFailed, "Timed out, 2 pages"},
+		{"cancelled", true, errors.New("boom"), JobCancelled, "Cancelled, 2 pages"},
+		{"context cancelled", false, fmt.Errorf("page 3: %w", context.Canceled), JobCancelled, "Cancelled, 2 pages"},
+	}
+
+	for _, test := range tests {
+		status, summary := runOutcome(test.cancelled, test.err, "2 pages")
+		if status != test.status || summary != test.summary {
+			t.Errorf("%s: expected %s %q, got %s %q", test.name, test.status, test.summary, status, summary)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/nielsvanm/homemanager/middleware"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// StateSetupDB creates the table that remembers which plugins have been
+// disabled in the app
+var StateSetupDB = []string{
+	`CREATE TABLE IF NOT EXISTS homemanager_plugin_state (
+		plugin TEXT PRIMARY KEY,
+		disabled BOOLEAN NOT NULL
+	);`,
+}
+
+// LoadState disables the plugins that were disabled in the app before, a
+// plugin that is disabled on the command line stays disabled
+func (m *Manager) LoadState() {
+	rows := m.DB.Query(`
+	SELECT plugin FROM homemanager_plugin_state
+	WHERE disabled;`)
+	if rows == nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			log.Warn("PluginManager", "Failed to scan plugin state row", err.Error())
+			continue
+		}
+
+		p := m.GetPlugin(name)
+		if p != nil && p.IsEnabled() {
+			p.Disable()
+		}
+	}
+}
+
+// SetEnabled enables or disables the plugin and remembers it for the next
+// start, it takes effect right away
+func (m *Manager) SetEnabled(p *Plugin, enabled bool) error {
+	err := m.DB.Exec(`
+	INSERT INTO homemanager_plugin_state (plugin, disabled)
+	VALUES ($1, $2)
+	ON CONFLICT (plugin) DO UPDATE SET disabled = EXCLUDED.disabled;`, p.Name, !enabled)
+	if err != nil {
+		return err
+	}
+
+	p.setDisabled(!enabled)
+	if enabled {
+		log.Info("PluginManager", "Enabled "+p.Name)
+	} else {
+		log.Info("PluginManager", "Disabled "+p.Name)
+	}
+
+	return nil
+}
+
+// IsEnabled returns false while the plugin is disabled
+func (p *Plugin) IsEnabled() bool {
+	return atomic.LoadInt32(&p.disabled) == 0
+}
+
+// Disable disables the plugin until the app is restarted, SetEnabled also
+// remembers it
+func (p *Plugin) Disable() {
+	p.setDisabled(true)
+	log.Info("PluginManager", "Disabled "+p.Name)
+}
+
+func (p *Plugin) setDisabled(disabled bool) {
+	var value int32
+	if disabled {
+		value = 1
+	}
+	atomic.StoreInt32(&p.disabled, value)
+}
+
+// requireEnabled responds with 404 while the plugin is disabled, the routes
+// of every plugin are registered so a plugin can be enabled without a restart
+func (p *Plugin) requireEnabled(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.IsEnabled() {
+			middleware.ErrorHandler(w, r, http.StatusNotFound, "The plugin "+p.Name+" is disabled")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
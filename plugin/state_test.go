@@ -0,0 +1,43 @@
+package plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestEnabledChangesWhileServing(t *testing.T) {
+	p := &Plugin{Name: "Test"}
+	handler := p.requireEnabled(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			p.setDisabled(i%2 == 0)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/", nil))
+		if rec.Code != http.StatusOK && rec.Code != http.StatusNotFound {
+			t.Fatalf("expected 200 or 404, got %d", rec.Code)
+		}
+	}
+	wg.Wait()
+
+	p.Disable()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/", nil))
+	if p.IsEnabled() || rec.Code != http.StatusNotFound {
+		t.Errorf("expected a disabled plugin to respond with 404, got %d", rec.Code)
+	}
+
+	p.setDisabled(false)
+	if !p.IsEnabled() {
+		t.Error("expected the plugin to be enabled again")
+	}
+}
@@ -0,0 +1,129 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/nielsvanm/homemanager/database"
+	"github.com/nielsvanm/homemanager/tools/clock"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// CheckpointSetupDB creates the table that keeps the last committed
+// checkpoint of streaming plugins
+var CheckpointSetupDB = []string{
+	`CREATE TABLE IF NOT EXISTS homemanager_plugin_checkpoint (
+		plugin TEXT PRIMARY KEY,
+		checkpoint TEXT NOT NULL,
+		updated TIMESTAMP NOT NULL
+	);`,
+}
+
+// dbSink commits the results of a streaming plugin to the database together
+// with its checkpoint
+type dbSink struct {
+	db         *database.DB
+	plugin     *Plugin
+	checkpoint string
+	batches    int
+	rows       int
+}
+
+// Commit stores the batches and the checkpoint in a single transaction, so a
+// checkpoint is only stored when the results before it are
+func (s *dbSink) Commit(ctx context.Context, checkpoint string, batches ...database.BatchQuery) error {
+	checkpointBatch := database.BatchQuery{}
+	checkpointBatch.Query = `
+	INSERT INTO homemanager_plugin_checkpoint (plugin, checkpoint, updated)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (plugin) DO UPDATE
+	SET checkpoint = EXCLUDED.checkpoint, updated = EXCLUDED.updated;`
+	checkpointBatch.AddValues(s.plugin.Name, checkpoint, clock.Now())
+
+	all := append([]database.BatchQuery{}, batches...)
+	err := s.db.ExecBatchesContext(ctx, append(all, checkpointBatch))
+	if err != nil {
+		return err
+	}
+
+	s.checkpoint = checkpoint
+	s.batches += len(batches)
+	for _, batch := range batches {
+		s.rows += len(batch.Values)
+	}
+
+	return nil
+}
+
+// GetCheckpoint returns the last committed checkpoint of the plugin, it is
+// empty when the last run finished or the plugin never ran
+func (m *Manager) GetCheckpoint(pluginName string) string {
+	rows := m.DB.Query(`
+	SELECT checkpoint FROM homemanager_plugin_checkpoint
+	WHERE plugin = $1;`, pluginName)
+	if rows == nil {
+		return ""
+	}
+	defer rows.Close()
+
+	checkpoint := ""
+	for rows.Next() {
+		err := rows.Scan(&checkpoint)
+		if err != nil {
+			log.Warn("PluginManager", "Failed to scan checkpoint row", err.Error())
+		}
+	}
+
+	return checkpoint
+}
+
+// ClearCheckpoint removes the checkpoint of the plugin so the next run
+// starts from the beginning
+func (m *Manager) ClearCheckpoint(pluginName string) {
+	m.DB.Exec(`
+	DELETE FROM homemanager_plugin_checkpoint
+	WHERE plugin = $1;`, pluginName)
+}
+
+// runStream runs a streaming plugin from its last checkpoint, the checkpoint
+// is cleared when the run completes
+func (m *Manager) runStream(job *Job) (string, error) {
+	p := job.Plugin
+
+	checkpoint := m.GetCheckpoint(p.Name)
+	if checkpoint != "" {
+		log.Info(p.Name, "Resuming from checkpoint", checkpoint)
+	}
+
+	sink := &dbSink{db: m.DB, plugin: p, checkpoint: checkpoint}
+	err := runStream(job.ctx, p, checkpoint, sink)
+	if err == nil {
+		err = job.ctx.Err()
+	}
+
+	summary := fmt.Sprintf("Committed %d batches with %d rows", sink.batches, sink.rows)
+	if err != nil {
+		if sink.checkpoint != "" {
+			summary += ", the next run resumes from checkpoint " + sink.checkpoint
+		}
+		return summary, err
+	}
+
+	m.ClearCheckpoint(p.Name)
+
+	return summary, nil
+}
+
+// runStream calls the stream function of the plugin and turns a panic into an
+// error so a broken plugin can't take down the server
+func runStream(ctx context.Context, p *Plugin, checkpoint string, sink database.Sink) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Err("PluginManager", p.Name, "panicked:", fmt.Sprint(r), "\n"+string(debug.Stack()))
+			err = fmt.Errorf("plugin panicked: %v", r)
+		}
+	}()
+
+	return p.Stream(ctx, checkpoint, sink)
+}
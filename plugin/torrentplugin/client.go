@@ -0,0 +1,93 @@
+package torrentplugin
+
+import (
+	"context"
+	"sync"
+
+	"github.com/lnguyen/go-transmission/transmission"
+)
+
+// Transmission is the part of the transmission client the plugin uses
+type Transmission interface {
+	GetTorrents() ([]transmission.Torrent, error)
+	AddTorrentByFilename(filename, downloadDir string) (*transmission.TorrentAdded, error)
+}
+
+// Client talks to the transmission daemon, tests replace it with a fake
+var Client Transmission = newTransmission("http://localhost:9091")
+
+func newTransmission(url string) Transmission {
+	client := transmission.New(url, "", "")
+	return &client
+}
+
+// The transmission client has no timeouts of its own, a daemon that hangs
+// keeps its calls from ever returning. Calls are made in the background and
+// callers give up when their context is done. The torrents are fetched by a
+// single call at a time that every caller waits for, so a hanging daemon
+// holds on to one goroutine instead of one per health check or scrape
+var torrentsCall *clientCall
+var torrentsCallLock sync.Mutex
+
+// clientCall is a call to transmission that is in progress
+type clientCall struct {
+	done     chan struct{}
+	torrents []transmission.Torrent
+	err      error
+}
+
+// fetchTorrents returns the torrents in transmission, or the error of the
+// context when it is done first
+func fetchTorrents(ctx context.Context) ([]transmission.Torrent, error) {
+	torrentsCallLock.Lock()
+	call := torrentsCall
+	if call == nil {
+		call = &clientCall{done: make(chan struct{})}
+		torrentsCall = call
+
+		client := Client
+		go func() {
+			call.torrents, call.err = client.GetTorrents()
+
+			torrentsCallLock.Lock()
+			torrentsCall = nil
+			torrentsCallLock.Unlock()
+			close(call.done)
+		}()
+	}
+	torrentsCallLock.Unlock()
+
+	select {
+	case <-call.done:
+		if call.err != nil {
+			return nil, call.err
+		}
+		// Every caller gets a copy it may change
+		return append([]transmission.Torrent{}, call.torrents...), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// addTorrent adds the torrent file to transmission, or returns the error of
+// the context when it is done first
+func addTorrent(ctx context.Context, filename, downloadDir string) (*transmission.TorrentAdded, error) {
+	type result struct {
+		added *transmission.TorrentAdded
+		err   error
+	}
+	done := make(chan result, 1)
+
+	client := Client
+	go func() {
+		added, err := client.AddTorrentByFilename(filename, downloadDir)
+		done <- result{added, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.added, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
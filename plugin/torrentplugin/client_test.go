@@ -0,0 +1,58 @@
+package torrentplugin
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lnguyen/go-transmission/transmission"
+)
+
+// hangingClient blocks every call until release is closed
+type hangingClient struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (c *hangingClient) GetTorrents() ([]transmission.Torrent, error) {
+	atomic.AddInt32(&c.calls, 1)
+	<-c.release
+	return []transmission.Torrent{{ID: 1}}, nil
+}
+
+func (c *hangingClient) AddTorrentByFilename(filename, downloadDir string) (*transmission.TorrentAdded, error) {
+	<-c.release
+	return &transmission.TorrentAdded{}, nil
+}
+
+func TestCallsGiveUpWhenTransmissionHangs(t *testing.T) {
+	client := &hangingClient{release: make(chan struct{})}
+	previous := Client
+	Client = client
+	defer func() { Client = previous }()
+
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := HealthCheck(ctx)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expected the health check to time out, got %v", err)
+		}
+	}
+	if calls := atomic.LoadInt32(&client.calls); calls != 1 {
+		t.Errorf("expected a single call to transmission while it hangs, got %d", calls)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := addTorrent(ctx, "a.torrent", "downloads"); err != context.DeadlineExceeded {
+		t.Errorf("expected adding a torrent to time out, got %v", err)
+	}
+
+	close(client.release)
+	torrents, err := fetchTorrents(context.Background())
+	if err != nil || len(torrents) != 1 {
+		t.Errorf("expected the torrents once transmission answers, got %v %v", torrents, err)
+	}
+}
@@ -0,0 +1,12 @@
+package torrentplugin
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+// Templates are the html templates of the plugin
+var Templates, _ = fs.Sub(templateFiles, "templates")
@@ -1,7 +1,18 @@
 package torrentplugin
 
-import "github.com/nielsvanm/homemanager/database"
+import (
+	"context"
 
-func UpdateTorrents() []database.BatchQuery {
+	"github.com/nielsvanm/homemanager/database"
+)
+
+// UpdateTorrents is the "main" function of the plugin
+func UpdateTorrents(ctx context.Context) []database.BatchQuery {
 	return nil
 }
+
+// HealthCheck checks if transmission can be reached
+func HealthCheck(ctx context.Context) error {
+	_, err := fetchTorrents(ctx)
+	return err
+}
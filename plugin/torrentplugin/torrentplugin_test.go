@@ -0,0 +1,176 @@
+package torrentplugin_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/lnguyen/go-transmission/transmission"
+	"github.com/nielsvanm/homemanager/auth"
+	"github.com/nielsvanm/homemanager/plugin"
+	"github.com/nielsvanm/homemanager/plugin/plugintest"
+	"github.com/nielsvanm/homemanager/plugin/torrentplugin"
+)
+
+// fakeTransmission answers with canned torrents and records the added ones
+type fakeTransmission struct {
+	torrents []transmission.Torrent
+	err      error
+
+	lock  sync.Mutex
+	added []string
+}
+
+func (f *fakeTransmission) GetTorrents() ([]transmission.Torrent, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return append([]transmission.Torrent{}, f.torrents...), nil
+}
+
+func (f *fakeTransmission) AddTorrentByFilename(filename, downloadDir string) (*transmission.TorrentAdded, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.added = append(f.added, filename, downloadDir)
+
+	return &transmission.TorrentAdded{HashString: "abc123", ID: 3, Name: filepath.Base(filename)}, nil
+}
+
+var cannedTorrents = []transmission.Torrent{
+	{ID: 1, Name: "debian.iso", Status: 4, PercentDone: 0.5, RateDownload: 2000000},
+	{ID: 2, Name: "ubuntu.iso", Status: 6, PercentDone: 1, IsFinished: true},
+}
+
+func setup(t *testing.T, fake *fakeTransmission) *plugintest.Harness {
+	previous := torrentplugin.Client
+	torrentplugin.Client = fake
+	t.Cleanup(func() { torrentplugin.Client = previous })
+
+	return plugintest.New(t, plugin.PluginManager.GetPlugin("TorrentPlugin"))
+}
+
+// upload builds the multipart request that adds a torrent file
+func upload(t *testing.T, filename, content string) *http.Request {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile("torrentfile", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/api/torrentplugin/add/", body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+
+	return r
+}
+
+func TestDashboardView(t *testing.T) {
+	h := setup(t, &fakeTransmission{torrents: cannedTorrents})
+
+	plugintest.AssertPage(t, h.Get("/torrentplugin/"), "debian.iso", "50%", "2.0 MBps", "ubuntu.iso")
+}
+
+func TestDashboardViewWithoutTransmission(t *testing.T) {
+	h := setup(t, &fakeTransmission{err: errors.New("connection refused")})
+
+	plugintest.AssertPage(t, h.Get("/torrentplugin/"), "Failed to connect to transmission")
+}
+
+func TestAddTorrent(t *testing.T) {
+	fake := &fakeTransmission{}
+	h := setup(t, fake)
+
+	rec := h.Do(upload(t, "debian", "d8:announce"))
+	plugintest.AssertStatus(t, rec, http.StatusCreated)
+
+	torrent, _ := h.Plugin.Storage.Path("torrents/debian.torrent")
+	content, err := os.ReadFile(torrent)
+	if err != nil || string(content) != "d8:announce" {
+		t.Errorf("expected the torrent file to be stored, got %q %v", content, err)
+	}
+
+	downloads, _ := h.Plugin.Storage.Path("downloads")
+	if len(fake.added) != 2 || fake.added[0] != torrent || fake.added[1] != downloads {
+		t.Errorf("expected the stored torrent to be added to transmission, got %v", fake.added)
+	}
+}
+
+func TestAddTorrentErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		fake    *fakeTransmission
+		role    string
+		request func(t *testing.T) *http.Request
+		status  int
+	}{
+		{"missing file", &fakeTransmission{}, auth.RoleAdmin, func(t *testing.T) *http.Request {
+			return httptest.NewRequest(http.MethodPost, "/api/torrentplugin/add/", nil)
+		}, http.StatusBadRequest},
+		{"transmission fails", &fakeTransmission{err: errors.New("connection refused")}, auth.RoleAdmin, func(t *testing.T) *http.Request {
+			return upload(t, "debian.torrent", "d8:announce")
+		}, http.StatusBadGateway},
+		{"guest", &fakeTransmission{}, auth.RoleGuest, func(t *testing.T) *http.Request {
+			return upload(t, "debian.torrent", "d8:announce")
+		}, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := setup(t, test.fake)
+			h.Role = test.role
+
+			plugintest.AssertStatus(t, h.Do(test.request(t)), test.status)
+			if len(test.fake.added) != 0 {
+				t.Errorf("expected no torrent to be added, got %v", test.fake.added)
+			}
+		})
+	}
+}
+
+func TestActiveTorrentsWidget(t *testing.T) {
+	h := setup(t, &fakeTransmission{torrents: cannedTorrents})
+
+	data, err := h.Plugin.Widgets[0].Data(httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	active := data.([]transmission.Torrent)
+	if len(active) != 1 || active[0].Name != "debian.iso" || active[0].PercentDone != 50 {
+		t.Errorf("expected only the unfinished torrent, got %v", active)
+	}
+}
+
+func TestActiveTorrentsMetric(t *testing.T) {
+	h := setup(t, &fakeTransmission{torrents: cannedTorrents})
+
+	samples := h.Plugin.Metrics[0].Collect(context.Background())
+	if len(samples) != 1 || samples[0].Value != 1 {
+		t.Errorf("expected a single active torrent, got %v", samples)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	h := setup(t, &fakeTransmission{})
+	if err := h.Plugin.HealthCheck(context.Background()); err != nil {
+		t.Errorf("expected transmission to be healthy, got %v", err)
+	}
+
+	torrentplugin.Client = &fakeTransmission{err: errors.New("connection refused")}
+	if err := h.Plugin.HealthCheck(context.Background()); err == nil {
+		t.Error("expected the health check to fail without transmission")
+	}
+}
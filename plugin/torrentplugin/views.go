@@ -1,41 +1,182 @@
 package torrentplugin
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
-	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/nielsvanm/homemanager/storage"
 	"github.com/nielsvanm/homemanager/tools"
 
 	"github.com/lnguyen/go-transmission/transmission"
+	"github.com/nielsvanm/homemanager/auth"
 	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/metrics"
+	"github.com/nielsvanm/homemanager/middleware"
 	"github.com/nielsvanm/homemanager/tools/log"
 )
 
-var tmClient = transmission.New("http://localhost:9091", "", "")
-var TorrentFolder = "./__data/torrentplugin/torrents/"
+// dashboardLayout lists the torrents in transmission
+var dashboardLayout = frame.NewLayout("base.html", "plugins/torrentplugin/dashboard.html")
+
+var store = storage.Get("TorrentPlugin")
+
+// PermAddTorrent allows adding torrents to transmission
+const PermAddTorrent = "torrentplugin.add"
+
+// Permissions the endpoints of the plugin require
+var Permissions = []auth.Permission{
+	{
+		Name:        PermAddTorrent,
+		Description: "Add torrents to transmission",
+		Roles:       []string{auth.RoleMember},
+	},
+}
+
+// uploadLimiter allows a burst of 10 torrents and one more every 10 seconds
+var uploadLimiter = middleware.NewLimiter(0.1, 10)
 
 var APIEndpoints = []*frame.Endpoint{
-	frame.NewEndpoint("/add/", APIAddTorrentView),
+	// Torrent files are small, anything bigger is not a torrent
+	frame.NewEndpoint("/add/", frame.Handle(APIAddTorrentView)).
+		WithMethods(http.MethodPost).
+		WithPermission(PermAddTorrent).
+		WithMiddleware(middleware.RateLimit(uploadLimiter, auth.RateLimitKey), middleware.BodyLimit(2<<20)).
+		WithDoc("Add a torrent", "Uploads a .torrent file to transmission, which starts downloading it.").
+		WithMultipartRequest(frame.Schema{
+			"type":     "object",
+			"required": []string{"torrentfile"},
+			"properties": frame.Schema{
+				"torrentfile": frame.Schema{"type": "string", "format": "binary"},
+			},
+		}).
+		WithResponse(http.StatusCreated, frame.Schema{
+			"type": "object",
+			"properties": frame.Schema{
+				"status":      frame.Schema{"type": "integer"},
+				"status_text": frame.Schema{"type": "string"},
+				"name":        frame.Schema{"type": "string"},
+				"hash":        frame.Schema{"type": "string"},
+			},
+		}),
 }
 var ViewEndpoints = []*frame.Endpoint{
-	frame.NewEndpoint("/", DashboardView),
+	frame.NewEndpoint("/", DashboardView).WithMenu("Torrent Plugin", "fas fa-download"),
+}
+
+// Topics publish the progress of the torrents while the dashboard is open
+var Topics = []frame.Topic{
+	{Name: "torrents", Sample: sampleTorrents, Interval: 2 * time.Second},
+}
+
+// Metrics report the downloads in transmission
+var Metrics = []metrics.Metric{
+	metrics.NewGaugeFunc("active_torrents", "Number of torrents that are still downloading", countActiveTorrents),
+}
+
+// torrentSample is a torrent as it is shown on the dashboard
+type torrentSample struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Status      int     `json:"status"`
+	PercentDone float64 `json:"percent_done"`
+	Speed       string  `json:"speed"`
+	IsFinished  bool    `json:"is_finished"`
+}
+
+// Widgets List of widgets for the home dashboard
+var Widgets = []*frame.Widget{
+	frame.NewWidget("active", "Active Torrents", "plugins/torrentplugin/widget.html", ActiveTorrentsWidget),
 }
 
 func DashboardView(w http.ResponseWriter, r *http.Request) {
-	page := frame.NewPage([]string{"base.html", "plugins/torrentplugin/dashboard.html"})
+	page := dashboardLayout.NewPage()
 
-	torrents, err := tmClient.GetTorrents()
+	torrents, err := getTorrents(r.Context())
 	if err != nil {
-		log.Warn("TorrentPlugin", "Failed to get torrents from transmission", err.Error())
+		log.FromContext(r.Context()).Warn("TorrentPlugin", "Failed to get torrents from transmission", err.Error())
 
 		page.AddContext("error", "Failed to connect to transmission, is it online?")
-		page.Render(w)
+		page.Render(w, r)
 		return
 	}
 
+	page.AddContext("torrents", torrents)
+
+	page.Render(w, r)
+}
+
+// ActiveTorrentsWidget provides the torrents that are still downloading
+func ActiveTorrentsWidget(r *http.Request) (interface{}, error) {
+	torrents, err := getTorrents(r.Context())
+	if err != nil {
+		log.FromContext(r.Context()).Warn("TorrentPlugin", "Failed to get torrents from transmission", err.Error())
+		return nil, errors.New("Failed to connect to transmission, is it online?")
+	}
+
+	active := []transmission.Torrent{}
+	for _, torrent := range torrents {
+		if !torrent.IsFinished {
+			active = append(active, torrent)
+		}
+	}
+
+	return active, nil
+}
+
+// sampleTorrents returns the torrents in transmission
+func sampleTorrents(ctx context.Context) (interface{}, error) {
+	torrents, err := getTorrents(ctx)
+	if err != nil {
+		return nil, errors.New("Failed to connect to transmission, is it online?")
+	}
+
+	samples := []torrentSample{}
+	for _, torrent := range torrents {
+		samples = append(samples, torrentSample{
+			ID:          torrent.ID,
+			Name:        torrent.Name,
+			Status:      torrent.Status,
+			PercentDone: torrent.PercentDone,
+			Speed:       torrent.DownloadDir,
+			IsFinished:  torrent.IsFinished,
+		})
+	}
+
+	return samples, nil
+}
+
+// countActiveTorrents returns the amount of torrents that are not finished
+func countActiveTorrents(ctx context.Context) (float64, error) {
+	torrents, err := getTorrents(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	active := 0
+	for _, torrent := range torrents {
+		if !torrent.IsFinished {
+			active++
+		}
+	}
+
+	return float64(active), nil
+}
+
+// getTorrents retrieves the torrents from transmission and prepares them
+// for displaying
+func getTorrents(ctx context.Context) ([]transmission.Torrent, error) {
+	torrents, err := fetchTorrents(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	for i := range torrents {
 		// Fix percent done
 		torrents[i].PercentDone = torrents[i].PercentDone * 100
@@ -44,54 +185,68 @@ func DashboardView(w http.ResponseWriter, r *http.Request) {
 		torrents[i].DownloadDir = tools.ByteCountDecimal(int64(torrents[i].RateDownload))
 	}
 
-	page.AddContext("torrents", torrents)
-
-	page.Render(w)
+	return torrents, nil
 }
 
 // APIAddTorrentView allows an external program or plugin to send a torrent to
 // download
-func APIAddTorrentView(w http.ResponseWriter, r *http.Request) {
+func APIAddTorrentView(w http.ResponseWriter, r *http.Request) error {
 	file, handle, err := r.FormFile("torrentfile")
 	if err != nil {
-		log.Warn("TorrentPlugin", "Failed to retrieve file", err.Error())
-		return
+		return frame.BadRequest("Send the torrent as the torrentfile field of a multipart form", err)
 	}
 	defer file.Close()
 
 	// Add .torrent extension
-	if !strings.HasSuffix(handle.Filename, ".torrent") {
-		handle.Filename += ".torrent"
+	filename := filepath.Base(handle.Filename)
+	if !strings.HasSuffix(filename, ".torrent") {
+		filename += ".torrent"
 	}
+	filename = filepath.Join("torrents", filename)
 
 	// Create/open file and copy contents from received file into our file
-	f, err := os.OpenFile(TorrentFolder+handle.Filename, os.O_WRONLY|os.O_CREATE, 0666)
+	f, err := store.Create(filename)
 	if err != nil {
-		log.Warn("TorrentPlugin", "Failed to open or create file at", TorrentFolder+handle.Filename, err.Error())
-		return
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			return frame.NewHTTPError(http.StatusInsufficientStorage, "The storage quota of the plugin is used up", err)
+		}
+		return frame.InternalError(err)
 	}
 
 	_, err = io.Copy(f, file)
+	f.Close()
 	if err != nil {
-		log.Warn("TorrentPlugin", "Failed to copy data", err.Error())
+		store.Delete(filename)
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			return frame.NewHTTPError(http.StatusInsufficientStorage, "The storage quota of the plugin is used up", err)
+		}
+		return frame.InternalError(err)
 	}
-	f.Close()
 
 	// Add torrent transmission
-	ta, err := tmClient.AddTorrentByFilename(TorrentFolder+handle.Filename, "./__data/torrentplugin/downloads/")
+	torrentPath, _ := store.Path(filename)
+	downloadPath, _ := store.Path("downloads")
+	ta, err := addTorrent(r.Context(), torrentPath, downloadPath)
 	if err != nil {
-		log.Warn("TorrentPlugin", "Failed to add torrent", err.Error())
-		return
+		return frame.NewHTTPError(http.StatusBadGateway, "Transmission did not accept the torrent", err)
 	}
 
-	log.Info("TorrentPlugin", ta.Name, string(ta.ID), ta.HashString)
+	log.FromContext(r.Context()).Info("TorrentPlugin", ta.Name, strconv.Itoa(ta.ID), ta.HashString)
 
 	// Respond
-	resp, err := json.Marshal(`{"status": 200, "status_text": "Torrent Succesfully added"}`)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	// The status is sent already, a failed write can only be logged
+	err = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      http.StatusCreated,
+		"status_text": "Torrent succesfully added",
+		"name":        ta.Name,
+		"hash":        ta.HashString,
+	})
 	if err != nil {
-		log.Warn("TorrentPlugin", "Failed to Marshal json response")
-		return
+		log.FromContext(r.Context()).Warn("TorrentPlugin", "Failed to write response", err.Error())
 	}
 
-	w.Write(resp)
+	return nil
 }
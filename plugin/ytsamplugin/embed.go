@@ -0,0 +1,18 @@
+package ytsamplugin
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+//go:embed static
+var staticFiles embed.FS
+
+// Templates are the html templates of the plugin
+var Templates, _ = fs.Sub(templateFiles, "templates")
+
+// Static are the static assets of the plugin
+var Static, _ = fs.Sub(staticFiles, "static")
@@ -1,11 +1,13 @@
 package ytsamplugin
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/nielsvanm/homemanager/database"
 	"github.com/nielsvanm/homemanager/tools/log"
@@ -13,7 +15,6 @@ import (
 
 /* TODO:
 - Update create queries to break upon duplicates
-- Add iterative loop to GetMovies to actually get all the movies
 - Create Views
 */
 
@@ -23,8 +24,9 @@ var BaseURL = "https://yts.am/api/v2/"
 // RequestLimit is the max amount of movies we should request
 var RequestLimit = 50
 
-var totalMovieCount = 0
-var currentPage = 1
+// Client is the http client used to talk to the api, the timeout makes sure a
+// single hanging request can't block the plugin forever
+var Client = &http.Client{Timeout: 30 * time.Second}
 
 type ResponseData struct {
 	Status        string           `json:"status,omitempty"`
@@ -38,8 +40,49 @@ type ResponseSettings struct {
 	Movies     []Movie `json:"movies,omitempty"`
 }
 
-// GetMovies is the "main" function of the plugin
-func GetMovies() []database.BatchQuery {
+// StreamMovies is the "main" function of the plugin, it commits the movies
+// page by page with the next page as checkpoint so an interrupted crawl
+// resumes where it stopped
+func StreamMovies(ctx context.Context, checkpoint string, sink database.Sink) error {
+	page := 1
+	if checkpoint != "" {
+		var err error
+		page, err = strconv.Atoi(checkpoint)
+		if err != nil {
+			log.Warn("YTSAMPlugin", "Ignoring invalid checkpoint", checkpoint)
+			page = 1
+		}
+	}
+
+	// Repeat request until we run out of movies
+	for {
+		if ctx.Err() != nil {
+			log.Warn("YTSAMPlugin", "Stopped requesting pages,", ctx.Err().Error())
+			return ctx.Err()
+		}
+
+		log.Info("YTSAMPlugin", "Requesting page "+strconv.Itoa(page))
+
+		resp := QueryYTS(ctx, page)
+		if resp == nil {
+			return fmt.Errorf("failed to request page %d", page)
+		}
+
+		if len(resp.Data.Movies) == 0 {
+			return nil
+		}
+
+		err := sink.Commit(ctx, strconv.Itoa(page+1), MovieBatches(resp.Data.Movies)...)
+		if err != nil {
+			return err
+		}
+		page++
+	}
+}
+
+// MovieBatches creates the queries that store the movies, their genres and
+// their torrents
+func MovieBatches(movies []Movie) []database.BatchQuery {
 	// Create Queries
 	genreBatch := database.BatchQuery{}
 	genreBatch.Query = `
@@ -71,50 +114,38 @@ func GetMovies() []database.BatchQuery {
 	))
 	ON CONFLICT DO NOTHING;`
 
-	// Repeat request until we run out of movies
-	for {
-		log.Info("YTSAMPlugin", "Requesting page "+strconv.Itoa(currentPage))
+	// Parse data to queries
+	for _, movie := range movies {
+		movieBatch.AddValues(
+			movie.Title,
+			movie.Year,
+			movie.Rating,
+			movie.Length,
+			movie.Description,
+			movie.CoverImage,
+		)
 
-		resp := QueryYTS(currentPage)
-		currentPage++
+		for _, genre := range movie.Genres {
+			genreBatch.AddValues(
+				genre,
+			)
 
-		if len(resp.Data.Movies) == 0 {
-			break
+			movieGenreBatch.AddValues(
+				movie.Title,
+				genre,
+			)
 		}
 
-		// Parse data to queries
-		for _, movie := range resp.Data.Movies {
-			movieBatch.AddValues(
+		for _, torrent := range movie.Torrents {
+			torrentBatch.AddValues(
+				torrent.Quality,
+				torrent.Type,
+				torrent.Size,
+				torrent.URL,
 				movie.Title,
-				movie.Year,
-				movie.Rating,
-				movie.Length,
-				movie.Description,
-				movie.CoverImage,
 			)
-
-			for _, genre := range movie.Genres {
-				genreBatch.AddValues(
-					genre,
-				)
-
-				movieGenreBatch.AddValues(
-					movie.Title,
-					genre,
-				)
-			}
-
-			for _, torrent := range movie.Torrents {
-				torrentBatch.AddValues(
-					torrent.Quality,
-					torrent.Type,
-					torrent.Size,
-					torrent.URL,
-					movie.Title,
-				)
-			}
-			log.Info("YTSAMPlugin", "Succesfully created queries for", movie.Title)
 		}
+		log.Info("YTSAMPlugin", "Succesfully created queries for", movie.Title)
 	}
 
 	// Create pluginresult
@@ -127,14 +158,21 @@ func GetMovies() []database.BatchQuery {
 }
 
 // QueryYTS queries the YTS.AM API at the provided page
-func QueryYTS(page int) *ResponseData {
+func QueryYTS(ctx context.Context, page int) *ResponseData {
 	templateURL := "%slist_movies.json?limit=%d&page=%d"
 	URL := fmt.Sprintf(templateURL, BaseURL, RequestLimit, page)
 
 	// Make the request and read the body
-	response, err := http.Get(URL)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, URL, nil)
 	if err != nil {
 		log.Warn("YTSAMPlugin", err.Error())
+		return nil
+	}
+
+	response, err := Client.Do(request)
+	if err != nil {
+		log.Warn("YTSAMPlugin", err.Error())
+		return nil
 	}
 
 	defer response.Body.Close()
@@ -1,10 +1,17 @@
 package ytsamplugin
 
 import (
+	"context"
+	"errors"
+
 	"github.com/nielsvanm/homemanager/database"
 	"github.com/nielsvanm/homemanager/tools/log"
 )
 
+// ErrLoadMovies is returned when the movies could not be read from the
+// database, the cause is logged by the database package
+var ErrLoadMovies = errors.New("failed to load the movies")
+
 // Movie is a representation of the movie data
 type Movie struct {
 	ID          int       `json:"id,omitempty"`
@@ -64,16 +71,66 @@ func GetAllMovies(limit, offset int, downloaded bool) []Movie {
 	return movieList
 }
 
-func GetSingleMovie(id int) Movie {
+// CountMovies returns the amount of movies in the database
+func CountMovies(ctx context.Context) (int, error) {
+	var count int
+	err := database.Database.QueryRowContext(ctx, `
+	SELECT COUNT(*)
+	FROM ytsamplugin_movie;`).Scan(&count)
+
+	return count, err
+}
+
+// GetRecentMovies returns the movies that were added to the database last
+func GetRecentMovies(limit int) ([]Movie, error) {
+	movieRows := database.Database.Query(`
+	SELECT id, title, cover_image, year, rating, length
+	FROM ytsamplugin_movie
+	ORDER BY id DESC
+	LIMIT $1;`, limit)
+	if movieRows == nil {
+		return nil, ErrLoadMovies
+	}
+	defer movieRows.Close()
+
+	movieList := []Movie{}
+
+	for movieRows.Next() {
+		var newMovie = Movie{}
+		err := movieRows.Scan(
+			&newMovie.ID,
+			&newMovie.Title,
+			&newMovie.CoverImage,
+			&newMovie.Year,
+			&newMovie.Rating,
+			&newMovie.Length,
+		)
+		if err != nil {
+			log.Warn("YTSAMPlugin", "Failed to scan movie row", err.Error())
+			continue
+		}
+
+		movieList = append(movieList, newMovie)
+	}
+
+	return movieList, movieRows.Err()
+}
+
+// GetSingleMovie returns the movie with the id or nil when it does not exist
+func GetSingleMovie(id int) *Movie {
 	rows := database.Database.Query(`
 	SELECT id, title, year, rating, length, description, cover_image
 	FROM ytsamplugin_movie
 	WHERE id = $1`, id)
+	if rows == nil {
+		return nil
+	}
+	defer rows.Close()
 
 	for rows.Next() {
 		movie := Movie{}
 
-		rows.Scan(
+		err := rows.Scan(
 			&movie.ID,
 			&movie.Title,
 			&movie.Year,
@@ -82,9 +139,13 @@ func GetSingleMovie(id int) Movie {
 			&movie.Description,
 			&movie.CoverImage,
 		)
-		return movie
+		if err != nil {
+			log.Warn("YTSAMPlugin", "Failed to parse movie row", err.Error())
+			return nil
+		}
+		return &movie
 	}
-	return Movie{}
+	return nil
 }
 
 // GetUniqueYears returns a list of years that we have movies for
@@ -239,6 +300,10 @@ func GetTorrentByID(torrentID int) *Torrent {
 	torrentRows := database.Database.Query(`
 	SELECT id, quality, type, size, url FROM ytsamplugin_torrent
 	WHERE id = $1`, torrentID)
+	if torrentRows == nil {
+		return nil
+	}
+	defer torrentRows.Close()
 
 	for torrentRows.Next() {
 		torrent := Torrent{}
@@ -253,6 +318,7 @@ func GetTorrentByID(torrentID int) *Torrent {
 
 		if err != nil {
 			log.Warn("YTSAMPlugin", "Failed to parse torrent row")
+			return nil
 		}
 
 		return &torrent
@@ -1,37 +1,78 @@
 package ytsamplugin
 
 import (
+	"context"
+	"io"
 	"net/http"
-	"os/exec"
+	"path"
 	"strconv"
 	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/nielsvanm/homemanager/auth"
 	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/metrics"
+	"github.com/nielsvanm/homemanager/storage"
+	"github.com/nielsvanm/homemanager/tools/log"
 )
 
+// Layouts of the plugin pages, the movie overview is a fragment that is
+// loaded into the dashboard
+var (
+	dashboardLayout     = frame.NewLayout("base.html", "plugins/ytsamplugin/dashboard.html")
+	movieOverviewLayout = frame.NewLayout("plugins/ytsamplugin/movieoverview.html")
+	movieLayout         = frame.NewLayout("base.html", "plugins/ytsamplugin/movieview.html")
+)
+
+var store = storage.Get("YTSAMPlugin")
+
+// PermDownload allows downloading the torrent files of movies
+const PermDownload = "ytsamplugin.download"
+
+// Permissions the endpoints of the plugin require
+var Permissions = []auth.Permission{
+	{
+		Name:        PermDownload,
+		Description: "Download the torrent files of movies",
+		Roles:       []string{auth.RoleMember},
+	},
+}
+
 // APIEndpoints List of endpoints for the API
 var APIEndpoints = []*frame.Endpoint{}
 
 // ViewEndpoints List of endpoints for the webapp
 var ViewEndpoints = []*frame.Endpoint{
-	frame.NewEndpoint("/", DashboardView),
+	frame.NewEndpoint("/", DashboardView).WithMenu("YTSAM Plugin", "fas fa-film"),
 	frame.NewEndpoint("/movie/", MovieOverviewView),
 	frame.NewEndpoint("/search/title/", TitleSearch),
-	frame.NewEndpoint("/view/{movieid}/", MovieView),
-	frame.NewEndpoint("/torrent/{torrentid}/", DownloadTorrentView),
+	frame.NewEndpoint("/view/{movieid}/", frame.Handle(MovieView)),
+	frame.NewEndpoint("/torrent/{torrentid}/", frame.Handle(DownloadTorrentView)).WithMethods(http.MethodPost).WithPermission(PermDownload),
+}
+
+// Widgets List of widgets for the home dashboard
+var Widgets = []*frame.Widget{
+	frame.NewWidget("recent", "Recently Added Movies", "plugins/ytsamplugin/widget.html", RecentMoviesWidget),
+}
+
+// Metrics report the size of the catalog
+var Metrics = []metrics.Metric{
+	metrics.NewGaugeFunc("catalog_movies", "Number of movies in the catalog", func(ctx context.Context) (float64, error) {
+		count, err := CountMovies(ctx)
+		return float64(count), err
+	}),
 }
 
 // DashboardView renders the dashboard template
 func DashboardView(w http.ResponseWriter, r *http.Request) {
-	dashTemplate := frame.NewPage([]string{"base.html", "plugins/ytsamplugin/dashboard.html"})
+	dashTemplate := dashboardLayout.NewPage()
 
-	dashTemplate.Render(w)
+	dashTemplate.Render(w, r)
 }
 
 // MovieOverviewView renders the movies for the dashboard
 func MovieOverviewView(w http.ResponseWriter, r *http.Request) {
-	movieTemplate := frame.NewPage([]string{"plugins/ytsamplugin/movieoverview.html"})
+	movieTemplate := movieOverviewLayout.NewPage()
 
 	getDownloaded := false
 	getDownloaded, err := strconv.ParseBool(r.URL.Query().Get("download"))
@@ -64,11 +105,11 @@ func MovieOverviewView(w http.ResponseWriter, r *http.Request) {
 	// pageCount := GetPageCount(int(page), pageSize, getDownloaded)
 	// movieTemplate.AddContext("pages", pageCount)
 
-	movieTemplate.Render(w)
+	movieTemplate.Render(w, r)
 }
 
 func TitleSearch(w http.ResponseWriter, r *http.Request) {
-	movieTemplate := frame.NewPage([]string{"plugins/ytsamplugin/movieoverview.html"})
+	movieTemplate := movieOverviewLayout.NewPage()
 
 	title := r.URL.Query().Get("title")
 	if title == "" {
@@ -82,15 +123,21 @@ func TitleSearch(w http.ResponseWriter, r *http.Request) {
 		"movies", movies,
 	)
 
-	movieTemplate.Render(w)
+	movieTemplate.Render(w, r)
 }
 
-func MovieView(w http.ResponseWriter, r *http.Request) {
-	page := frame.NewPage([]string{"base.html", "plugins/ytsamplugin/movieview.html"})
+func MovieView(w http.ResponseWriter, r *http.Request) error {
+	page := movieLayout.NewPage()
 
-	movieID, _ := strconv.Atoi(mux.Vars(r)["movieid"])
+	movieID, err := strconv.Atoi(mux.Vars(r)["movieid"])
+	if err != nil {
+		return frame.BadRequest("The movie id should be a number", err)
+	}
 
 	movie := GetSingleMovie(movieID)
+	if movie == nil {
+		return frame.NotFound("There is no movie with id " + strconv.Itoa(movieID))
+	}
 	page.AddContext("movie", movie)
 
 	genres := GetGenreByMovie(movie.ID)
@@ -99,18 +146,54 @@ func MovieView(w http.ResponseWriter, r *http.Request) {
 
 	torrents := GetTorrentsByMovie(movie.ID)
 	page.AddContext("torrents", torrents)
+	page.AddContext("candownload", frame.IsAuthorized(r, PermDownload))
+
+	page.Render(w, r)
+	return nil
+}
 
-	page.Render(w)
+// RecentMoviesWidget provides the movies that were added last
+func RecentMoviesWidget(r *http.Request) (interface{}, error) {
+	return GetRecentMovies(6)
 }
 
 // DownloadTorrentView downloads a torrent in the background
-func DownloadTorrentView(w http.ResponseWriter, r *http.Request) {
-	torrentID, _ := strconv.Atoi(mux.Vars(r)["torrentid"])
+func DownloadTorrentView(w http.ResponseWriter, r *http.Request) error {
+	torrentID, err := strconv.Atoi(mux.Vars(r)["torrentid"])
+	if err != nil {
+		return frame.BadRequest("The torrent id should be a number", err)
+	}
 
 	torrent := GetTorrentByID(torrentID)
+	if torrent == nil {
+		return frame.NotFound("There is no torrent with id " + strconv.Itoa(torrentID))
+	}
+
+	go downloadTorrentFile(torrent.URL)
 
-	torrentDir := "./__data/ytsamplugin/torrents/"
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
 
-	tmp := exec.Command("wget", "--directory-prefix="+torrentDir, torrent.URL)
-	tmp.Start()
+// downloadTorrentFile stores the torrent file at the url in the torrents
+// folder of the plugin
+func downloadTorrentFile(url string) {
+	response, err := Client.Get(url)
+	if err != nil {
+		log.Warn("YTSAMPlugin", "Failed to download torrent", err.Error())
+		return
+	}
+	defer response.Body.Close()
+
+	f, err := store.Create("torrents/" + path.Base(response.Request.URL.Path))
+	if err != nil {
+		log.Warn("YTSAMPlugin", "Failed to create torrent file", err.Error())
+		return
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, response.Body)
+	if err != nil {
+		log.Warn("YTSAMPlugin", "Failed to save torrent", err.Error())
+	}
 }
@@ -0,0 +1,240 @@
+package ytsamplugin_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nielsvanm/homemanager/auth"
+	"github.com/nielsvanm/homemanager/plugin"
+	"github.com/nielsvanm/homemanager/plugin/plugintest"
+)
+
+const listURL = "https://yts.am/api/v2/list_movies.json?limit=50&page="
+
+const firstPage = `{
+	"status": "ok",
+	"data": {
+		"movie_count": 3,
+		"page_number": 1,
+		"movies": [
+			{
+				"title": "The Matrix",
+				"year": 1999,
+				"rating": 8.7,
+				"runtime": 136,
+				"description_full": "A hacker learns the truth.",
+				"large_cover_image": "https://yts.am/matrix.jpg",
+				"genres": ["Action", "Sci-Fi"],
+				"torrents": [
+					{"quality": "720p", "type": "bluray", "size": "700 MB", "url": "https://yts.am/torrent/matrix720"},
+					{"quality": "1080p", "type": "bluray", "size": "1.6 GB", "url": "https://yts.am/torrent/matrix1080"}
+				]
+			},
+			{
+				"title": "Up",
+				"year": 2009,
+				"rating": 8.2,
+				"runtime": 96,
+				"genres": ["Animation"]
+			}
+		]
+	}
+}`
+
+const secondPage = `{
+	"status": "ok",
+	"data": {
+		"movie_count": 3,
+		"page_number": 2,
+		"movies": [
+			{"title": "Heat", "year": 1995, "rating": 8.3, "runtime": 170, "genres": ["Crime"]}
+		]
+	}
+}`
+
+const emptyPage = `{"status": "ok", "data": {"movie_count": 3, "page_number": 3}}`
+
+func setup(t *testing.T) *plugintest.Harness {
+	return plugintest.New(t, plugin.PluginManager.GetPlugin("YTSAMPlugin"))
+}
+
+func TestStreamCommitsEveryPage(t *testing.T) {
+	h := setup(t)
+	h.HTTP.Handle(listURL+"1", http.StatusOK, firstPage)
+	h.HTTP.Handle(listURL+"2", http.StatusOK, secondPage)
+	h.HTTP.Handle(listURL+"3", http.StatusOK, emptyPage)
+
+	sink, err := h.Stream(context.Background(), "")
+	if err != nil {
+		t.Fatalf("expected the stream to finish, got %v", err)
+	}
+
+	if len(sink.Commits) != 2 {
+		t.Fatalf("expected a commit per page with movies, got %d", len(sink.Commits))
+	}
+	if sink.Checkpoint() != "3" {
+		t.Errorf("expected the checkpoint to be the next page, got %q", sink.Checkpoint())
+	}
+
+	first := sink.Commits[0].Batches
+	movies := plugintest.AssertBatch(t, first, "INSERT INTO ytsamplugin_movie (title", 2)
+	plugintest.AssertBatchRow(t, movies, 0, "The Matrix", 1999, float32(8.7), 136, "A hacker learns the truth.", "https://yts.am/matrix.jpg")
+	plugintest.AssertBatch(t, first, "INSERT INTO ytsamplugin_genre", 3)
+	plugintest.AssertBatch(t, first, "INSERT INTO ytsamplugin_movie_genre", 3)
+	torrents := plugintest.AssertBatch(t, first, "INSERT INTO ytsamplugin_torrent", 2)
+	plugintest.AssertBatchRow(t, torrents, 1, "1080p", "bluray", "1.6 GB", "https://yts.am/torrent/matrix1080", "The Matrix")
+
+	movies = plugintest.AssertBatch(t, sink.Commits[1].Batches, "INSERT INTO ytsamplugin_movie (title", 1)
+	plugintest.AssertBatchRow(t, movies, 0, "Heat", 1995, float32(8.3), 170, "", "")
+}
+
+func TestStreamResumesFromCheckpoint(t *testing.T) {
+	h := setup(t)
+	h.HTTP.Handle(listURL+"2", http.StatusOK, secondPage)
+	h.HTTP.Handle(listURL+"3", http.StatusOK, emptyPage)
+
+	sink, err := h.Stream(context.Background(), "2")
+	if err != nil {
+		t.Fatalf("expected the stream to finish, got %v", err)
+	}
+
+	if len(sink.Commits) != 1 || sink.Checkpoint() != "3" {
+		t.Errorf("expected only the second page to be committed, got %d commits up to %q", len(sink.Commits), sink.Checkpoint())
+	}
+	for _, r := range h.HTTP.Requests() {
+		if strings.HasSuffix(r.URL.String(), "page=1") {
+			t.Error("expected the pages before the checkpoint to be skipped")
+		}
+	}
+}
+
+func TestStreamFailsOnAPIErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"status not ok", http.StatusOK, `{"status": "error", "status_message": "Rate limited"}`},
+		{"invalid json", http.StatusBadGateway, "<html>Bad gateway</html>"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := setup(t)
+			h.HTTP.Handle(listURL+"1", http.StatusOK, firstPage)
+			h.HTTP.Handle(listURL+"2", test.status, test.body)
+
+			sink, err := h.Stream(context.Background(), "")
+			if err == nil {
+				t.Fatal("expected the stream to fail")
+			}
+			if sink.Checkpoint() != "2" {
+				t.Errorf("expected the failed page to be the checkpoint, got %q", sink.Checkpoint())
+			}
+		})
+	}
+}
+
+func TestStreamStopsWhenCancelled(t *testing.T) {
+	h := setup(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	sink, err := h.Stream(ctx, "")
+	if err != context.Canceled {
+		t.Errorf("expected the stream to be cancelled, got %v", err)
+	}
+	if len(sink.Commits) != 0 || len(h.HTTP.Requests()) != 0 {
+		t.Error("expected no pages to be requested")
+	}
+}
+
+func TestMovieView(t *testing.T) {
+	h := setup(t)
+	h.FakeDB.HandleQuery("FROM ytsamplugin_movie WHERE id = $1",
+		[]string{"id", "title", "year", "rating", "length", "description", "cover_image"},
+		[]interface{}{7, "The Matrix", 1999, 8.7, 136, "A hacker learns the truth.", "https://yts.am/matrix.jpg"},
+	)
+	h.FakeDB.HandleQuery("SELECT name FROM ytsamplugin_genre", []string{"name"},
+		[]interface{}{"Action"},
+		[]interface{}{"Sci-Fi"},
+	)
+	h.FakeDB.HandleQuery("FROM ytsamplugin_torrent WHERE movie = $1",
+		[]string{"id", "quality", "type", "size", "url"},
+		[]interface{}{3, "1080p", "bluray", "1.6 GB", "https://yts.am/torrent/matrix1080"},
+	)
+
+	plugintest.AssertPage(t, h.Get("/ytsamplugin/view/7/"),
+		"The Matrix", "A hacker learns the truth.", "Action/Sci-Fi", "Download 1080p", "/ytsamplugin/torrent/3/")
+
+	queries := h.FakeDB.Queries()
+	if len(queries) == 0 || queries[0].Args[0] != int64(7) {
+		t.Errorf("expected the movie to be looked up by id, got %v", queries)
+	}
+
+	h.Role = auth.RoleGuest
+	rec := h.Get("/ytsamplugin/view/7/")
+	if strings.Contains(rec.Body.String(), "/ytsamplugin/torrent/3/") {
+		t.Error("expected guests not to be offered the download")
+	}
+}
+
+func TestMovieViewErrors(t *testing.T) {
+	h := setup(t)
+
+	plugintest.AssertStatus(t, h.Get("/ytsamplugin/view/7/"), http.StatusNotFound)
+	plugintest.AssertStatus(t, h.Get("/ytsamplugin/view/seven/"), http.StatusBadRequest)
+}
+
+func TestDownloadTorrentErrors(t *testing.T) {
+	h := setup(t)
+
+	plugintest.AssertStatus(t, h.Request(http.MethodPost, "/ytsamplugin/torrent/3/", nil), http.StatusNotFound)
+	plugintest.AssertStatus(t, h.Request(http.MethodPost, "/ytsamplugin/torrent/three/", nil), http.StatusBadRequest)
+
+	h.Role = auth.RoleGuest
+	plugintest.AssertStatus(t, h.Request(http.MethodPost, "/ytsamplugin/torrent/3/", nil), http.StatusForbidden)
+	if len(h.FakeDB.Queries()) != 1 {
+		t.Error("expected guests to be refused before the torrent is looked up")
+	}
+}
+
+func TestCatalogMetric(t *testing.T) {
+	h := setup(t)
+	h.FakeDB.HandleQuery("SELECT COUNT(*) FROM ytsamplugin_movie", []string{"count"}, []interface{}{42})
+
+	samples := h.Plugin.Metrics[0].Collect(context.Background())
+	if len(samples) != 1 || samples[0].Value != 42 {
+		t.Errorf("expected the movie count, got %v", samples)
+	}
+}
+
+func TestRecentMoviesWidget(t *testing.T) {
+	h := setup(t)
+	widget := h.Plugin.Widgets[0]
+	h.FakeDB.HandleQuery("FROM ytsamplugin_movie ORDER BY id DESC",
+		[]string{"id", "title", "cover_image", "year", "rating", "length"},
+		[]interface{}{7, "Big Buck Bunny", "bunny.jpg", 2008, 6.5, 10},
+		[]interface{}{"broken", "Broken", "broken.jpg", 2000, 1.0, 90},
+	)
+
+	rec := httptest.NewRecorder()
+	widget.Render(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	plugintest.AssertPage(t, rec, "Big Buck Bunny", "/ytsamplugin/view/7/")
+	if strings.Count(rec.Body.String(), "<img") != 1 {
+		t.Errorf("expected the row that fails to scan to be skipped, got %s", rec.Body.String())
+	}
+
+	h.FakeDB.FailQuery("FROM ytsamplugin_movie ORDER BY id DESC", errors.New("connection refused"))
+	rec = httptest.NewRecorder()
+	widget.Render(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	plugintest.AssertStatus(t, rec, http.StatusBadGateway)
+	if strings.Contains(rec.Body.String(), "No movies have been added yet") {
+		t.Error("expected an error instead of an empty list")
+	}
+}
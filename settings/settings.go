@@ -0,0 +1,17 @@
+package settings
+
+// Setting types
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeBool   = "bool"
+)
+
+// Setting describes a single configurable option of a plugin
+type Setting struct {
+	Key         string
+	Label       string
+	Description string
+	Type        string
+	Default     string
+}
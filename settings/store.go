@@ -0,0 +1,96 @@
+package settings
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nielsvanm/homemanager/database"
+)
+
+// SetupDB creates the table that stores the values of the settings
+var SetupDB = []string{
+	`CREATE TABLE IF NOT EXISTS homemanager_setting (
+		owner TEXT NOT NULL,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (owner, key)
+	);`,
+}
+
+// Validate returns an error when the value doesn't fit the type of the
+// setting
+func (s Setting) Validate(value string) error {
+	switch s.Type {
+	case TypeInt:
+		_, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s should be a whole number", s.Key)
+		}
+	case TypeBool:
+		_, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s should be true or false", s.Key)
+		}
+	}
+
+	return nil
+}
+
+// Get returns the stored value of the setting of the owner, such as a
+// plugin, or its default when nothing is stored
+func Get(owner string, s Setting) string {
+	if database.Database == nil {
+		return s.Default
+	}
+
+	rows := database.Database.Query(`
+	SELECT value FROM homemanager_setting
+	WHERE owner = $1 AND key = $2;`, strings.ToLower(owner), s.Key)
+	if rows == nil {
+		return s.Default
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return s.Default
+	}
+
+	var value sql.NullString
+	if rows.Scan(&value) != nil || !value.Valid {
+		return s.Default
+	}
+
+	return value.String
+}
+
+// Set validates and stores the value of the setting of the owner
+func Set(owner string, s Setting, value string) error {
+	err := s.Validate(value)
+	if err != nil {
+		return err
+	}
+
+	if database.Database == nil {
+		return errors.New("there is no database to store the setting in")
+	}
+
+	return database.Database.Exec(`
+	INSERT INTO homemanager_setting (owner, key, value)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (owner, key) DO UPDATE SET value = EXCLUDED.value;`,
+		strings.ToLower(owner), s.Key, value)
+}
+
+// Reset removes the stored value so the default is used again
+func Reset(owner string, s Setting) error {
+	if database.Database == nil {
+		return errors.New("there is no database to store the setting in")
+	}
+
+	return database.Database.Exec(`
+	DELETE FROM homemanager_setting
+	WHERE owner = $1 AND key = $2;`, strings.ToLower(owner), s.Key)
+}
@@ -0,0 +1,355 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nielsvanm/homemanager/tools"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// Root is the folder where all plugin data is stored, typically injected
+// by the main.go file
+var Root string
+
+// DirPerm and FilePerm are the permissions used when creating plugin data
+const (
+	DirPerm  os.FileMode = 0750
+	FilePerm os.FileMode = 0640
+)
+
+var (
+	// ErrInvalidPath is returned when a path would escape the plugin folder
+	ErrInvalidPath = errors.New("path is outside of the plugin storage folder")
+
+	// ErrQuotaExceeded is returned when a write would exceed the hard quota
+	ErrQuotaExceeded = errors.New("plugin storage quota exceeded")
+)
+
+var registry = map[string]*Storage{}
+var registryLock sync.Mutex
+
+func init() {
+	Root = "./__data/"
+}
+
+// Storage is a folder on disk that belongs to a single plugin, every path
+// that is passed to it is resolved relative to that folder.
+type Storage struct {
+	Name string
+
+	// Quotas in bytes, a value of 0 disables the quota
+	SoftQuota int64
+	HardQuota int64
+
+	// Internal variables
+	lock        sync.Mutex
+	quotaLock   sync.Mutex
+	usage       int64
+	usageValid  bool
+	softWarning bool
+}
+
+// Get returns the storage of the plugin with the provided name, creating
+// it when it is requested for the first time
+func Get(name string) *Storage {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	key := strings.ToLower(name)
+	s, ok := registry[key]
+	if !ok {
+		s = &Storage{Name: key}
+		registry[key] = s
+	}
+
+	return s
+}
+
+// All returns every storage that has been requested, sorted by name
+func All() []*Storage {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	list := []*Storage{}
+	for _, s := range registry {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list
+}
+
+// Dir returns the root folder of the storage
+func (s *Storage) Dir() string {
+	return filepath.Join(Root, s.Name)
+}
+
+// Path resolves name inside the storage folder, it returns ErrInvalidPath
+// when the result would point outside of it. Symlinks inside the storage
+// could point anywhere, so a path that goes through one is refused as well
+func (s *Storage) Path(name string) (string, error) {
+	if filepath.IsAbs(name) || strings.Contains(name, "\x00") {
+		return "", ErrInvalidPath
+	}
+
+	root := s.Dir()
+	full := filepath.Join(root, name)
+
+	rel, err := filepath.Rel(root, full)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrInvalidPath
+	}
+	if rel == "." {
+		return full, nil
+	}
+
+	// The root itself may be a symlink, only what is below it is checked.
+	// Elements that don't exist yet can't be symlinks, neither can anything
+	// below them
+	current := root
+	for _, element := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, element)
+
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", ErrInvalidPath
+		}
+	}
+
+	return full, nil
+}
+
+// MkdirAll creates a folder, including its parents, inside the storage
+func (s *Storage) MkdirAll(name string) error {
+	path, err := s.Path(name)
+	if err != nil {
+		return err
+	}
+
+	return os.MkdirAll(path, DirPerm)
+}
+
+// Open opens a file in the storage for reading
+func (s *Storage) Open(name string) (*os.File, error) {
+	path, err := s.Path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(path)
+}
+
+// Create creates or truncates a file in the storage, the returned writer
+// refuses to write beyond the hard quota of the storage
+func (s *Storage) Create(name string) (io.WriteCloser, error) {
+	path, err := s.Path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.quotaLock.Lock()
+	defer s.quotaLock.Unlock()
+
+	// Load the usage so writes can be accounted for
+	if s.SoftQuota > 0 || s.HardQuota > 0 {
+		usage, err := s.Usage()
+		if err != nil {
+			return nil, err
+		}
+		if s.HardQuota > 0 && usage >= s.HardQuota {
+			log.Warn("Storage", s.Name, "refused to create", name+",", ErrQuotaExceeded.Error())
+			return nil, ErrQuotaExceeded
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), DirPerm)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove the size of the file we are about to truncate from the usage
+	if info, err := os.Lstat(path); err == nil {
+		s.addUsage(-info.Size())
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, FilePerm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &quotaWriter{f, s}, nil
+}
+
+// List returns the entries of a folder in the storage
+func (s *Storage) List(name string) ([]os.FileInfo, error) {
+	path, err := s.Path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadDir(path)
+}
+
+// Delete removes a file or folder, including its contents, from the storage
+func (s *Storage) Delete(name string) error {
+	path, err := s.Path(name)
+	if err != nil {
+		return err
+	}
+
+	if path == s.Dir() {
+		return ErrInvalidPath
+	}
+
+	err = os.RemoveAll(path)
+	s.invalidateUsage()
+
+	return err
+}
+
+// Usage returns the amount of bytes the storage is using on disk, symlinks
+// are not followed
+func (s *Storage) Usage() (int64, error) {
+	s.lock.Lock()
+	if s.usageValid {
+		defer s.lock.Unlock()
+		return s.usage, nil
+	}
+	s.lock.Unlock()
+
+	var total int64
+	err := filepath.WalkDir(s.Dir(), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.lock.Lock()
+	s.usage = total
+	s.usageValid = true
+	s.lock.Unlock()
+
+	return total, nil
+}
+
+// Refresh forgets the cached usage, it should be called when files are
+// changed outside of the storage api, for example by an external program
+func (s *Storage) Refresh() {
+	s.invalidateUsage()
+}
+
+// OverSoftQuota returns true when the usage is above the soft quota
+func (s *Storage) OverSoftQuota() bool {
+	usage, err := s.Usage()
+	return err == nil && s.SoftQuota > 0 && usage >= s.SoftQuota
+}
+
+// OverHardQuota returns true when the usage is above the hard quota
+func (s *Storage) OverHardQuota() bool {
+	usage, err := s.Usage()
+	return err == nil && s.HardQuota > 0 && usage >= s.HardQuota
+}
+
+// ReadableUsage returns the usage in the human readable form
+func (s *Storage) ReadableUsage() string {
+	usage, err := s.Usage()
+	if err != nil {
+		return "unknown"
+	}
+
+	return tools.ByteCountDecimal(usage)
+}
+
+func (s *Storage) invalidateUsage() {
+	s.lock.Lock()
+	s.usageValid = false
+	s.lock.Unlock()
+}
+
+// addUsage updates the cached usage and warns once when the soft quota
+// is crossed
+func (s *Storage) addUsage(n int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.usageValid {
+		return
+	}
+	s.usage += n
+
+	if s.SoftQuota > 0 && s.usage >= s.SoftQuota && !s.softWarning {
+		s.softWarning = true
+		log.Warn("Storage", s.Name, "is using", tools.ByteCountDecimal(s.usage), "which is above its soft quota of", tools.ByteCountDecimal(s.SoftQuota))
+	} else if s.usage < s.SoftQuota {
+		s.softWarning = false
+	}
+}
+
+// quotaWriter is a file that keeps the usage of its storage up to date and
+// stops writing when the hard quota is reached
+type quotaWriter struct {
+	file    *os.File
+	storage *Storage
+}
+
+// Write checks the quota, writes and accounts for the bytes while holding
+// the quota lock of the storage, so concurrent writers can't both pass the
+// check and exceed the quota together
+func (qw *quotaWriter) Write(b []byte) (int, error) {
+	s := qw.storage
+	s.quotaLock.Lock()
+	defer s.quotaLock.Unlock()
+
+	if s.HardQuota > 0 {
+		usage, err := s.Usage()
+		if err != nil {
+			return 0, err
+		}
+		if usage+int64(len(b)) > s.HardQuota {
+			log.Warn("Storage", s.Name, "stopped writing", qw.file.Name()+",", ErrQuotaExceeded.Error())
+			return 0, ErrQuotaExceeded
+		}
+	}
+
+	n, err := qw.file.Write(b)
+	s.addUsage(int64(n))
+
+	return n, err
+}
+
+func (qw *quotaWriter) Close() error {
+	return qw.file.Close()
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func newTestStorage(t *testing.T, hardQuota int64) *Storage {
+	previous := Root
+	Root = t.TempDir()
+	t.Cleanup(func() { Root = previous })
+
+	s := &Storage{Name: "test", HardQuota: hardQuota}
+	if err := os.MkdirAll(s.Dir(), DirPerm); err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestPath(t *testing.T) {
+	s := newTestStorage(t, 0)
+
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"file.txt", true},
+		{"dir/file.txt", true},
+		{"dir/../file.txt", true},
+		{"../file.txt", false},
+		{"dir/../../file.txt", false},
+		{"/etc/passwd", false},
+		{"file\x00.txt", false},
+	}
+
+	for _, test := range tests {
+		_, err := s.Path(test.name)
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("Path(%q): expected valid %v, got error %v", test.name, test.valid, err)
+		}
+	}
+}
+
+func TestConcurrentWritesStayWithinQuota(t *testing.T) {
+	s := newTestStorage(t, 1000)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			w, err := s.Create(filepath.Join("uploads", string(rune('a'+i))))
+			if err != nil {
+				return
+			}
+			defer w.Close()
+
+			io.Copy(w, bytes.NewReader(make([]byte, 300)))
+		}(i)
+	}
+	wg.Wait()
+
+	s.Refresh()
+	usage, err := s.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage > s.HardQuota {
+		t.Errorf("expected usage within the hard quota of %d, got %d", s.HardQuota, usage)
+	}
+}
+
+func TestUsageSkipsSymlinks(t *testing.T) {
+	s := newTestStorage(t, 0)
+
+	outside := filepath.Join(t.TempDir(), "big")
+	if err := os.WriteFile(outside, make([]byte, 5000), FilePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(s.Dir(), "link")); err != nil {
+		t.Skip("symlinks are not supported:", err)
+	}
+	if err := os.WriteFile(filepath.Join(s.Dir(), "file"), make([]byte, 10), FilePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := s.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != 10 {
+		t.Errorf("expected a usage of 10, got %d", usage)
+	}
+
+	_, err = s.Create("link")
+	if err != ErrInvalidPath {
+		t.Errorf("expected creating through a symlink to fail with ErrInvalidPath, got %v", err)
+	}
+}
+
+func TestSymlinkedDirectory(t *testing.T) {
+	s := newTestStorage(t, 0)
+
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), FilePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(s.Dir(), "torrents")); err != nil {
+		t.Skip("symlinks are not supported:", err)
+	}
+
+	if _, err := s.Path("torrents/secret"); err != ErrInvalidPath {
+		t.Errorf("expected a path through the symlink to be invalid, got %v", err)
+	}
+	if _, err := s.Open("torrents/secret"); err != ErrInvalidPath {
+		t.Errorf("expected opening through the symlink to fail with ErrInvalidPath, got %v", err)
+	}
+	if _, err := s.Create("torrents/new"); err != ErrInvalidPath {
+		t.Errorf("expected creating through the symlink to fail with ErrInvalidPath, got %v", err)
+	}
+	if _, err := s.List("torrents"); err != ErrInvalidPath {
+		t.Errorf("expected listing the symlink to fail with ErrInvalidPath, got %v", err)
+	}
+	if err := s.Delete("torrents/secret"); err != ErrInvalidPath {
+		t.Errorf("expected deleting through the symlink to fail with ErrInvalidPath, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(outside, "secret")); err != nil {
+		t.Errorf("expected the file outside of the storage to be left alone, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new")); !os.IsNotExist(err) {
+		t.Errorf("expected no file to be created outside of the storage, got %v", err)
+	}
+
+	// Paths that don't go through the symlink keep working
+	if _, err := s.Path("downloads/new/file"); err != nil {
+		t.Errorf("expected a path that doesn't exist yet to be valid, got %v", err)
+	}
+}
@@ -0,0 +1,45 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time, it is replaced by a fake clock in tests
+type Clock interface {
+	Now() time.Time
+}
+
+var current Clock = realClock{}
+var currentLock sync.RWMutex
+
+// Now returns the current time of the app's clock
+func Now() time.Time {
+	currentLock.RLock()
+	defer currentLock.RUnlock()
+
+	return current.Now()
+}
+
+// Since returns the time elapsed since t according to the app's clock
+func Since(t time.Time) time.Duration {
+	return Now().Sub(t)
+}
+
+// Set replaces the app's clock and returns the previous one
+func Set(c Clock) Clock {
+	currentLock.Lock()
+	defer currentLock.Unlock()
+
+	previous := current
+	current = c
+
+	return previous
+}
+
+// realClock is the clock of the system
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
@@ -1,9 +1,11 @@
 package log
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 )
 
 const (
@@ -22,6 +24,36 @@ const (
 	Fatality = "FATAL"
 )
 
+// File is where every logged message is stored
+var File = "log.txt"
+
+// Hook is a function that receives every message that is logged
+type Hook func(level, module, message string)
+
+var hooks = map[int]Hook{}
+var hooksLock sync.RWMutex
+var nextHookID = 0
+
+// AddHook registers a function that is called for every logged message, it
+// returns an id that can be used to remove the hook again
+func AddHook(hook Hook) int {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+
+	nextHookID++
+	hooks[nextHookID] = hook
+
+	return nextHookID
+}
+
+// RemoveHook removes the hook with the provided id
+func RemoveHook(id int) {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+
+	delete(hooks, id)
+}
+
 // Log is a global function for outputting information to stdout
 // and log.txt
 func Log(level, module string, message ...string) {
@@ -31,11 +63,24 @@ func Log(level, module string, message ...string) {
 
 	fmt.Println(outMessage)
 
+	// Hooks may log themselves, they are called without holding the lock so
+	// a waiting AddHook or RemoveHook can't deadlock them
+	hooksLock.RLock()
+	current := make([]Hook, 0, len(hooks))
+	for _, hook := range hooks {
+		current = append(current, hook)
+	}
+	hooksLock.RUnlock()
+
+	for _, hook := range current {
+		hook(level, module, compactMessage)
+	}
+
 	if level == Fatality {
 		panic(message)
 	}
 
-	f, err := os.OpenFile("log.txt", os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	f, err := os.OpenFile(File, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
 	if err != nil {
 		//REVIEW Should we panic or do something else when we can't store logs?
 		panic(err)
@@ -66,3 +111,47 @@ func Err(module string, message ...string) {
 func Fatal(module string, message ...string) {
 	Log(Fatality, module, message...)
 }
+
+// Logger logs messages with a prefix, such as the id of the request they
+// belong to
+type Logger struct {
+	Prefix string
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx that carries the prefix, messages logged
+// with FromContext(ctx) start with it
+func NewContext(ctx context.Context, prefix string) context.Context {
+	return context.WithValue(ctx, contextKey{}, prefix)
+}
+
+// FromContext returns a logger with the prefix of the context, the prefix is
+// empty when the context has none
+func FromContext(ctx context.Context) Logger {
+	prefix, _ := ctx.Value(contextKey{}).(string)
+	return Logger{prefix}
+}
+
+func (l Logger) prefixed(message []string) []string {
+	if l.Prefix == "" {
+		return message
+	}
+
+	return append([]string{"[" + l.Prefix + "]"}, message...)
+}
+
+// Info logs message at information level
+func (l Logger) Info(module string, message ...string) {
+	Log(Information, module, l.prefixed(message)...)
+}
+
+// Warn logs message at warning level
+func (l Logger) Warn(module string, message ...string) {
+	Log(Warning, module, l.prefixed(message)...)
+}
+
+// Err logs message at error level
+func (l Logger) Err(module string, message ...string) {
+	Log(Error, module, l.prefixed(message)...)
+}
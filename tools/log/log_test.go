@@ -0,0 +1,67 @@
+package log
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func useTempFile(t *testing.T) {
+	previous := File
+	File = filepath.Join(t.TempDir(), "log.txt")
+	t.Cleanup(func() { File = previous })
+}
+
+func TestHooksMayLogWhileHooksChange(t *testing.T) {
+	useTempFile(t)
+
+	entered := make(chan struct{})
+	proceed := make(chan struct{})
+	id := AddHook(func(level, module, message string) {
+		if module != "Test" {
+			return
+		}
+		close(entered)
+		<-proceed
+		Warn("Hook", "logged from a hook")
+	})
+	defer RemoveHook(id)
+
+	done := make(chan struct{})
+	go func() {
+		Info("Test", "message")
+		close(done)
+	}()
+
+	// A hook is added while the first one is running, then the first one
+	// logs again
+	<-entered
+	added := make(chan int)
+	go func() { added <- AddHook(func(level, module, message string) {}) }()
+	time.Sleep(10 * time.Millisecond)
+	close(proceed)
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("logging from a hook deadlocked")
+	}
+	RemoveHook(<-added)
+}
+
+func TestLoggerPrefix(t *testing.T) {
+	useTempFile(t)
+
+	var got string
+	id := AddHook(func(level, module, message string) {
+		if module == "Test" {
+			got = message
+		}
+	})
+	defer RemoveHook(id)
+
+	Logger{Prefix: "abc"}.Info("Test", "hello")
+	if got != "[abc] hello" {
+		t.Errorf("expected the message to start with the prefix, got %q", got)
+	}
+}
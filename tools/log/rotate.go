@@ -0,0 +1,114 @@
+package log
+
+import (
+	"os"
+	"strconv"
+	"sync"
+)
+
+// RotatingFile is a file that is appended to until it grows past MaxSize,
+// it is then renamed to Path.1 and a new file is started. Older files move
+// up to Path.2, Path.3 and so on, only MaxBackups of them are kept
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+
+	// Internal variables
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingFile returns a rotating file for the path, the file is opened
+// on the first write. A MaxSize of 0 or less never rotates
+func NewRotatingFile(path string, maxSize int64, maxBackups int) *RotatingFile {
+	return &RotatingFile{Path: path, MaxSize: maxSize, MaxBackups: maxBackups}
+}
+
+// Write appends b to the file, rotating it first when b doesn't fit
+func (f *RotatingFile) Write(b []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.file == nil {
+		err := f.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if f.MaxSize > 0 && f.size > 0 && f.size+int64(len(b)) > f.MaxSize {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+
+	return n, err
+}
+
+// Close closes the file, the next write opens it again
+func (f *RotatingFile) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+
+	return err
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.Path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+
+	return nil
+}
+
+// rotate shifts the backups up by one and starts a new file. The caller must
+// hold the lock
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return err
+	}
+
+	if f.MaxBackups > 0 {
+		os.Remove(f.backup(f.MaxBackups))
+		for i := f.MaxBackups - 1; i > 0; i-- {
+			os.Rename(f.backup(i), f.backup(i+1))
+		}
+		err = os.Rename(f.Path, f.backup(1))
+	} else {
+		err = os.Remove(f.Path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return f.open()
+}
+
+func (f *RotatingFile) backup(i int) string {
+	return f.Path + "." + strconv.Itoa(i)
+}
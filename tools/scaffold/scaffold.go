@@ -0,0 +1,241 @@
+package scaffold
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// registrationMarker is the line in plugins.go new plugins are added above
+const registrationMarker = "// New plugins are added above this line by the plugin generator"
+
+// appImport is the import path of the app's packages, the plugins are under
+// pluginImport
+const (
+	appImport    = "github.com/nielsvanm/homemanager/"
+	pluginImport = appImport + "plugin/"
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`)
+
+// PluginInfo is the data that is available to the scaffolding templates
+type PluginInfo struct {
+	Name     string
+	Package  string
+	Category string
+}
+
+// Run executes the plugin command, it is called with the arguments after
+// "plugin", e.g. new <name> --category X
+func Run(args []string) error {
+	if len(args) < 2 || args[0] != "new" || strings.HasPrefix(args[1], "-") {
+		return errors.New("usage: homemanager plugin new <name> --category <category> [--root <repository root>]")
+	}
+
+	flags := flag.NewFlagSet("plugin new", flag.ContinueOnError)
+	category := flags.String("category", "Other", "--category <category the plugin is shown in>")
+	root := flags.String("root", ".", "--root <folder that contains the plugin folder>")
+	err := flags.Parse(args[2:])
+	if err != nil {
+		return err
+	}
+
+	return NewPlugin(*root, args[1], *category)
+}
+
+// NewPlugin creates a compiling plugin package in root/plugin/ and registers
+// it in root/plugin/plugins.go
+func NewPlugin(root, name, category string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid plugin name %q, use letters and digits only", name)
+	}
+
+	info := PluginInfo{
+		Name:     name,
+		Package:  strings.ToLower(name),
+		Category: category,
+	}
+
+	err := checkPackage(root, info)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(root, "plugin", info.Package)
+	for path, content := range files {
+		path = strings.Replace(path, "PACKAGE", info.Package, -1)
+		err := writeFile(filepath.Join(dir, path), content, info)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = register(filepath.Join(root, "plugin", "plugins.go"), info)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Created", dir, "and registered", info.Name, "in plugin/plugins.go")
+	return nil
+}
+
+// checkPackage returns an error when the package of the plugin would not
+// compile, because its name is a keyword or is taken by a package of the app
+// or by a package the generated code or plugins.go imports
+func checkPackage(root string, info PluginInfo) error {
+	if token.IsKeyword(info.Package) || info.Package == "main" {
+		return fmt.Errorf("invalid plugin name %q, %s can't be the name of a package", info.Name, info.Package)
+	}
+
+	for _, dir := range []string{info.Package, filepath.Join("tools", info.Package), filepath.Join("plugin", info.Package)} {
+		dir = filepath.Join(root, dir)
+		if _, err := os.Stat(dir); err == nil {
+			return fmt.Errorf("invalid plugin name %q, %s already exists", info.Name, dir)
+		}
+	}
+
+	imported, err := importedPackages(root, info)
+	if err != nil {
+		return err
+	}
+	if imported[info.Package] {
+		return fmt.Errorf("invalid plugin name %q, the plugin imports a package called %s", info.Name, info.Package)
+	}
+
+	return nil
+}
+
+// importedPackages returns the names of the packages that are imported by
+// the generated go files and plugins.go, apart from the plugin itself
+func importedPackages(root string, info PluginInfo) (map[string]bool, error) {
+	sources := map[string][]byte{}
+	for name, content := range files {
+		if !strings.HasSuffix(name, ".go") {
+			continue
+		}
+
+		out, err := render(content, info)
+		if err != nil {
+			return nil, err
+		}
+		sources[name] = out
+	}
+
+	pluginsPath := filepath.Join(root, "plugin", "plugins.go")
+	content, err := ioutil.ReadFile(pluginsPath)
+	if err != nil {
+		return nil, err
+	}
+	sources[pluginsPath] = content
+
+	imported := map[string]bool{}
+	fset := token.NewFileSet()
+	for name, source := range sources {
+		f, err := parser.ParseFile(fset, name, source, parser.ImportsOnly)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %s", name, err.Error())
+		}
+
+		for _, spec := range f.Imports {
+			importPath, _ := strconv.Unquote(spec.Path.Value)
+			if importPath == pluginImport+info.Package {
+				continue
+			}
+
+			if spec.Name != nil {
+				imported[spec.Name.Name] = true
+			} else {
+				imported[path.Base(importPath)] = true
+			}
+		}
+	}
+
+	return imported, nil
+}
+
+// writeFile renders the template to the path, go files are formatted
+func writeFile(path, content string, info PluginInfo) error {
+	out, err := render(content, info)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(path, ".go") {
+		out, err = format.Source(out)
+		if err != nil {
+			return fmt.Errorf("failed to format %s: %s", path, err.Error())
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0750)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, out, 0640)
+}
+
+// register adds the import and the plugin definition to plugins.go
+func register(path string, info PluginInfo) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	source := string(content)
+
+	if !strings.Contains(source, registrationMarker) {
+		return fmt.Errorf("can't find the registration marker in %s, add the plugin by hand", path)
+	}
+
+	// The import joins the other packages of the app, format.Source sorts it
+	// into place within that group
+	importLine := "\t\"" + pluginImport + info.Package + "\"\n"
+	if i := strings.Index(source, "\t\""+appImport); i >= 0 {
+		source = source[:i] + importLine + source[i:]
+	} else {
+		source = strings.Replace(source, "import (\n", "import (\n"+importLine+"\n", 1)
+	}
+
+	definition, err := render(registration, info)
+	if err != nil {
+		return err
+	}
+	source = strings.Replace(source, registrationMarker, string(definition)+registrationMarker, 1)
+
+	formatted, err := format.Source([]byte(source))
+	if err != nil {
+		return fmt.Errorf("failed to format %s: %s", path, err.Error())
+	}
+
+	return ioutil.WriteFile(path, formatted, stat.Mode().Perm())
+}
+
+// render executes a scaffolding template, they use [[ ]] as delimiters so
+// the html templates they produce can use {{ }}
+func render(content string, info PluginInfo) ([]byte, error) {
+	t, err := template.New("scaffold").Delims("[[", "]]").Parse(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, info)
+
+	return buf.Bytes(), err
+}
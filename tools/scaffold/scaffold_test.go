@@ -0,0 +1,92 @@
+package scaffold
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPlugins = `package plugin
+
+import (
+	"time"
+
+	"github.com/nielsvanm/homemanager/plugin/torrentplugin"
+)
+
+func init() {
+	PluginManager.Plugins = []*Plugin{
+		&Plugin{
+			Name:    "TorrentPlugin",
+			Main:    torrentplugin.UpdateTorrents,
+			Timeout: time.Minute,
+		},
+		// New plugins are added above this line by the plugin generator
+	}
+}
+`
+
+// newTestRoot returns a repository root with plugins.go and a storage package
+func newTestRoot(t *testing.T) string {
+	root := t.TempDir()
+	for _, dir := range []string{"plugin/torrentplugin", "storage", "tools/log"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "plugin", "plugins.go"), []byte(testPlugins), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return root
+}
+
+func TestNewPluginRejectsNames(t *testing.T) {
+	root := newTestRoot(t)
+
+	for _, name := range []string{"func", "Type", "main", "storage", "Log", "TorrentPlugin", "context", "time", "plugin", "3d", "my-plugin"} {
+		if err := NewPlugin(root, name, "Test"); err == nil {
+			t.Errorf("%s: expected the name to be rejected", name)
+		}
+		if _, err := os.Stat(filepath.Join(root, "plugin", strings.ToLower(name), "db.go")); err == nil {
+			t.Errorf("%s: expected nothing to be written", name)
+		}
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(root, "plugin", "plugins.go"))
+	if string(content) != testPlugins {
+		t.Errorf("expected plugins.go to be left alone, got\n%s", content)
+	}
+}
+
+func TestNewPluginRegisters(t *testing.T) {
+	root := newTestRoot(t)
+
+	if err := NewPlugin(root, "Demo", "Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(root, "plugin", "plugins.go")
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imports := "import (\n\t\"time\"\n\n\t\"github.com/nielsvanm/homemanager/plugin/demo\"\n\t\"github.com/nielsvanm/homemanager/plugin/torrentplugin\"\n)"
+	if !strings.Contains(string(content), imports) {
+		t.Errorf("expected the import in the group of the app's packages, got\n%s", content)
+	}
+	if !strings.Contains(string(content), "Main:          demo.Main,") {
+		t.Errorf("expected the plugin to be registered, got\n%s", content)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Mode().Perm() != 0644 {
+		t.Errorf("expected the mode of plugins.go to be kept, got %s", stat.Mode().Perm())
+	}
+}
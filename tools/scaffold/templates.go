@@ -0,0 +1,307 @@
+package scaffold
+
+import "strings"
+
+// backtick replaces ”' in the templates below, raw strings can't contain
+// backticks themselves
+var backtick = strings.NewReplacer("'''", "`")
+
+// files maps the path of every generated file, relative to the plugin
+// folder, to its template. PACKAGE in a path is replaced by the package name
+var files = map[string]string{
+	"db.go":                    backtick.Replace(dbTemplate),
+	"logic.go":                 backtick.Replace(logicTemplate),
+	"objects.go":               backtick.Replace(objectsTemplate),
+	"views.go":                 viewsTemplate,
+	"settings.go":              settingsTemplate,
+	"embed.go":                 embedTemplate,
+	"PACKAGE_test.go":          backtick.Replace(testTemplate),
+	"templates/dashboard.html": dashboardTemplate,
+	"static/css/PACKAGE.css":   cssTemplate,
+}
+
+// registration is the plugin definition that is added to plugins.go
+var registration = `&Plugin{
+	Name:          "[[.Name]]",
+	Description:   "TODO: Describe what [[.Name]] does",
+	Category:      "[[.Category]]",
+	SetupDatabase: [[.Package]].SetupDB,
+	Tables:        [[.Package]].Tables,
+	APIEndpoints:  [[.Package]].APIEndpoints,
+	ViewEndpoints: [[.Package]].ViewEndpoints,
+	Main:          [[.Package]].Main,
+	Settings:      [[.Package]].Settings,
+	Templates:     [[.Package]].Templates,
+	Static:        [[.Package]].Static,
+},
+`
+
+var dbTemplate = `package [[.Package]]
+
+// SetupDB creates the tables of the plugin, %s is replaced by the plugin name
+var SetupDB = []string{
+	'''CREATE TABLE IF NOT EXISTS %s_item (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		added TIMESTAMP NOT NULL DEFAULT NOW()
+	);''',
+}
+
+// Tables lists the tables of the plugin so they can be dropped
+var Tables = []string{
+	'''%s_item''',
+}
+`
+
+var logicTemplate = `package [[.Package]]
+
+import (
+	"context"
+
+	"github.com/nielsvanm/homemanager/database"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// Main is the "main" function of the plugin, it returns the queries that
+// store the results of a run
+func Main(ctx context.Context) []database.BatchQuery {
+	itemBatch := database.BatchQuery{}
+	itemBatch.Query = '''
+	INSERT INTO [[.Package]]_item (name)
+	VALUES ($1) ON CONFLICT DO NOTHING;'''
+
+	// TODO: Replace with the actual work of the plugin
+	for _, name := range []string{"first", "second"} {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		itemBatch.AddValues(name)
+		log.Info("[[.Name]]", "Created query for", name)
+	}
+
+	return []database.BatchQuery{
+		itemBatch,
+	}
+}
+`
+
+var objectsTemplate = `package [[.Package]]
+
+import (
+	"github.com/nielsvanm/homemanager/database"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// Item is a representation of the item data
+type Item struct {
+	ID   int    '''json:"id"'''
+	Name string '''json:"name"'''
+}
+
+// GetItems returns all the items in the database
+func GetItems() []Item {
+	rows := database.Database.Query('''
+	SELECT id, name FROM [[.Package]]_item
+	ORDER BY id;''')
+
+	items := []Item{}
+	if rows == nil {
+		return items
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		item := Item{}
+		err := rows.Scan(
+			&item.ID,
+			&item.Name,
+		)
+
+		if err != nil {
+			log.Warn("[[.Name]]", "Failed to scan item row")
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	return items
+}
+`
+
+var viewsTemplate = `package [[.Package]]
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nielsvanm/homemanager/frame"
+)
+
+// APIEndpoints List of endpoints for the API
+var APIEndpoints = []*frame.Endpoint{
+	frame.NewEndpoint("/items/", frame.Handle(APIItemsView)),
+}
+
+// ViewEndpoints List of endpoints for the webapp
+var ViewEndpoints = []*frame.Endpoint{
+	frame.NewEndpoint("/", DashboardView).WithMenu("[[.Name]]", "fas fa-puzzle-piece"),
+}
+
+// dashboardLayout is parsed when the app starts
+var dashboardLayout = frame.NewLayout("base.html", "plugins/[[.Package]]/dashboard.html")
+
+// DashboardView renders the dashboard template
+func DashboardView(w http.ResponseWriter, r *http.Request) {
+	page := dashboardLayout.NewPage()
+
+	page.AddContext("items", GetItems())
+
+	page.Render(w, r)
+}
+
+// APIItemsView returns the items as json
+func APIItemsView(w http.ResponseWriter, r *http.Request) error {
+	resp, err := json.Marshal(GetItems())
+	if err != nil {
+		return frame.InternalError(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+	return nil
+}
+`
+
+var settingsTemplate = `package [[.Package]]
+
+import "github.com/nielsvanm/homemanager/settings"
+
+// Settings describes the options the plugin can be configured with
+var Settings = []settings.Setting{
+	// TODO: Replace with the settings of the plugin
+	{
+		Key:         "example",
+		Label:       "Example",
+		Description: "An example setting",
+		Type:        settings.TypeString,
+		Default:     "",
+	},
+}
+`
+
+var embedTemplate = `package [[.Package]]
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+//go:embed static
+var staticFiles embed.FS
+
+// Templates are the html templates of the plugin
+var Templates, _ = fs.Sub(templateFiles, "templates")
+
+// Static are the static assets of the plugin
+var Static, _ = fs.Sub(staticFiles, "static")
+`
+
+var testTemplate = `package [[.Package]]_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nielsvanm/homemanager/plugin"
+	"github.com/nielsvanm/homemanager/plugin/plugintest"
+)
+
+func setup(t *testing.T) *plugintest.Harness {
+	return plugintest.New(t, plugin.PluginManager.GetPlugin("[[.Name]]"))
+}
+
+func TestMainReturnsBatches(t *testing.T) {
+	h := setup(t)
+
+	batches := h.Run(context.Background())
+
+	batch := plugintest.AssertBatch(t, batches, "INSERT INTO [[.Package]]_item", 2)
+	plugintest.AssertBatchRow(t, batch, 0, "first")
+}
+
+func TestDashboardView(t *testing.T) {
+	h := setup(t)
+
+	plugintest.AssertPage(t, h.Get("/[[.Package]]/"), "No items yet")
+}
+
+func TestAPIItemsView(t *testing.T) {
+	h := setup(t)
+	h.FakeDB.HandleQuery("SELECT id, name FROM [[.Package]]_item", []string{"id", "name"},
+		[]interface{}{1, "first"},
+		[]interface{}{2, "second"},
+	)
+
+	items := []map[string]interface{}{}
+	plugintest.AssertJSON(t, h.Get("/api/[[.Package]]/items/"), &items)
+
+	if len(items) != 2 || items[0]["name"] != "first" {
+		t.Errorf("expected the 2 items, got %v", items)
+	}
+}
+
+func TestCommittedItemsAreListed(t *testing.T) {
+	h := setup(t)
+	h.RequirePostgres()
+	h.Commit(h.Run(context.Background()))
+
+	items := []map[string]interface{}{}
+	plugintest.AssertJSON(t, h.Get("/api/[[.Package]]/items/"), &items)
+
+	if len(items) != 2 {
+		t.Errorf("expected 2 items, got %d", len(items))
+	}
+}
+`
+
+var dashboardTemplate = `{{ define "custom_css" }}
+<link rel="stylesheet" href="{{ asset "/static/plugins/[[.Package]]/css/[[.Package]].css" }}">
+{{ end }}
+
+{{ define "content" }}
+<div class="container-fluid [[.Package]]">
+    <div class="row">
+        <table class="table">
+            <thead>
+                <tr>
+                    <th>ID</th>
+                    <th>Name</th>
+                </tr>
+            </thead>
+            <tbody>
+                {{ range .items }}
+                <tr>
+                    <td>{{ .ID }}</td>
+                    <td>{{ .Name }}</td>
+                </tr>
+                {{ else }}
+                <tr>
+                    <td colspan="2">No items yet, run the plugin to create them.</td>
+                </tr>
+                {{ end }}
+            </tbody>
+        </table>
+    </div>
+</div>
+{{ end }}
+`
+
+var cssTemplate = `.[[.Package]] table {
+    margin-top: 1em;
+}
+`
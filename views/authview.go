@@ -0,0 +1,144 @@
+package views
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/nielsvanm/homemanager/auth"
+	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/middleware"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// Layouts of the pages, they are parsed once when the app starts
+var (
+	loginLayout = frame.NewLayout("auth/layout.html", "auth/login.html")
+	setupLayout = frame.NewLayout("auth/layout.html", "auth/setup.html")
+)
+
+var (
+	errMissingUsername  = errors.New("a username is required")
+	errPasswordMismatch = errors.New("the passwords don't match")
+)
+
+// CurrentUser returns the user that is logged in for the request, it is used
+// as the frame.UserProvider
+func CurrentUser(r *http.Request) interface{} {
+	user := auth.UserFromRequest(r)
+	if user == nil {
+		return nil
+	}
+
+	return user
+}
+
+// LoginView shows the login form and logs the user in when it is submitted
+func LoginView(w http.ResponseWriter, r *http.Request) {
+	next := auth.SafeRedirect(r.FormValue("next"), "/")
+
+	if auth.CountUsers() == 0 {
+		middleware.Redirect(w, r, auth.SetupURL, http.StatusSeeOther)
+		return
+	}
+
+	page := loginLayout.NewPage()
+	page.AddContext("next", next)
+
+	if r.Method == http.MethodPost {
+		username := r.PostFormValue("username")
+		ip := middleware.ClientIP(r)
+
+		// Don't even check the password while locked out, the answer would
+		// still help guessing it
+		if wait := auth.LoginLockout(username, ip); wait > 0 {
+			minutes := int(math.Ceil(wait.Minutes()))
+			log.FromContext(r.Context()).Warn("Auth", "Locked out login for "+username+" from "+ip)
+
+			page.AddContext("username", username)
+			page.AddContext("error", "Too many failed logins, try again in "+strconv.Itoa(minutes)+" minutes")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			page.Render(w, r)
+			return
+		}
+
+		user, err := auth.Authenticate(username, r.PostFormValue("password"))
+		if err == nil {
+			auth.LoginSucceeded(username, ip)
+			err = auth.Login(w, r, user)
+			if err == nil {
+				middleware.Redirect(w, r, next, http.StatusSeeOther)
+				return
+			}
+			log.FromContext(r.Context()).Warn("Auth", "Failed to create session", err.Error())
+		} else {
+			auth.LoginFailed(username, ip)
+			log.FromContext(r.Context()).Warn("Auth", "Failed login for "+username+" from "+ip)
+		}
+
+		page.AddContext("username", username)
+		page.AddContext("error", err.Error())
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+
+	page.Render(w, r)
+}
+
+// LogoutView ends the session of the user
+func LogoutView(w http.ResponseWriter, r *http.Request) {
+	auth.Logout(w, r)
+	middleware.Redirect(w, r, auth.LoginURL, http.StatusSeeOther)
+}
+
+// SetupView creates the first account, it is only available as long as no
+// accounts exist
+func SetupView(w http.ResponseWriter, r *http.Request) {
+	if auth.CountUsers() != 0 {
+		middleware.Redirect(w, r, auth.LoginURL, http.StatusSeeOther)
+		return
+	}
+
+	page := setupLayout.NewPage()
+
+	if r.Method == http.MethodPost {
+		username := r.PostFormValue("username")
+		password := r.PostFormValue("password")
+
+		var user *auth.User
+		var err error
+		switch {
+		case username == "":
+			err = errMissingUsername
+		case password != r.PostFormValue("confirm"):
+			err = errPasswordMismatch
+		default:
+			user, err = auth.CreateFirstUser(username, password)
+		}
+
+		// Somebody else finished the setup in the meantime
+		if err == auth.ErrSetupDone {
+			middleware.Redirect(w, r, auth.LoginURL, http.StatusSeeOther)
+			return
+		}
+
+		if err == nil && user != nil {
+			err = auth.Login(w, r, user)
+			if err == nil {
+				middleware.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
+		}
+
+		page.AddContext("username", username)
+		if err != nil {
+			page.AddContext("error", err.Error())
+		} else {
+			page.AddContext("error", "Failed to create the account")
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	page.Render(w, r)
+}
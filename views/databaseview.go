@@ -7,46 +7,86 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/middleware"
 	"github.com/nielsvanm/homemanager/plugin"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// The table overview and the confirmation page of destructive actions
+var (
+	databaseLayout = frame.NewLayout("base.html", "database/dashboard.html")
+	confirmLayout  = frame.NewLayout("base.html", "database/confirm.html")
 )
 
 // DatabaseView is an overview and management page for the database tables
 func DatabaseView(w http.ResponseWriter, r *http.Request) {
-	dbPage := frame.NewPage([]string{"base.html", "database/dashboard.html"})
+	dbPage := databaseLayout.NewPage()
 
 	// Get all plugins and add it to context
 	plugins := plugin.PluginManager.Plugins
 	dbPage.AddContext("plugins", plugins)
 
-	dbPage.Render(w)
+	dbPage.Render(w, r)
 }
 
-func CreateTablesView(w http.ResponseWriter, r *http.Request) {
-	pluginName := mux.Vars(r)["pluginname"]
-
-	plug := plugin.PluginManager.GetPlugin(pluginName)
+// CreateTablesView creates the tables of a plugin, tables that already exist
+// are left alone
+func CreateTablesView(w http.ResponseWriter, r *http.Request) error {
+	plug := plugin.PluginManager.GetPlugin(mux.Vars(r)["pluginname"])
 	if plug == nil {
-		w.Write([]byte("Failed to find the plugin"))
-		return
+		return frame.NotFound("There is no plugin called " + mux.Vars(r)["pluginname"])
 	}
 
 	for _, query := range plug.SetupDatabase {
 		database.Database.Exec(query)
 	}
+	log.FromContext(r.Context()).Info("Database", "Created the tables of "+plug.Name)
+
+	middleware.Redirect(w, r, "/database/", http.StatusSeeOther)
+	return nil
 }
 
-func DropTablesView(w http.ResponseWriter, r *http.Request) {
-	pluginName := mux.Vars(r)["pluginname"]
+// TruncateTablesView asks for confirmation on GET and deletes all rows of the
+// plugin's tables on POST
+func TruncateTablesView(w http.ResponseWriter, r *http.Request) error {
+	return confirmTablesAction(w, r, "Truncate", "deletes every row in", (*plugin.Plugin).TruncateTables)
+}
 
-	plug := plugin.PluginManager.GetPlugin(pluginName)
+// DropTablesView asks for confirmation on GET and drops the plugin's tables
+// on POST
+func DropTablesView(w http.ResponseWriter, r *http.Request) error {
+	return confirmTablesAction(w, r, "Drop", "deletes", (*plugin.Plugin).DropTables)
+}
+
+// confirmTablesAction shows a confirmation page for a destructive action on
+// the tables of a plugin, the queries only run once the name of the plugin
+// has been typed in to confirm
+func confirmTablesAction(w http.ResponseWriter, r *http.Request, action, description string, queries func(*plugin.Plugin) []string) error {
+	plug := plugin.PluginManager.GetPlugin(mux.Vars(r)["pluginname"])
 	if plug == nil {
-		w.Write([]byte("Failed to find the plugin"))
-		return
+		return frame.NotFound("There is no plugin called " + mux.Vars(r)["pluginname"])
 	}
 
-	queries := plug.DropTables()
+	page := confirmLayout.NewPage()
+	page.AddContext("plugin", plug)
+	page.AddContext("action", action)
+	page.AddContext("description", description)
 
-	for _, query := range queries {
-		database.Database.Exec(query)
+	if r.Method == http.MethodPost {
+		if r.PostFormValue("confirm") == plug.Name {
+			for _, query := range queries(plug) {
+				database.Database.Exec(query)
+			}
+			log.FromContext(r.Context()).Warn("Database", action, "the tables of", plug.Name, "from", r.RemoteAddr)
+
+			middleware.Redirect(w, r, "/database/", http.StatusSeeOther)
+			return nil
+		}
+
+		page.AddContext("error", "Type "+plug.Name+" to confirm")
+		w.WriteHeader(http.StatusBadRequest)
 	}
+
+	page.Render(w, r)
+	return nil
 }
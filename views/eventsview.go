@@ -0,0 +1,34 @@
+package views
+
+import (
+	"time"
+
+	"github.com/nielsvanm/homemanager/auth"
+	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/tools/clock"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// LogTopic publishes every message that is logged as a "log" event
+const LogTopic = "logs"
+
+// logEvent is a logged message as it is published
+type logEvent struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Module  string    `json:"module"`
+	Message string    `json:"message"`
+}
+
+// RegisterTopics makes the topics of the core pages available on /events/
+// and starts publishing the log
+func RegisterTopics() {
+	frame.Events.RegisterTopic(StatsTopic)
+	frame.Events.RegisterTopic(frame.Topic{Name: LogTopic, Permission: auth.PermViewLogs})
+
+	log.AddHook(func(level, module, message string) {
+		if frame.Events.HasSubscribers(LogTopic) {
+			frame.Events.Publish(LogTopic, "log", logEvent{clock.Now(), level, module, message})
+		}
+	})
+}
@@ -3,12 +3,33 @@ package views
 import (
 	"net/http"
 
+	"github.com/gorilla/mux"
 	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/plugin"
 )
 
+// dashboardLayout is the home page with the widget grid
+var dashboardLayout = frame.NewLayout("base.html", "dashboard/dashboard.html")
+
 // DashboardView is the main index page of the site
 func DashboardView(w http.ResponseWriter, r *http.Request) {
-	dashboardPage := frame.NewPage([]string{"base.html", "dashboard/dashboard.html"})
+	dashboardPage := dashboardLayout.NewPage()
+
+	// The widgets load their own content once the page is shown
+	dashboardPage.AddContext("widgets", plugin.PluginManager.GetWidgets())
+
+	dashboardPage.Render(w, r)
+}
+
+// WidgetView renders a single dashboard widget
+func WidgetView(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+
+	widget := plugin.PluginManager.GetWidget(vars["pluginname"], vars["widgetname"])
+	if widget == nil {
+		return frame.NotFound("There is no widget called " + vars["widgetname"])
+	}
 
-	dashboardPage.Render(w)
+	widget.Render(w, r)
+	return nil
 }
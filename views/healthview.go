@@ -0,0 +1,45 @@
+package views
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nielsvanm/homemanager/health"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// HealthzView reports the health of every component as json, it always
+// responds with 200 as long as the server is running so it can be used as a
+// liveness probe
+func HealthzView(w http.ResponseWriter, r *http.Request) {
+	report := health.Run(r.Context())
+
+	writeHealthReport(w, report, http.StatusOK)
+}
+
+// ReadyzView reports the health of every component as json, it responds with
+// 503 when a critical component such as the database is unavailable
+func ReadyzView(w http.ResponseWriter, r *http.Request) {
+	report := health.Run(r.Context())
+
+	status := http.StatusOK
+	if report.Status == health.StatusDown {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeHealthReport(w, report, status)
+}
+
+func writeHealthReport(w http.ResponseWriter, report health.Report, status int) {
+	resp, err := json.Marshal(report)
+	if err != nil {
+		log.Warn("Health", "Failed to marshal health report", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
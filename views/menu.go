@@ -0,0 +1,31 @@
+package views
+
+import (
+	"github.com/nielsvanm/homemanager/auth"
+	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/plugin"
+)
+
+// DashboardMenu is the sidebar group of the core pages
+var DashboardMenu = frame.MenuGroup{
+	Name: "Dashboard",
+	Icon: "fas fa-tachometer-alt",
+	Items: []frame.MenuItem{
+		{Label: "Home", Path: "/"},
+		{Label: "Statistics", Path: "/stats/"},
+		{Label: "Database", Path: "/database/", Permission: auth.PermManageDatabase},
+		{Label: "Storage", Path: "/storage/", Permission: auth.PermViewStorage},
+		{Label: "Plugins", Path: "/plugins/", Permission: auth.PermViewPlugins},
+		{Label: "Users", Path: "/users/", Permission: auth.PermManageUsers},
+		{Label: "API tokens", Path: "/tokens/", Permission: auth.PermAPITokens},
+		{Label: "API docs", Path: "/apidocs/"},
+	},
+}
+
+// Menu returns the sidebar, the core pages followed by a group for every
+// plugin category
+func Menu() []frame.MenuGroup {
+	groups := []frame.MenuGroup{DashboardMenu}
+
+	return append(groups, plugin.PluginManager.GetMenuGroups()...)
+}
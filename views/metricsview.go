@@ -0,0 +1,27 @@
+package views
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/nielsvanm/homemanager/metrics"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// MetricsTimeout is the time the metrics that are read when they are
+// scraped, such as the size of the catalog, get together
+var MetricsTimeout = 5 * time.Second
+
+// MetricsView writes every registered metric in the Prometheus text format,
+// scrapers authenticate with an api token
+func MetricsView(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), MetricsTimeout)
+	defer cancel()
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	err := metrics.Write(ctx, w)
+	if err != nil {
+		log.FromContext(r.Context()).Warn("Metrics", "Failed to write the metrics", err.Error())
+	}
+}
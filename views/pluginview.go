@@ -0,0 +1,141 @@
+package views
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/nielsvanm/homemanager/auth"
+	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/plugin"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// pluginsLayout lists the plugins and their runs
+var pluginsLayout = frame.NewLayout("base.html", "dashboard/plugins.html")
+
+// pluginRuns is a plugin together with its latest runs as shown on the
+// plugin page
+type pluginRuns struct {
+	Plugin  *plugin.Plugin
+	History []plugin.RunRecord
+}
+
+// PluginsView lists the plugins with their run history and allows running
+// them manually
+func PluginsView(w http.ResponseWriter, r *http.Request) {
+	page := pluginsLayout.NewPage()
+
+	plugins := []pluginRuns{}
+	for _, plug := range plugin.PluginManager.Plugins {
+		plugins = append(plugins, pluginRuns{
+			plug,
+			plugin.PluginManager.GetRunHistory(plug.Name, 5),
+		})
+	}
+	page.AddContext("plugins", plugins)
+	page.AddContext("canrun", frame.IsAuthorized(r, auth.PermRunPlugins))
+
+	page.Render(w, r)
+}
+
+// RunPluginView starts the main function of a plugin in the background and
+// responds with the id of the job
+func RunPluginView(w http.ResponseWriter, r *http.Request) error {
+	plug := plugin.PluginManager.GetPlugin(mux.Vars(r)["pluginname"])
+	if plug == nil {
+		return frame.NotFound("There is no plugin called " + mux.Vars(r)["pluginname"])
+	}
+
+	// The run should outlive this request
+	job := plugin.PluginManager.StartBackgroundRun(plug)
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"id": %d}`, job.ID)
+	return nil
+}
+
+// CancelJobView cancels a running job
+func CancelJobView(w http.ResponseWriter, r *http.Request) error {
+	job, err := getJob(r)
+	if err != nil {
+		return err
+	}
+
+	job.Cancel()
+	return nil
+}
+
+// JobEventsView streams the log lines and status of a job as server-sent
+// events until the job is done
+func JobEventsView(w http.ResponseWriter, r *http.Request) error {
+	job, err := getJob(r)
+	if err != nil {
+		return err
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return frame.InternalError(errors.New("the response writer does not support streaming"))
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	lines, events := job.Subscribe()
+	defer job.Unsubscribe(events)
+
+	// Replay what happened before we subscribed
+	for _, line := range lines {
+		writeEvent(w, plugin.JobEvent{Type: "log", Message: line, Status: plugin.JobRunning})
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case event, open := <-events:
+			if !open {
+				status, summary := job.Result()
+				event = plugin.JobEvent{Type: "done", Message: summary, Status: status}
+			}
+			writeEvent(w, event)
+			flusher.Flush()
+
+			if event.Type == "done" {
+				return nil
+			}
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
+
+// getJob returns the job referenced by the jobid url variable
+func getJob(r *http.Request) (*plugin.Job, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["jobid"])
+	if err != nil {
+		return nil, frame.BadRequest("The job id should be a number", err)
+	}
+
+	job := plugin.PluginManager.GetJob(id)
+	if job == nil {
+		return nil, frame.NotFound("There is no job with id " + strconv.Itoa(id))
+	}
+
+	return job, nil
+}
+
+// writeEvent writes a single server-sent event
+func writeEvent(w http.ResponseWriter, event plugin.JobEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Warn("PluginRunner", "Failed to marshal event", err.Error())
+		return
+	}
+
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+}
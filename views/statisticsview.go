@@ -1,10 +1,12 @@
 package views
 
 import (
+	"context"
 	"net/http"
 	"os"
 	"runtime"
 	"strconv"
+	"time"
 
 	"github.com/nielsvanm/homemanager/plugin"
 	"github.com/nielsvanm/homemanager/tools"
@@ -13,12 +15,31 @@ import (
 	"github.com/nielsvanm/homemanager/frame"
 )
 
+// statisticsLayout shows the server statistics
+var statisticsLayout = frame.NewLayout("base.html", "dashboard/statistics.html")
+
+// StatsTopic publishes the statistics shown on the statistics page every
+// two seconds while the page is open
+var StatsTopic = frame.Topic{
+	Name:     "stats",
+	Sample:   sampleStats,
+	Interval: 2 * time.Second,
+}
+
+// statsSample is a sample of the statistics, sizes are human readable
+type statsSample struct {
+	Processors int    `json:"processors"`
+	Memory     string `json:"memory"`
+	Plugins    int    `json:"plugins"`
+	LogSize    string `json:"log_size"`
+}
+
 func StatisticsView(w http.ResponseWriter, r *http.Request) {
 	// Construct page
-	page := frame.NewPage([]string{"base.html", "dashboard/statistics.html"})
+	page := statisticsLayout.NewPage()
 
 	// Render page
-	page.Render(w)
+	page.Render(w, r)
 }
 
 // ProcessorCountView returns a integer representing the amount of cores available
@@ -49,11 +70,13 @@ func PluginCountView(w http.ResponseWriter, r *http.Request) {
 	)
 }
 
-// LogSizeView writes the size of log.txt to the responsewriter
+// LogSizeView writes the size of the log file to the responsewriter
 func LogSizeView(w http.ResponseWriter, r *http.Request) {
-	f, err := os.Stat("./log.txt")
+	f, err := os.Stat(log.File)
 	if err != nil {
-		log.Warn("Statistics", "Failed to access log.txt", err.Error())
+		log.FromContext(r.Context()).Warn("Statistics", "Failed to access "+log.File, err.Error())
+		w.Write([]byte(tools.ByteCountDecimal(0)))
+		return
 	}
 
 	size := f.Size()
@@ -61,3 +84,21 @@ func LogSizeView(w http.ResponseWriter, r *http.Request) {
 
 	w.Write([]byte(readableSize))
 }
+
+// sampleStats collects the statistics of the statistics page
+func sampleStats(ctx context.Context) (interface{}, error) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	var logSize int64
+	if f, err := os.Stat(log.File); err == nil {
+		logSize = f.Size()
+	}
+
+	return statsSample{
+		Processors: runtime.NumCPU(),
+		Memory:     tools.ByteCountDecimal(int64(m.Alloc)),
+		Plugins:    len(plugin.PluginManager.Plugins),
+		LogSize:    tools.ByteCountDecimal(logSize),
+	}, nil
+}
@@ -0,0 +1,65 @@
+package views
+
+import (
+	"net/http"
+
+	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/plugin"
+	"github.com/nielsvanm/homemanager/tools"
+)
+
+// storageLayout shows the disk usage of the plugins
+var storageLayout = frame.NewLayout("base.html", "dashboard/storage.html")
+
+// pluginStorage is the storage information of a single plugin as shown on
+// the storage page
+type pluginStorage struct {
+	Name      string
+	Dir       string
+	Usage     string
+	SoftQuota string
+	HardQuota string
+	Percent   int64
+	Warning   bool
+	Full      bool
+}
+
+// StorageView shows the disk usage and quotas of every plugin
+func StorageView(w http.ResponseWriter, r *http.Request) {
+	page := storageLayout.NewPage()
+
+	storages := []pluginStorage{}
+	for _, plug := range plugin.PluginManager.Plugins {
+		s := plug.Storage
+		if s == nil {
+			continue
+		}
+
+		// Usage is cached, recalculate it since transmission and friends
+		// write to the folders without us knowing
+		s.Refresh()
+		usage, _ := s.Usage()
+
+		info := pluginStorage{
+			Name:      plug.Name,
+			Dir:       s.Dir(),
+			Usage:     s.ReadableUsage(),
+			SoftQuota: "-",
+			HardQuota: "-",
+			Warning:   s.OverSoftQuota(),
+			Full:      s.OverHardQuota(),
+		}
+		if s.SoftQuota > 0 {
+			info.SoftQuota = tools.ByteCountDecimal(s.SoftQuota)
+		}
+		if s.HardQuota > 0 {
+			info.HardQuota = tools.ByteCountDecimal(s.HardQuota)
+			info.Percent = usage * 100 / s.HardQuota
+		}
+
+		storages = append(storages, info)
+	}
+	page.AddContext("storages", storages)
+
+	page.Render(w, r)
+}
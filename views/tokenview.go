@@ -0,0 +1,106 @@
+package views
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nielsvanm/homemanager/auth"
+	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/middleware"
+	"github.com/nielsvanm/homemanager/plugin"
+)
+
+// tokensLayout lists the api tokens of the user
+var tokensLayout = frame.NewLayout("base.html", "dashboard/tokens.html")
+
+// tokenLifetimes are the expiry options of the token form
+var tokenLifetimes = []struct {
+	Days  int
+	Label string
+}{
+	{30, "30 days"},
+	{90, "90 days"},
+	{365, "1 year"},
+	{0, "Never"},
+}
+
+// TokensView lists the api tokens of the user
+func TokensView(w http.ResponseWriter, r *http.Request) {
+	renderTokens(w, r, http.StatusOK, "", "")
+}
+
+// CreateTokenView creates an api token for the user and shows it once
+func CreateTokenView(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	user := auth.UserFromRequest(r)
+	days, _ := strconv.Atoi(r.PostFormValue("days"))
+
+	// Tokens can't be given permissions the user does not have
+	scopes := r.PostForm["scope"]
+	for _, scope := range scopes {
+		if !strings.HasPrefix(scope, auth.PluginScopePrefix) && !user.HasPermission(scope) {
+			renderTokens(w, r, http.StatusForbidden, "you don't have the permission "+scope, "")
+			return
+		}
+	}
+
+	_, secret, err := auth.CreateToken(user, r.PostFormValue("name"), scopes, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		renderTokens(w, r, http.StatusBadRequest, err.Error(), "")
+		return
+	}
+
+	renderTokens(w, r, http.StatusOK, "", secret)
+}
+
+// RevokeTokenView deletes an api token of the user
+func RevokeTokenView(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(mux.Vars(r)["tokenid"])
+	if err != nil {
+		return frame.NotFound("There is no token with id " + mux.Vars(r)["tokenid"])
+	}
+
+	err = auth.RevokeToken(auth.UserFromRequest(r), id)
+	if err != nil {
+		renderTokens(w, r, http.StatusInternalServerError, err.Error(), "")
+		return nil
+	}
+
+	middleware.Redirect(w, r, "/tokens/", http.StatusSeeOther)
+	return nil
+}
+
+func renderTokens(w http.ResponseWriter, r *http.Request, status int, message, secret string) {
+	page := tokensLayout.NewPage()
+	user := auth.UserFromRequest(r)
+
+	plugins := []string{}
+	for _, plug := range plugin.PluginManager.Plugins {
+		if plug.IsEnabled() {
+			plugins = append(plugins, strings.ToLower(plug.Name))
+		}
+	}
+
+	permissions := []auth.Permission{}
+	for _, perm := range auth.GetPermissions() {
+		if user.HasPermission(perm.Name) {
+			permissions = append(permissions, perm)
+		}
+	}
+
+	page.AddContext("tokens", auth.GetTokens(user))
+	page.AddContext("plugins", plugins)
+	page.AddContext("permissions", permissions)
+	page.AddContext("lifetimes", tokenLifetimes)
+	page.AddContext("secret", secret)
+	if message != "" {
+		page.AddContext("error", message)
+	}
+
+	w.WriteHeader(status)
+	page.Render(w, r)
+}
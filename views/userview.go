@@ -0,0 +1,132 @@
+package views
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/nielsvanm/homemanager/auth"
+	"github.com/nielsvanm/homemanager/frame"
+	"github.com/nielsvanm/homemanager/middleware"
+	"github.com/nielsvanm/homemanager/tools/log"
+)
+
+// usersLayout is the user management page
+var usersLayout = frame.NewLayout("base.html", "dashboard/users.html")
+
+var (
+	errChangeSelf = errors.New("you can't change or delete your own account")
+	errLastAdmin  = errors.New("there has to be at least one admin")
+)
+
+// UsersView lists the users with their roles and the permissions of every
+// role
+func UsersView(w http.ResponseWriter, r *http.Request) {
+	renderUsers(w, r, http.StatusOK, "")
+}
+
+// CreateUserView creates a user with the submitted username, password and
+// role
+func CreateUserView(w http.ResponseWriter, r *http.Request) {
+	username := r.PostFormValue("username")
+	if username == "" {
+		renderUsers(w, r, http.StatusBadRequest, errMissingUsername.Error())
+		return
+	}
+	if auth.GetUserByName(username) != nil {
+		renderUsers(w, r, http.StatusBadRequest, "the username "+username+" is already taken")
+		return
+	}
+
+	_, err := auth.CreateUser(username, r.PostFormValue("password"), r.PostFormValue("role"))
+	if err != nil {
+		renderUsers(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	middleware.Redirect(w, r, "/users/", http.StatusSeeOther)
+}
+
+// UserRoleView assigns the submitted role to a user
+func UserRoleView(w http.ResponseWriter, r *http.Request) error {
+	user, err := getManagedUser(r)
+	if user == nil {
+		return frame.NotFound("There is no user with id " + mux.Vars(r)["userid"])
+	}
+
+	role := r.PostFormValue("role")
+	if err == nil && user.Role == auth.RoleAdmin && role != auth.RoleAdmin && auth.CountAdmins() <= 1 {
+		err = errLastAdmin
+	}
+	if err == nil {
+		err = user.SetRole(role)
+	}
+	if err != nil {
+		renderUsers(w, r, http.StatusBadRequest, err.Error())
+		return nil
+	}
+
+	log.FromContext(r.Context()).Info("Auth", auth.UserFromRequest(r).Username, "made", user.Username, "a", role)
+	middleware.Redirect(w, r, "/users/", http.StatusSeeOther)
+	return nil
+}
+
+// DeleteUserView deletes a user and its sessions
+func DeleteUserView(w http.ResponseWriter, r *http.Request) error {
+	user, err := getManagedUser(r)
+	if user == nil {
+		return frame.NotFound("There is no user with id " + mux.Vars(r)["userid"])
+	}
+
+	if err == nil && user.Role == auth.RoleAdmin && auth.CountAdmins() <= 1 {
+		err = errLastAdmin
+	}
+	if err == nil {
+		err = user.Delete()
+	}
+	if err != nil {
+		renderUsers(w, r, http.StatusBadRequest, err.Error())
+		return nil
+	}
+
+	log.FromContext(r.Context()).Info("Auth", auth.UserFromRequest(r).Username, "deleted", user.Username)
+	middleware.Redirect(w, r, "/users/", http.StatusSeeOther)
+	return nil
+}
+
+// getManagedUser returns the user of the url, with an error when it is the
+// user that is logged in since admins can't lock themselves out
+func getManagedUser(r *http.Request) (*auth.User, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["userid"])
+	if err != nil {
+		return nil, err
+	}
+
+	user := auth.GetUserByID(id)
+	if user == nil {
+		return nil, nil
+	}
+
+	current := auth.UserFromRequest(r)
+	if current != nil && current.ID == user.ID {
+		return user, errChangeSelf
+	}
+
+	return user, nil
+}
+
+func renderUsers(w http.ResponseWriter, r *http.Request, status int, message string) {
+	page := usersLayout.NewPage()
+
+	page.AddContext("users", auth.GetUsers())
+	page.AddContext("roles", auth.Roles)
+	page.AddContext("permissions", auth.GetPermissions())
+	page.AddContext("current", auth.UserFromRequest(r))
+	if message != "" {
+		page.AddContext("error", message)
+	}
+
+	w.WriteHeader(status)
+	page.Render(w, r)
+}